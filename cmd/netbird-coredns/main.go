@@ -1,15 +1,21 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strings"
+	"syscall"
+	"time"
 
 	"netbird-coredns/internal/api"
 	"netbird-coredns/internal/config"
 	"netbird-coredns/internal/logger"
 	"netbird-coredns/internal/process"
 	"netbird-coredns/internal/template"
+	"netbird-coredns/pkg/dns"
 )
 
 const banner = `
@@ -25,13 +31,48 @@ A CoreDNS plugin for managing custom DNS records via API.
 By Christian De Leon (https://github.com/christian-deleon/netbird-coredns)
 `
 
+// netbirdCheckInterval is how often the NetBird connection is checked when
+// NBDNS_DISCONNECTED_RESPONSE=servfail
+const netbirdCheckInterval = 10 * time.Second
+
+// startupSummary is the single JSON event logged once every service is up
+type startupSummary struct {
+	Event            string    `json:"event"`
+	Time             time.Time `json:"time"`
+	StartupSeconds   float64   `json:"startup_seconds"`
+	Mode             string    `json:"mode"`
+	Hostname         string    `json:"hostname"`
+	Domains          []string  `json:"domains"`
+	ForwardTo        string    `json:"forward_to"`
+	Fallthrough      bool      `json:"fallthrough"`
+	DNSPort          int       `json:"dns_port"`
+	APIPort          int       `json:"api_port"`
+	MetricsPort      int       `json:"metrics_port"`
+	BindAddresses    []string  `json:"bind_addresses,omitempty"`
+	RecordsFile      string    `json:"records_file,omitempty"`
+	RecordsDir       string    `json:"records_dir,omitempty"`
+	Records          int       `json:"records"`
+	NetBirdConnected bool      `json:"netbird_connected"`
+	LogLevel         string    `json:"log_level"`
+}
+
 func main() {
+	startedAt := time.Now()
+
 	// Check for help flag
 	if len(os.Args) > 1 && (os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help") {
 		printUsage()
 		os.Exit(0)
 	}
 
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		os.Exit(runQuery(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		os.Exit(runRecord(os.Args[2:]))
+	}
+
 	// Set up panic recovery
 	defer func() {
 		if r := recover(); r != nil {
@@ -62,26 +103,80 @@ func main() {
 	}
 
 	logger.Info("Configuration loaded:")
+	logger.Info("  Mode: %s", cfg.Mode)
 	logger.Info("  Management URL: %s", cfg.ManagementURL)
 	logger.Info("  Hostname: %s", cfg.Hostname)
 	if len(cfg.DNSLabels) > 0 {
 		logger.Info("  DNS Labels: %s", strings.Join(cfg.DNSLabels, ", "))
 	}
 	logger.Info("  Domains: %s", strings.Join(cfg.Domains, ", "))
+	if cfg.AutoDomains {
+		logger.Info("  Auto domains: enabled (NetBird account domain is added after connecting)")
+	}
 	logger.Info("  Forward to: %s", cfg.ForwardTo)
+	switch {
+	case !cfg.Fallthrough:
+		logger.Info("  Fallthrough: disabled (in-zone misses are answered authoritatively)")
+	case len(cfg.FallthroughZones) > 0:
+		logger.Info("  Fallthrough: %s", strings.Join(cfg.FallthroughZones, ", "))
+	}
 	logger.Info("  DNS Port: %d", cfg.DNSPort)
 	logger.Info("  API Port: %d", cfg.APIPort)
+	if cfg.NetBirdInterface != "" {
+		logger.Info("  Bind: NetBird interface %s and loopback", cfg.NetBirdInterface)
+	}
+	if cfg.MetricsPort > 0 {
+		logger.Info("  Metrics Port: %d", cfg.MetricsPort)
+	}
 	logger.Info("  Refresh interval: %d seconds", cfg.RefreshInterval)
-	logger.Info("  Records file: %s", cfg.RecordsFile)
+	if cfg.RecordsDir != "" {
+		logger.Info("  Records directory: %s (sharded by domain)", cfg.RecordsDir)
+	} else {
+		logger.Info("  Records file: %s", cfg.RecordsFile)
+	}
+	if cfg.SeedFile != "" {
+		logger.Info("  Seed records file: %s", cfg.SeedFile)
+	}
+	if cfg.RecordsPublicKey != "" {
+		logger.Info("  Records signature verification: enabled (signing: %t)", cfg.RecordsPrivateKey != "")
+	}
+	if cfg.BackupInterval > 0 {
+		logger.Info("  Backups: every %s to %s (keep: %d)", cfg.BackupInterval, cfg.BackupDir, cfg.BackupKeep)
+	}
 	logger.Info("  Log level: %s", cfg.LogLevel)
 
+	// Fail early with a clear message instead of when CoreDNS exits after NetBird is up
+	if err := checkPrivilegedPorts(cfg); err != nil {
+		logger.Fatal("%v", err)
+	}
+
 	// Initialize DNS records storage
 	logger.Info("Initializing DNS records storage...")
-	storage, err := api.NewStorage(cfg.RecordsFile)
+	storageOpts, err := storageOptions(cfg)
+	if err != nil {
+		logger.Fatal("Invalid records signing configuration: %v", err)
+	}
+	storage, err := api.NewStorageWithOptions(cfg.RecordsFile, storageOpts)
 	if err != nil {
 		logger.Fatal("Failed to initialize storage: %v", err)
 	}
 	logger.Info("DNS records storage initialized")
+	if cfg.RecordsDir == "" && !cfg.FollowSymlink && !cfg.IsFollower() {
+		if info, err := os.Lstat(cfg.RecordsFile); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			logger.Warn("Records file %s is a symlink; writes will fail unless NBDNS_FOLLOW_SYMLINK=true", cfg.RecordsFile)
+		}
+	}
+
+	// Followers never write, so keep the API's view in sync with the leader's writes.
+	// The leader is responsible for purging expired records.
+	if cfg.IsFollower() {
+		go reloadStorage(storage, time.Duration(cfg.RefreshInterval)*time.Second)
+	} else {
+		go purgeExpiredRecords(storage, time.Duration(cfg.RefreshInterval)*time.Second)
+	}
+	if cfg.BackupInterval > 0 {
+		go backupRecords(storage, cfg.BackupDir, cfg.BackupKeep, cfg.BackupInterval)
+	}
 
 	// Note: The plugin is initialized by CoreDNS when it loads the plugin
 	// CoreDNS will create its own plugin instance via plugin.New() which handles
@@ -89,7 +184,7 @@ func main() {
 
 	// Start HTTP API server
 	logger.Info("Starting DNS records API server...")
-	apiServer := api.NewServer(storage, cfg.APIPort)
+	apiServer := api.NewServer(storage, cfg)
 	if err := apiServer.Start(); err != nil {
 		logger.Fatal("Failed to start API server: %v", err)
 	}
@@ -106,6 +201,7 @@ func main() {
 	if err := generator.WriteCorefile(cfg, corefilePath); err != nil {
 		logger.Fatal("Failed to generate Corefile: %v", err)
 	}
+	dumpCorefile(generator, cfg)
 
 	// Print generated Corefile
 	corefileContent, _ := generator.GenerateCorefile(cfg)
@@ -114,6 +210,9 @@ func main() {
 
 	// Create process manager
 	processManager := process.NewManager(cfg)
+	processManager.SetRecordCounter(storage.RecordCounts)
+	apiServer.SetProcessLister(processManager.Processes)
+	processManager.SetAPIStopper(apiServer.Stop)
 
 	// Start NetBird peer registration
 	logger.Info("Starting NetBird peer registration...")
@@ -129,17 +228,80 @@ func main() {
 	logger.Info("NetBird connection established successfully")
 	logger.Info("This DNS service is now discoverable via NetBird DNS")
 
+	// Add the NetBird account domain and regenerate the Corefile before CoreDNS reads it
+	if cfg.AutoDomains {
+		domain, err := processManager.DiscoverNetBirdDomain()
+		switch {
+		case err != nil && len(cfg.Domains) == 0:
+			logger.Fatal("Failed to discover NetBird domain and NBDNS_DOMAINS is empty: %v", err)
+		case err != nil:
+			logger.Warn("Failed to discover NetBird domain, using configured domains only: %v", err)
+		case cfg.AddDomain(domain):
+			logger.Info("Discovered NetBird domain: %s", domain)
+			apiServer.AddDomain(domain)
+			if err := generator.WriteCorefile(cfg, corefilePath); err != nil {
+				logger.Fatal("Failed to regenerate Corefile: %v", err)
+			}
+			dumpCorefile(generator, cfg)
+		default:
+			logger.Info("Discovered NetBird domain %s is already configured", domain)
+		}
+	}
+
+	// Restrict DNS and the API to the NetBird tunnel; loopback stays available for
+	// health checks and the query subcommand
+	if cfg.NetBirdInterface != "" {
+		ip, err := processManager.DiscoverNetBirdIP()
+		if err != nil {
+			logger.Fatal("Failed to find the address of NetBird interface %s: %v", cfg.NetBirdInterface, err)
+		}
+		logger.Info("Binding DNS and API to NetBird interface %s (%s) and loopback", cfg.NetBirdInterface, ip)
+
+		cfg.BindAddresses = []string{ip.String(), "127.0.0.1"}
+		if err := generator.WriteCorefile(cfg, corefilePath); err != nil {
+			logger.Fatal("Failed to regenerate Corefile: %v", err)
+		}
+		dumpCorefile(generator, cfg)
+
+		if err := apiServer.ListenOn(ip.String()); err != nil {
+			logger.Fatal("Failed to start API server on NetBird interface: %v", err)
+		}
+	}
+
+	// Give NetBird time to propagate the DNS labels before CoreDNS starts serving
+	if cfg.CoreDNSStartDelay > 0 {
+		processManager.WaitForDNSLabel(time.Duration(cfg.CoreDNSStartDelay) * time.Second)
+	}
+
 	// Start CoreDNS
 	logger.Info("Starting CoreDNS...")
 	if err := processManager.StartCoreDNS(corefilePath); err != nil {
 		logger.Fatal("Failed to start CoreDNS: %v", err)
 	}
+	apiServer.SetDNSReady()
+
+	// Tell the DNS plugin when NetBird goes down so it can fail queries over to other servers
+	if cfg.DisconnectedResponse == config.DisconnectedServfail {
+		connection := api.NewConnectionStatus(cfg.RecordsFile)
+		if err := connection.Set(true); err != nil {
+			logger.Warn("Failed to reset NetBird connection status: %v", err)
+		}
+		go processManager.MonitorNetBirdConnection(netbirdCheckInterval, func(connected bool) {
+			if err := connection.Set(connected); err != nil {
+				logger.Error("Failed to update NetBird connection status: %v", err)
+			}
+		})
+	}
 
 	logger.Info("All services started successfully")
 	logger.Info("Service is ready and waiting for connections...")
 	logger.Info("  DNS Server: port %d (UDP/TCP)", cfg.DNSPort)
 	logger.Info("  API Server: http://localhost:%d", cfg.APIPort)
-	logger.Info("  Health Check: http://localhost:%d/health", cfg.APIPort)
+	logger.Info("  Health Check: http://localhost:%d%s", cfg.APIPort, cfg.HealthPath)
+	if cfg.MetricsPort > 0 {
+		logger.Info("  Metrics: http://localhost:%d/metrics", cfg.MetricsPort)
+	}
+	logStartupSummary(cfg, storage, processManager, startedAt)
 
 	// Run with signal handling
 	if err := processManager.RunWithSignalHandling(); err != nil {
@@ -149,21 +311,298 @@ func main() {
 	logger.Info("Service shutdown completed successfully")
 }
 
+// logStartupSummary logs the effective configuration, record count and NetBird
+// status as one JSON line, for log pipelines that key on startup
+func logStartupSummary(cfg *config.Config, storage *api.Storage, manager *process.Manager, startedAt time.Time) {
+	records := 0
+	for _, count := range storage.RecordCounts() {
+		records += count
+	}
+	connected, err := manager.NetBirdConnected()
+	if err != nil {
+		logger.Debug("Failed to check NetBird status for the startup summary: %v", err)
+	}
+
+	summary := startupSummary{
+		Event:            "startup_complete",
+		Time:             time.Now().UTC(),
+		StartupSeconds:   time.Since(startedAt).Seconds(),
+		Mode:             cfg.Mode,
+		Hostname:         cfg.Hostname,
+		Domains:          cfg.Domains,
+		ForwardTo:        cfg.ForwardTo,
+		Fallthrough:      cfg.Fallthrough,
+		DNSPort:          cfg.DNSPort,
+		APIPort:          cfg.APIPort,
+		MetricsPort:      cfg.MetricsPort,
+		BindAddresses:    cfg.BindAddresses,
+		Records:          records,
+		NetBirdConnected: connected,
+		LogLevel:         cfg.LogLevel,
+	}
+	if cfg.RecordsDir != "" {
+		summary.RecordsDir = cfg.RecordsDir
+	} else {
+		summary.RecordsFile = cfg.RecordsFile
+	}
+	logger.Event(summary)
+}
+
+// storageOptions builds the storage options from the configuration
+func storageOptions(cfg *config.Config) (api.StorageOptions, error) {
+	opts := api.StorageOptions{
+		ShardDir:              cfg.RecordsDir,
+		SeedFile:              cfg.SeedFile,
+		SaveRetries:           cfg.SaveRetries,
+		RejectApex:            cfg.ApexRecords == config.ApexRecordsReject,
+		RejectDuplicateValues: !cfg.DedupValues,
+		FollowSymlinks:        cfg.FollowSymlink,
+		Limits:                dns.Limits{MaxNameLength: cfg.MaxNameLength, MaxValueLength: cfg.MaxValueLength},
+		// Followers never write, so only the leader upgrades old records files
+		Migrate: !cfg.IsFollower(),
+	}
+	if cfg.SoftDelete {
+		opts.SoftDeleteRetention = cfg.SoftDeleteRetention
+	}
+
+	if cfg.RecordsPublicKey != "" {
+		publicKey, err := api.ParsePublicKey(cfg.RecordsPublicKey)
+		if err != nil {
+			return opts, err
+		}
+		opts.PublicKey = publicKey
+	}
+
+	if cfg.RecordsPrivateKey != "" {
+		privateKey, err := api.ParsePrivateKey(cfg.RecordsPrivateKey)
+		if err != nil {
+			return opts, err
+		}
+		if !privateKey.Public().(ed25519.PublicKey).Equal(opts.PublicKey) {
+			return opts, fmt.Errorf("NBDNS_RECORDS_PRIVKEY does not match NBDNS_RECORDS_PUBKEY")
+		}
+		opts.PrivateKey = privateKey
+	}
+
+	return opts, nil
+}
+
+// checkPrivilegedPorts verifies that the ports below 1024 this service listens on
+// can be bound, which requires root or CAP_NET_BIND_SERVICE
+func checkPrivilegedPorts(cfg *config.Config) error {
+	type listener struct {
+		name    string
+		network string
+		port    int
+	}
+	listeners := []listener{
+		{"DNS", "udp", cfg.DNSPort},
+		{"DNS", "tcp", cfg.DNSPort},
+		{"API", "tcp", cfg.APIPort},
+		{"metrics", "tcp", cfg.MetricsPort},
+	}
+
+	for _, l := range listeners {
+		if l.port <= 0 || l.port >= 1024 {
+			continue
+		}
+
+		addr := fmt.Sprintf(":%d", l.port)
+		var err error
+		if l.network == "udp" {
+			var conn net.PacketConn
+			if conn, err = net.ListenPacket(l.network, addr); err == nil {
+				conn.Close()
+			}
+		} else {
+			var ln net.Listener
+			if ln, err = net.Listen(l.network, addr); err == nil {
+				ln.Close()
+			}
+		}
+
+		switch {
+		case err == nil:
+			logger.Debug("%s port %d/%s can be bound", l.name, l.port, l.network)
+		case errors.Is(err, syscall.EACCES):
+			return fmt.Errorf("cannot bind %s port %d/%s: permission denied. Ports below 1024 need root or the "+
+				"CAP_NET_BIND_SERVICE capability: add `cap_add: [NET_BIND_SERVICE]` in Docker Compose, "+
+				"run `setcap cap_net_bind_service=+ep` on the binary, or choose a port of 1024 or above", l.name, l.port, l.network)
+		case errors.Is(err, syscall.EADDRINUSE):
+			return fmt.Errorf("cannot bind %s port %d/%s: address already in use. Stop the service holding it "+
+				"(for port 53 often the systemd-resolved stub listener) or choose another port", l.name, l.port, l.network)
+		default:
+			return fmt.Errorf("cannot bind %s port %d/%s: %w", l.name, l.port, l.network, err)
+		}
+	}
+
+	return nil
+}
+
+// reloadStorage periodically reloads the records file written by another instance
+func reloadStorage(storage *api.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := storage.Reload(); err != nil {
+			logger.Error("Failed to reload records from disk: %v", err)
+		} else {
+			logger.Debug("Reloaded records from disk")
+		}
+	}
+}
+
+// purgeExpiredRecords periodically removes records whose expiry time has passed,
+// and soft-deleted records whose retention window has
+func purgeExpiredRecords(storage *api.Storage, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := storage.PurgeExpired()
+		if err != nil {
+			logger.Error("Failed to purge expired records: %v", err)
+		} else if removed > 0 {
+			logger.Info("Purged %d expired record(s)", removed)
+		}
+
+		removed, err = storage.PurgeDeleted()
+		if err != nil {
+			logger.Error("Failed to purge deleted records: %v", err)
+		} else if removed > 0 {
+			logger.Info("Purged %d deleted record(s)", removed)
+		}
+	}
+}
+
+// dumpCorefile writes a copy of the generated Corefile to NBDNS_COREFILE_DUMP_PATH.
+// The copy is only for troubleshooting, so a failed write is not fatal.
+func dumpCorefile(generator *template.Generator, cfg *config.Config) {
+	if cfg.CorefileDumpPath == "" {
+		return
+	}
+	if err := generator.WriteCorefile(cfg, cfg.CorefileDumpPath); err != nil {
+		logger.Warn("Failed to write Corefile copy to %s: %v", cfg.CorefileDumpPath, err)
+		return
+	}
+	logger.Debug("Wrote Corefile copy to %s", cfg.CorefileDumpPath)
+}
+
+// backupRecords periodically snapshots the records to dir, keeping the newest keep
+func backupRecords(storage *api.Storage, dir string, keep int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		path, err := storage.Snapshot(dir, keep)
+		switch {
+		case err != nil && path == "":
+			logger.Error("Failed to back up records: %v", err)
+		case err != nil:
+			logger.Warn("Backed up records to %s, but failed to prune old backups: %v", path, err)
+		default:
+			logger.Debug("Backed up records to %s", path)
+		}
+	}
+}
+
 func printUsage() {
-	fmt.Fprintf(os.Stderr, `Usage: %s
+	fmt.Fprintf(os.Stderr, `Usage: %s [command]
+
+Commands:
+  (none)                  Start the netbird-coredns service
+  query <name> [type]     Query the local DNS server and print the response (type defaults to A)
+  record list|add|delete  List, add or delete records in the records file or via --api (see record --help)
 
-Environment Variables (all prefixed with NBDNS_):
-  NBDNS_DOMAINS           Comma-separated domains for DNS resolution (required)
-  NBDNS_SETUP_KEY         NetBird setup key for peer registration (required)
+Environment Variables (all prefixed with NBDNS_, each may also be read from the file named by <VAR>_FILE):
+  NBDNS_DOMAINS           Comma-separated domains for DNS resolution (required unless NBDNS_AUTO_DOMAINS is set)
+  NBDNS_AUTO_DOMAINS      Add the NetBird account's DNS domain to the domain list (default: false)
+  NBDNS_DEFAULT_DOMAIN    Domain of records created without one (default: the only configured domain)
+  NBDNS_SETUP_KEY         NetBird setup key, required for the first join only
   NBDNS_MANAGEMENT_URL    NetBird Management server URL (default: https://api.netbird.io)
   NBDNS_HOSTNAME          Hostname for NetBird peer (default: nb-dns)
+  NBDNS_NETBIRD_INTERFACE Listen for DNS and API only on this NetBird interface and loopback, e.g. wt0
   NBDNS_DNS_LABELS        DNS labels for service discovery (default: nb-dns)
   NBDNS_FORWARD_TO        Forward server for unresolved queries (default: 8.8.8.8)
+  NBDNS_UPSTREAM_TIMEOUT  Time DNS queries sent by the service wait for an answer, e.g. 5s (default: 2s)
+  NBDNS_FALLTHROUGH       Forward in-zone queries without a custom record: true, false or zones (default: true)
   NBDNS_DNS_PORT          DNS server port (default: 5053)
+  NBDNS_CHAOS_VERSION     Answer version.bind CHAOS TXT queries with this string (default: not answered)
+  NBDNS_DNS_COMPRESS      Use DNS name compression in responses (default: true)
+  NBDNS_A_QUERY_ORDER     A queries at a name with both records: cname-first or a-first (default: cname-first)
+  NBDNS_ANSWER_ORDER      Order of multi-value A answers: fixed, shuffle or roundrobin (default: fixed)
+  NBDNS_ZONE_NS           Comma-separated nameservers answered for NS queries at the zone apex
+  NBDNS_SOA_MNAME         Primary nameserver of the SOA in negative answers (default: first zone NS or ns.<zone>)
+  NBDNS_SOA_RNAME         Mailbox of the SOA in negative answers (default: hostmaster.<zone>)
+  NBDNS_SOA_SERIAL        Serial of the SOA in negative answers (default: 1)
+  NBDNS_NEGATIVE_TTL      Seconds NXDOMAIN and NODATA answers may be cached, 0-86400 (default: 60)
+  NBDNS_AUTO_PTR          Answer PTR queries from in-zone A records (default: false)
+  NBDNS_TTL_MIN           Lowest TTL served for custom records, 0 for none (default: 0)
+  NBDNS_TTL_MAX           Highest TTL served for custom records, 0 for none (default: 0)
+  NBDNS_TTL_JITTER        Spread served TTLs randomly by up to this percentage, 0-50 (default: 0)
+  NBDNS_FORCE_TCP_OVER_BYTES
+                          Truncate UDP responses over this size so clients use TCP, 0 to disable (default: 0)
+  NBDNS_CHAOS             Allow chaos testing settings such as NBDNS_RESPONSE_DELAY (default: false)
+  NBDNS_RESPONSE_DELAY    Testing only: delay every DNS response, e.g. 200ms, 0-30s (default: 0)
+  NBDNS_VALIDATE_ON_RELOAD
+                          Validate records on every reload and skip invalid ones (default: false)
+  NBDNS_TOP_RECORDS       Per-record hit metrics for this many of the most queried records (default: 0, disabled)
+  NBDNS_QUERY_ACL         CIDR=allow|deny rules for in-zone queries; most specific CIDR wins
+  NBDNS_MAX_CONCURRENT_QUERIES
+                          Maximum concurrent in-zone lookups, extra queries get SERVFAIL (default: 0, unlimited)
+  NBDNS_RRL_RATE          UDP responses/sec per client prefix before truncating, 0 to disable (default: 0)
   NBDNS_API_PORT          API server port (default: 8080)
+  NBDNS_API_ACCESS_LOG    Log every API request as a JSON line (default: false)
+  NBDNS_CORS_ORIGINS      Browser origins allowed to call the API, * for any (default: none)
+  NBDNS_TRUSTED_PROXIES   CIDRs of reverse proxies whose X-Forwarded-For is trusted for the client address
+  NBDNS_API_WAIT_FOR_DNS  Reject API writes with 503 until CoreDNS has started (default: false)
+  NBDNS_HEALTH_PATH       Health check path, /health stays available (default: /health)
+  NBDNS_HEALTH_BODY       Plain-text health check body (default: JSON {"status":"ok"})
+  NBDNS_HEALTH_STATUS     Health check status code (default: 200)
+  NBDNS_API_H2C           Also serve the API over cleartext HTTP/2 (default: false)
+  NBDNS_API_DRAIN_TIMEOUT Time in-flight API requests may finish on shutdown, e.g. 10s (default: 5s)
+  NBDNS_SOFT_DELETE       Keep deleted records restorable (default: false)
+  NBDNS_SOFT_DELETE_RETENTION
+                          How long soft-deleted records can be restored, e.g. 72h (default: 24h)
+  NBDNS_API_MAX_CONCURRENT
+                          Maximum in-flight API mutations, 0 for unlimited (default: 0)
+  NBDNS_METRICS_PORT      Prometheus metrics port, 0 to disable (default: 0)
   NBDNS_REFRESH_INTERVAL  Refresh interval in seconds (default: 15)
+  NBDNS_COREDNS_START_RETRIES
+                          Retries when the DNS port is still in use at startup (default: 3)
+  NBDNS_COREDNS_START_DELAY
+                          Max seconds to wait for the DNS label to resolve before starting CoreDNS (default: 0)
+  NBDNS_COREDNS_RELOAD    Hot-reload the Corefile on change with the CoreDNS reload plugin (default: false)
+  NBDNS_COREDNS_RELOAD_INTERVAL
+                          Seconds between Corefile change checks, at least 2 (default: 30)
+  NBDNS_COREDNS_ERRORS_CONSOLIDATE
+                          Consolidate repeated CoreDNS errors: DURATION [REGEXP], e.g. 1m (default: disabled)
+  NBDNS_COREDNS_ERRORS_STACKTRACE
+                          Log stack traces of recovered CoreDNS panics (default: false)
+  NBDNS_DNS_REUSEPORT     Share the DNS port via SO_REUSEPORT: true (GOMAXPROCS servers) or a count (default: false)
+  NBDNS_COREFILE_DUMP_PATH
+                          Also write the generated Corefile here for troubleshooting
   NBDNS_RECORDS_FILE      Path to DNS records file (default: /etc/nb-dns/records/records.json)
+  NBDNS_RECORDS_DIR       Store records as one <domain>.json file per domain in this directory
+  NBDNS_SEED_RECORDS_FILE Read-only records that are always served and cannot be changed via the API
+  NBDNS_BACKUP_DIR        Directory for snapshots created via POST /api/v1/snapshot
+  NBDNS_BACKUP_KEEP       Number of snapshots to keep, 0 to keep all (default: 0)
+  NBDNS_BACKUP_RETAIN     Alias for NBDNS_BACKUP_KEEP
+  NBDNS_BACKUP_INTERVAL   Take a snapshot every interval, e.g. 1h (default: 0, disabled)
+  NBDNS_RECORDS_PUBKEY    Base64 ed25519 public key used to verify the records file signature
+  NBDNS_RECORDS_PRIVKEY   Base64 ed25519 private key used to re-sign the records file on save
+  NBDNS_APEX_RECORDS      Whether records may use an empty or @ name: allow or reject (default: allow)
+  NBDNS_DISCONNECTED_RESPONSE
+                          Answer in-zone queries while NetBird is down: serve or servfail (default: serve)
+  NBDNS_DEDUP_VALUES      Drop repeated values of multi-value records; false rejects them (default: true)
+  NBDNS_FOLLOW_SYMLINK    Write a symlinked records file through to its target instead of failing (default: false)
+  NBDNS_SAVE_RETRIES      Retries of a failed records write before the change is rolled back, 0-10 (default: 2)
+  NBDNS_MAX_NAME_LEN      Longest record name or alias accepted, at most 253 (default: 253)
+  NBDNS_MAX_VALUE_LEN     Longest record value accepted, at most 4096 (default: 4096)
+  NBDNS_CHILD_OUTPUT      How NetBird and CoreDNS output is logged: log or raw (default: log)
   NBDNS_LOG_LEVEL         Log level for the entire service (default: info)
+  NBDNS_MODE              Instance mode: leader or follower (default: leader)
 
 `, os.Args[0])
 }
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"netbird-coredns/internal/config"
+)
+
+// runQuery implements the "query" subcommand: it sends a single DNS query to the
+// local CoreDNS instance and prints the response. It returns the process exit code.
+func runQuery(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s query <name> [type]\n", os.Args[0])
+		return 2
+	}
+
+	name := dns.Fqdn(args[0])
+	qtype := dns.TypeA
+	if len(args) == 2 {
+		t, ok := dns.StringToType[strings.ToUpper(args[1])]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown query type: %s\n", args[1])
+			return 2
+		}
+		qtype = t
+	}
+
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	server := net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.DNSPort))
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+
+	client := &dns.Client{Timeout: cfg.UpstreamTimeout}
+	resp, rtt, err := client.Exchange(m, server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Query to %s failed: %v\n", server, err)
+		return 1
+	}
+
+	fmt.Printf(";; SERVER: %s\n", server)
+	fmt.Printf(";; QUERY: %s %s\n", name, dns.TypeToString[qtype])
+	fmt.Printf(";; RCODE: %s\n", dns.RcodeToString[resp.Rcode])
+	fmt.Printf(";; Query time: %v\n", rtt.Round(time.Millisecond))
+
+	fmt.Println()
+	fmt.Println(";; ANSWER SECTION:")
+	for _, rr := range resp.Answer {
+		fmt.Println(rr.String())
+	}
+
+	if resp.Rcode != dns.RcodeSuccess {
+		return 1
+	}
+	return 0
+}
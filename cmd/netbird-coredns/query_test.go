@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQueryTimesOutOnSilentServer(t *testing.T) {
+	// A UDP listener that reads queries but never answers them
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	timeout := 200 * time.Millisecond
+	t.Setenv("NBDNS_DOMAINS", "example.com")
+	t.Setenv("NBDNS_SETUP_KEY", "test-key")
+	t.Setenv("NBDNS_DNS_PORT", strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port))
+	t.Setenv("NBDNS_UPSTREAM_TIMEOUT", timeout.String())
+
+	start := time.Now()
+	code := runQuery([]string{"web.example.com"})
+	elapsed := time.Since(start)
+
+	if code != 1 {
+		t.Errorf("runQuery() = %d, want 1", code)
+	}
+	if elapsed > timeout+time.Second {
+		t.Errorf("runQuery() took %v, want at most %v", elapsed, timeout)
+	}
+}
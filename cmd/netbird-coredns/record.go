@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"netbird-coredns/internal/api"
+	"netbird-coredns/internal/config"
+	"netbird-coredns/pkg/dns"
+)
+
+const recordUsage = `Usage: %[1]s record <command> [flags]
+
+Commands:
+  list [domain]                          List records, optionally of one domain
+  add <domain> <name> <type> <value>     Create or update a record (use @ for the root domain)
+  delete <domain> <name> [type]          Delete a record, or every record of the name without a type
+
+Flags:
+  --json      Print JSON instead of a table
+  --api       Go through the local API instead of the records file
+  --ttl N     TTL of an added record in seconds (default: 60)
+
+Without --api the records file (or NBDNS_RECORDS_DIR) is changed directly. Use
+--api while the service is running, so its in-memory records stay current.
+`
+
+// recordBackend reads and changes records either in the records file or
+// through the local API
+type recordBackend interface {
+	list(domain string) ([]*dns.Record, error)
+	add(record *dns.Record) (*dns.Record, error)
+	delete(domain, name string, recordType dns.RecordType) ([]*dns.Record, error)
+}
+
+// runRecord implements the "record" subcommand. It returns the process exit code.
+func runRecord(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, recordUsage, os.Args[0])
+		return 2
+	}
+	command := args[0]
+	if command == "-h" || command == "--help" || command == "help" {
+		fmt.Fprintf(os.Stderr, recordUsage, os.Args[0])
+		return 0
+	}
+
+	flags := flag.NewFlagSet("record "+command, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	asJSON := flags.Bool("json", false, "")
+	viaAPI := flags.Bool("api", false, "")
+	ttl := flags.Uint("ttl", 0, "")
+	positional, err := parseInterspersed(flags, args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		fmt.Fprintf(os.Stderr, recordUsage, os.Args[0])
+		return 2
+	}
+
+	var minArgs, maxArgs int
+	switch command {
+	case "list":
+		minArgs, maxArgs = 0, 1
+	case "add":
+		minArgs, maxArgs = 4, 4
+	case "delete":
+		minArgs, maxArgs = 2, 3
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown record command: %s\n\n", command)
+		fmt.Fprintf(os.Stderr, recordUsage, os.Args[0])
+		return 2
+	}
+	if len(positional) < minArgs || len(positional) > maxArgs {
+		fmt.Fprintf(os.Stderr, recordUsage, os.Args[0])
+		return 2
+	}
+
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	var backend recordBackend
+	if *viaAPI {
+		backend = &apiRecords{
+			baseURL: fmt.Sprintf("http://127.0.0.1:%d", cfg.APIPort),
+			client:  &http.Client{Timeout: 10 * time.Second},
+		}
+	} else {
+		opts, err := storageOptions(cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid storage configuration: %v\n", err)
+			return 1
+		}
+		storage, err := api.NewStorageWithOptions(cfg.RecordsFile, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open records: %v\n", err)
+			return 1
+		}
+		backend = &fileRecords{storage: storage}
+	}
+
+	var records []*dns.Record
+	switch command {
+	case "list":
+		domain := ""
+		if len(positional) == 1 {
+			domain = positional[0]
+		}
+		records, err = backend.list(domain)
+	case "add":
+		recordType, typeErr := dns.ParseRecordType(positional[2])
+		if typeErr != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", typeErr)
+			return 2
+		}
+		var record *dns.Record
+		record, err = backend.add(&dns.Record{
+			Domain: positional[0],
+			Name:   positional[1],
+			Type:   recordType,
+			Value:  positional[3],
+			TTL:    uint32(*ttl),
+		})
+		if err == nil {
+			records = []*dns.Record{record}
+		}
+	case "delete":
+		var recordType dns.RecordType
+		if len(positional) == 3 {
+			if recordType, err = dns.ParseRecordType(positional[2]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 2
+			}
+		}
+		records, err = backend.delete(positional[0], positional[1], recordType)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to %s records: %v\n", command, err)
+		return 1
+	}
+
+	sortRecords(records)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(records); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode records: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	printRecordTable(records)
+	return 0
+}
+
+// parseInterspersed parses flags that may appear before, between or after the
+// positional arguments, which the flag package alone stops at
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// sortRecords orders records by domain, name and type
+func sortRecords(records []*dns.Record) {
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+}
+
+// printRecordTable prints records as an aligned table
+func printRecordTable(records []*dns.Record) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tNAME\tTYPE\tTTL\tVALUE")
+	for _, record := range records {
+		name := record.Name
+		if name == "" {
+			name = "@"
+		}
+		value := record.Value
+		if len(record.Values) > 0 {
+			value = strings.Join(record.Values, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", record.Domain, name, record.Type, record.TTL, value)
+	}
+	w.Flush()
+}
+
+// fileRecords changes the records file directly, holding its lock while writing
+type fileRecords struct {
+	storage *api.Storage
+}
+
+func (f *fileRecords) list(domain string) ([]*dns.Record, error) {
+	if domain != "" {
+		return flattenRecords(map[string]map[string]dns.RecordSet{domain: f.storage.ListRecordsByDomain(domain)}), nil
+	}
+	return flattenRecords(f.storage.ListRecords()), nil
+}
+
+func (f *fileRecords) add(record *dns.Record) (*dns.Record, error) {
+	if err := f.storage.SetRecord(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (f *fileRecords) delete(domain, name string, recordType dns.RecordType) ([]*dns.Record, error) {
+	return f.storage.DeleteRecord(domain, name, recordType)
+}
+
+// apiRecords changes records through the API of the running service
+type apiRecords struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (a *apiRecords) list(domain string) ([]*dns.Record, error) {
+	var records map[string]map[string]dns.RecordSet
+	if err := a.do(http.MethodGet, "/api/v1/records", nil, &records); err != nil {
+		return nil, err
+	}
+	if domain != "" {
+		records = map[string]map[string]dns.RecordSet{dns.TrimDot(domain): records[dns.TrimDot(domain)]}
+	}
+	return flattenRecords(records), nil
+}
+
+func (a *apiRecords) add(record *dns.Record) (*dns.Record, error) {
+	var response struct {
+		Record *dns.Record `json:"record"`
+	}
+	if err := a.do(http.MethodPost, "/api/v1/records", record, &response); err != nil {
+		return nil, err
+	}
+	return response.Record, nil
+}
+
+func (a *apiRecords) delete(domain, name string, recordType dns.RecordType) ([]*dns.Record, error) {
+	if name == "" {
+		name = "@"
+	}
+	path := "/api/v1/records/" + url.PathEscape(domain) + "/" + url.PathEscape(name)
+	if recordType != "" {
+		path += "?type=" + url.QueryEscape(string(recordType))
+	}
+
+	var response struct {
+		Record dns.RecordSet `json:"record"`
+	}
+	if err := a.do(http.MethodDelete, path, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Record, nil
+}
+
+// do sends a request to the API and decodes a successful JSON response into out.
+// Error responses are returned with their plain-text message.
+func (a *apiRecords) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, a.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// flattenRecords lists the records of a domain -> name -> records map
+func flattenRecords(records map[string]map[string]dns.RecordSet) []*dns.Record {
+	flat := []*dns.Record{}
+	for _, domainRecords := range records {
+		for _, set := range domainRecords {
+			flat = append(flat, set...)
+		}
+	}
+	return flat
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// Problems reported by a records audit
+const (
+	// ProblemInvalid marks a record that fails the validation applied on write
+	ProblemInvalid = "invalid"
+	// ProblemCNAMEConflict marks a CNAME sharing its name with other records
+	ProblemCNAMEConflict = "cname_conflict"
+	// ProblemAliasConflict marks an alias clashing with a name or another alias
+	ProblemAliasConflict = "alias_conflict"
+	// ProblemDanglingCNAME marks a CNAME whose in-zone target has no records
+	ProblemDanglingCNAME = "dangling_cname"
+)
+
+// Problem is an issue found in a stored record
+type Problem struct {
+	Domain  string         `json:"domain"`
+	Name    string         `json:"name"`
+	Type    dns.RecordType `json:"type"`
+	Problem string         `json:"problem"`
+	Detail  string         `json:"detail"`
+}
+
+// Audit checks every stored record against the validation applied on write and
+// against the other records: CNAME exclusivity, clashing aliases and CNAMEs
+// pointing at in-zone names that do not exist. Records files edited by hand or
+// written by older versions can hold records the API would reject. domains are
+// the configured domains that decide whether a CNAME target is in zone.
+func (s *Storage) Audit(domains []string) []Problem {
+	records := s.ListRecords()
+	problems := []Problem{}
+	report := func(record *dns.Record, problem, detail string) {
+		problems = append(problems, Problem{Domain: record.Domain, Name: record.Name, Type: record.Type, Problem: problem, Detail: detail})
+	}
+
+	for _, domainRecords := range records {
+		for _, set := range domainRecords {
+			for _, record := range set {
+				if err := record.ValidateWithin(s.limits); err != nil {
+					report(record, ProblemInvalid, err.Error())
+				} else if duplicates := record.DuplicateValues(); len(duplicates) > 0 {
+					report(record, ProblemInvalid, "duplicate values: "+strings.Join(duplicates, ", "))
+				}
+
+				if record.Type == dns.RecordTypeCNAME && len(set) > 1 {
+					if err := set.Without(func(r *dns.Record) bool { return r == record }).CheckConflict(record.Type); err != nil {
+						report(record, ProblemCNAMEConflict, err.Error())
+					}
+				}
+
+				if err := checkAliases(domainRecords, record); err != nil {
+					report(record, ProblemAliasConflict, err.Error())
+				}
+
+				if record.Type == dns.RecordTypeCNAME {
+					for _, target := range cnameTargets(record) {
+						if s.dangling(domains, target) {
+							report(record, ProblemDanglingCNAME, "in-zone target "+target+" has no records")
+						}
+					}
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+	return problems
+}
+
+// cnameTargets lists the distinct targets of a CNAME record, including its views
+func cnameTargets(record *dns.Record) []string {
+	targets := []string{strings.ToLower(record.Value)}
+	for _, view := range record.Views {
+		target := strings.ToLower(view.Value)
+		if target != "" && !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// dangling reports whether target falls under one of domains yet neither has
+// records of its own nor is covered by a catch-all record
+func (s *Storage) dangling(domains []string, target string) bool {
+	if !InZone(domains, target) {
+		return false
+	}
+	if domain, name, ok := SplitName(domains, target); ok {
+		if _, err := s.GetRecords(domain, name); err == nil {
+			return false
+		}
+	}
+	_, _, ok := s.DefaultRecord(domains, target)
+	return !ok
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the address of the client that sent r. The X-Forwarded-For
+// header is only believed when the immediate peer is one of the trusted proxies;
+// it is then read from the right, skipping further trusted proxies, so a client
+// cannot spoof its address by sending the header itself.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrustedProxy(net.ParseIP(peer), trusted) {
+		return peer
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	client := peer
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			// A malformed hop ends the chain; the last trusted hop is the best we know
+			break
+		}
+		client = ip.String()
+		if !isTrustedProxy(ip, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+// isTrustedProxy reports whether ip falls within one of the trusted networks
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
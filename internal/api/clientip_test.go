@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "fd00::/8"} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		trusted   []*net.IPNet
+		want      string
+	}{
+		{"no proxies trusted", "100.64.0.5:4321", nil, nil, "100.64.0.5"},
+		{"header ignored without trusted proxies", "10.0.0.2:4321", []string{"100.64.0.5"}, nil, "10.0.0.2"},
+		{"untrusted peer cannot spoof", "100.64.0.9:4321", []string{"100.64.0.5"}, trusted, "100.64.0.9"},
+		{"trusted peer without header", "10.0.0.2:4321", nil, trusted, "10.0.0.2"},
+		{"trusted peer", "10.0.0.2:4321", []string{"100.64.0.5"}, trusted, "100.64.0.5"},
+		{"chain of trusted proxies", "10.0.0.2:4321", []string{"100.64.0.5, 10.1.1.1, 10.2.2.2"}, trusted, "100.64.0.5"},
+		{"spoofed entry left of the client", "10.0.0.2:4321", []string{"1.2.3.4, 100.64.0.5"}, trusted, "100.64.0.5"},
+		{"header repeated", "10.0.0.2:4321", []string{"1.2.3.4", "100.64.0.5, 10.1.1.1"}, trusted, "100.64.0.5"},
+		{"malformed hop", "10.0.0.2:4321", []string{"100.64.0.5, garbage, 10.1.1.1"}, trusted, "10.1.1.1"},
+		{"IPv6 trusted peer", "[fd00::2]:4321", []string{"2001:db8::7"}, trusted, "2001:db8::7"},
+		{"IPv6 untrusted peer", "[2001:db8::9]:4321", []string{"2001:db8::7"}, trusted, "2001:db8::9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/v1/records", nil)
+			r.RemoteAddr = tt.peer
+			for _, header := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", header)
+			}
+			if got := clientIP(r, tt.trusted); got != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
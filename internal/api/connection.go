@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// connectionCheckInterval limits how often the marker file is checked on the query path
+const connectionCheckInterval = time.Second
+
+// ConnectionStatus is the NetBird connection state shared between the process
+// manager and the DNS plugin. Like Maintenance, it is a marker file next to the
+// records file, present while NetBird is disconnected. A missing marker means
+// connected, so a stopped monitor never leaves DNS failing.
+type ConnectionStatus struct {
+	path string
+
+	mu           sync.Mutex
+	disconnected bool
+	checkedAt    time.Time
+}
+
+// NewConnectionStatus creates a connection status for the given records file
+func NewConnectionStatus(recordsFile string) *ConnectionStatus {
+	return &ConnectionStatus{
+		path: recordsFile + ".disconnected",
+	}
+}
+
+// Set records whether NetBird is connected
+func (c *ConnectionStatus) Set(connected bool) error {
+	if connected {
+		if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove disconnected marker: %w", err)
+		}
+		return nil
+	}
+
+	data := []byte(time.Now().UTC().Format(time.RFC3339) + "\n")
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write disconnected marker: %w", err)
+	}
+	return nil
+}
+
+// Connected reports whether NetBird is connected, checking the marker file at most once per second
+func (c *ConnectionStatus) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.checkedAt) >= connectionCheckInterval {
+		_, err := os.Stat(c.path)
+		c.disconnected = err == nil
+		c.checkedAt = now
+	}
+
+	return !c.disconnected
+}
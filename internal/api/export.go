@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+	"go.yaml.in/yaml/v2"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// Export formats
+const (
+	ExportJSON = "json"
+	ExportYAML = "yaml"
+	ExportZone = "zone"
+	// ExportDnsmasq and ExportHosts only hold the record types they can represent
+	ExportDnsmasq = "dnsmasq"
+	ExportHosts   = "hosts"
+)
+
+// labelsField is the custom record field holding the labels matched by an
+// export filter, e.g. "labels": {"env": "prod"}
+const labelsField = "labels"
+
+// ExportFilter selects the records of an export. Empty fields match every record.
+type ExportFilter struct {
+	Domain string
+	Type   dns.RecordType
+	// Labels must all be present with the given values in the record's labels field
+	Labels map[string]string
+}
+
+// Export returns the unexpired records matching filter, ordered by domain, name and type
+func (s *Storage) Export(filter ExportFilter) []*dns.Record {
+	var records map[string]map[string]dns.RecordSet
+	if filter.Domain != "" {
+		domain := dns.TrimDot(filter.Domain)
+		records = map[string]map[string]dns.RecordSet{domain: s.ListRecordsByDomain(domain)}
+	} else {
+		records = s.ListRecords()
+	}
+
+	matched := []*dns.Record{}
+	for _, domainRecords := range records {
+		for _, set := range domainRecords {
+			for _, record := range set {
+				if filter.matches(record) {
+					matched = append(matched, record)
+				}
+			}
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+	return matched
+}
+
+// matches reports whether record passes the type and label filters
+func (f ExportFilter) matches(record *dns.Record) bool {
+	if f.Type != "" && record.Type != f.Type {
+		return false
+	}
+	if len(f.Labels) == 0 {
+		return true
+	}
+
+	var labels map[string]string
+	if raw, ok := record.Extra[labelsField]; !ok || json.Unmarshal(raw, &labels) != nil {
+		return false
+	}
+	for key, value := range f.Labels {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabelFilters parses label filters of the form key=value
+func ParseLabelFilters(filters []string) (map[string]string, error) {
+	labels := make(map[string]string, len(filters))
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label filter %q, expected key=value", filter)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// WriteExport writes records to w in the given format. JSON and YAML list the
+// records in the bulk upsert format, so an export can be loaded back with
+// PUT /api/v1/records/bulk. The zone format writes one resource record per line.
+func WriteExport(w io.Writer, format string, records []*dns.Record) error {
+	switch format {
+	case ExportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case ExportYAML:
+		// Go through JSON so custom fields and field names match the JSON export
+		data, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		var doc []yaml.MapSlice
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case ExportZone:
+		return writeZone(w, records)
+	case ExportDnsmasq, ExportHosts:
+		return writeHosts(w, format, records)
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// Exportable reports whether format can represent record. Only the dnsmasq and
+// hosts formats leave record types out.
+func Exportable(format string, record *dns.Record) bool {
+	switch format {
+	case ExportDnsmasq:
+		return record.Type == dns.RecordTypeA || record.Type == dns.RecordTypeTXT
+	case ExportHosts:
+		return record.Type == dns.RecordTypeA
+	default:
+		return true
+	}
+}
+
+// ExportSkipped counts the records format cannot represent
+func ExportSkipped(format string, records []*dns.Record) int {
+	skipped := 0
+	for _, record := range records {
+		if !Exportable(format, record) {
+			skipped++
+		}
+	}
+	return skipped
+}
+
+// writeHosts writes records as dnsmasq configuration or hosts file lines, for
+// syncing into resolvers that read those formats. An A record becomes an
+// address=/name/value line (dnsmasq) or a "value name" line (hosts) per value,
+// with its aliases as further names; dnsmasq gets TXT records as txt-record
+// lines. Other types are skipped and counted in a leading comment. Views and
+// expiry have no equivalent; the default values are written.
+func writeHosts(w io.Writer, format string, records []*dns.Record) error {
+	if skipped := ExportSkipped(format, records); skipped > 0 {
+		if _, err := fmt.Fprintf(w, "# %d record(s) skipped that the %s format cannot represent\n", skipped, format); err != nil {
+			return err
+		}
+	}
+
+	for _, record := range records {
+		if !Exportable(format, record) {
+			continue
+		}
+
+		names := []string{dns.TrimDot(record.FQDN())}
+		for _, alias := range record.Aliases {
+			names = append(names, alias+"."+record.Domain)
+		}
+
+		var lines []string
+		switch {
+		case record.Type == dns.RecordTypeTXT:
+			var chunks []string
+			for _, chunk := range dns.SplitTXT(record.Value) {
+				chunks = append(chunks, `"`+strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(chunk)+`"`)
+			}
+			for _, name := range names {
+				lines = append(lines, "txt-record="+name+","+strings.Join(chunks, ","))
+			}
+		case format == ExportDnsmasq:
+			for _, value := range record.ValuesFor(nil) {
+				lines = append(lines, "address=/"+strings.Join(names, "/")+"/"+value)
+			}
+		default:
+			for _, value := range record.ValuesFor(nil) {
+				lines = append(lines, value+" "+strings.Join(names, " "))
+			}
+		}
+
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeZone writes records as zone file lines with absolute names. Views,
+// aliases and expiry have no zone file equivalent; the default values are written.
+func writeZone(w io.Writer, records []*dns.Record) error {
+	domain := ""
+	for _, record := range records {
+		if record.Domain != domain {
+			domain = record.Domain
+			if _, err := fmt.Fprintf(w, "; %s\n", domain); err != nil {
+				return err
+			}
+		}
+
+		header := mdns.RR_Header{Name: record.FQDN(), Class: mdns.ClassINET, Ttl: record.TTL}
+		var rrs []mdns.RR
+		switch record.Type {
+		case dns.RecordTypeA:
+			values := record.Values
+			if len(values) == 0 {
+				values = []string{record.Value}
+			}
+			header.Rrtype = mdns.TypeA
+			for _, value := range values {
+				rrs = append(rrs, &mdns.A{Hdr: header, A: net.ParseIP(value)})
+			}
+		case dns.RecordTypeCNAME:
+			header.Rrtype = mdns.TypeCNAME
+			rrs = append(rrs, &mdns.CNAME{Hdr: header, Target: mdns.Fqdn(record.Value)})
+		case dns.RecordTypeTXT:
+			header.Rrtype = mdns.TypeTXT
+			rrs = append(rrs, &mdns.TXT{Hdr: header, Txt: dns.SplitTXT(record.Value)})
+		}
+
+		for _, rr := range rrs {
+			if _, err := fmt.Fprintln(w, rr.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
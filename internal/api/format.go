@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// RecordsFormatVersion is the on-disk format written by this version.
+//
+// Version history:
+//
+//	0: a bare domain -> name -> record object map, one record per name
+//	1: {"version": 1, "records": ...} with every name holding an array of
+//	   records, at most one per type
+const RecordsFormatVersion = 1
+
+// recordsFile is the on-disk layout of the records file and of snapshots
+type recordsFile struct {
+	Version int                                 `json:"version"`
+	Records map[string]map[string][]*dns.Record `json:"records"`
+}
+
+// shardFile is the on-disk layout of a single domain's shard
+type shardFile struct {
+	Version int                      `json:"version"`
+	Records map[string][]*dns.Record `json:"records"`
+}
+
+// encodeRecords converts records to the current on-disk layout
+func encodeRecords(records map[string]map[string]dns.RecordSet) recordsFile {
+	file := recordsFile{Version: RecordsFormatVersion, Records: make(map[string]map[string][]*dns.Record, len(records))}
+	for domain, domainRecords := range records {
+		file.Records[domain] = encodeShard(domainRecords).Records
+	}
+	return file
+}
+
+// encodeShard converts one domain's records to the current on-disk layout
+func encodeShard(domainRecords map[string]dns.RecordSet) shardFile {
+	file := shardFile{Version: RecordsFormatVersion, Records: make(map[string][]*dns.Record, len(domainRecords))}
+	for name, set := range domainRecords {
+		file.Records[name] = set
+	}
+	return file
+}
+
+// decodeRecords decodes a records file in any supported format version and
+// returns the records along with the version they were stored in
+func decodeRecords(data []byte) (map[string]map[string]dns.RecordSet, int, error) {
+	version, body, err := unwrapVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make(map[string]map[string]dns.RecordSet)
+	if err := json.Unmarshal(body, &records); err != nil {
+		return nil, 0, err
+	}
+	return records, version, migrate(records, version)
+}
+
+// decodeShard decodes a shard file in any supported format version and
+// returns the domain's records along with the version they were stored in
+func decodeShard(domain string, data []byte) (map[string]dns.RecordSet, int, error) {
+	version, body, err := unwrapVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	domainRecords := make(map[string]dns.RecordSet)
+	if err := json.Unmarshal(body, &domainRecords); err != nil {
+		return nil, 0, err
+	}
+
+	records := map[string]map[string]dns.RecordSet{domain: domainRecords}
+	return domainRecords, version, migrate(records, version)
+}
+
+// unwrapVersion returns the format version of a stored file and the JSON of its
+// records. Version 0 files have no envelope: the whole file is the records map.
+// A top-level "version" key holding a number marks an enveloped file; a domain
+// named "version" would hold an object instead.
+func unwrapVersion(data []byte) (int, []byte, error) {
+	var envelope struct {
+		Version json.RawMessage `json:"version"`
+		Records json.RawMessage `json:"records"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return 0, nil, err
+	}
+
+	raw := bytes.TrimSpace(envelope.Version)
+	if len(raw) == 0 || raw[0] == '{' {
+		return 0, data, nil
+	}
+
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, nil, fmt.Errorf("invalid format version: %s", raw)
+	}
+	if version > RecordsFormatVersion {
+		return 0, nil, fmt.Errorf("format version %d is newer than the supported version %d; upgrade netbird-coredns", version, RecordsFormatVersion)
+	}
+	if len(envelope.Records) == 0 {
+		return version, []byte("{}"), nil
+	}
+	return version, envelope.Records, nil
+}
+
+// migrate upgrades records decoded from an older format version in place
+func migrate(records map[string]map[string]dns.RecordSet, version int) error {
+	switch version {
+	case 0:
+		// Version 0 held a single record object per name, which RecordSet
+		// already decodes into a one-record set; nothing else changed
+		fallthrough
+	case RecordsFormatVersion:
+		return nil
+	default:
+		return fmt.Errorf("unsupported format version %d", version)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// ForwardResult is the outcome of querying one forwarder
+type ForwardResult struct {
+	// Forwarder is the entry of NBDNS_FORWARD_TO, Address the server queried
+	Forwarder string `json:"forwarder"`
+	Address   string `json:"address,omitempty"`
+	OK        bool   `json:"ok"`
+	// Rcode is empty when no response was received
+	Rcode     string   `json:"rcode,omitempty"`
+	Answers   []string `json:"answers"`
+	LatencyMs float64  `json:"latency_ms"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// forwardTarget is a server to query and the network to reach it over
+type forwardTarget struct {
+	forwarder string
+	address   string
+	net       string
+}
+
+// forwardTargets expands the forward plugin's destinations into the servers to
+// query: plain and dns:// addresses over UDP on port 53 by default, tls://
+// addresses over DNS-over-TLS on port 853, and a resolv.conf path into each of
+// its nameservers. A destination that cannot be used gets an error result.
+func forwardTargets(forwardTo string) ([]forwardTarget, []ForwardResult) {
+	var targets []forwardTarget
+	var failed []ForwardResult
+	for _, forwarder := range strings.Fields(forwardTo) {
+		if strings.HasPrefix(forwarder, "/") {
+			resolv, err := mdns.ClientConfigFromFile(forwarder)
+			if err != nil {
+				failed = append(failed, ForwardResult{Forwarder: forwarder, Answers: []string{}, Error: err.Error()})
+				continue
+			}
+			for _, server := range resolv.Servers {
+				targets = append(targets, forwardTarget{forwarder: forwarder, address: net.JoinHostPort(server, resolv.Port), net: "udp"})
+			}
+			continue
+		}
+
+		network, port, address := "udp", "53", strings.TrimPrefix(forwarder, "dns://")
+		if strings.HasPrefix(forwarder, "tls://") {
+			network, port, address = "tcp-tls", "853", strings.TrimPrefix(forwarder, "tls://")
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(strings.Trim(address, "[]"), port)
+		}
+		targets = append(targets, forwardTarget{forwarder: forwarder, address: address, net: network})
+	}
+	return targets, failed
+}
+
+// TestForwarders queries every destination of forwardTo, as NBDNS_FORWARD_TO
+// is written, for name and qtype at the same time and returns the results in
+// configuration order, followed by destinations that could not be queried. A
+// truncated UDP response is retried over TCP. Each query gives up after
+// timeout, so a dead forwarder fails quickly instead of holding the request.
+func TestForwarders(forwardTo, name string, qtype uint16, timeout time.Duration) []ForwardResult {
+	targets, results := forwardTargets(forwardTo)
+
+	tested := make([]ForwardResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tested[i] = testForwarder(target, name, qtype, timeout)
+		}()
+	}
+	wg.Wait()
+
+	return append(tested, results...)
+}
+
+// testForwarder sends one query to a forwarder
+func testForwarder(target forwardTarget, name string, qtype uint16, timeout time.Duration) ForwardResult {
+	result := ForwardResult{Forwarder: target.forwarder, Address: target.address, Answers: []string{}}
+
+	m := new(mdns.Msg)
+	m.SetQuestion(mdns.Fqdn(name), qtype)
+	m.RecursionDesired = true
+
+	client := &mdns.Client{Net: target.net, Timeout: timeout}
+	if target.net == "tcp-tls" {
+		host, _, _ := net.SplitHostPort(target.address)
+		client.TLSConfig = &tls.Config{ServerName: host}
+	}
+
+	start := time.Now()
+	resp, _, err := client.Exchange(m, target.address)
+	if err == nil && resp.Truncated && target.net == "udp" {
+		client.Net = "tcp"
+		resp, _, err = client.Exchange(m, target.address)
+	}
+	result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Rcode = mdns.RcodeToString[resp.Rcode]
+	// NXDOMAIN is a valid answer from a working forwarder
+	result.OK = resp.Rcode == mdns.RcodeSuccess || resp.Rcode == mdns.RcodeNameError
+	for _, rr := range resp.Answer {
+		result.Answers = append(result.Answers, rr.String())
+	}
+	return result
+}
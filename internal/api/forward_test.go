@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// silentUpstream returns the address of a UDP listener that reads queries but
+// never answers them
+func silentUpstream(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestTestForwardersTimesOut(t *testing.T) {
+	address := silentUpstream(t)
+	timeout := 200 * time.Millisecond
+
+	start := time.Now()
+	results := TestForwarders(address, "example.com", mdns.TypeA, timeout)
+	elapsed := time.Since(start)
+
+	if elapsed > timeout+time.Second {
+		t.Errorf("TestForwarders took %v, want at most %v", elapsed, timeout)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	result := results[0]
+	if result.OK || result.Error == "" {
+		t.Errorf("result = %+v, want a failed query with an error", result)
+	}
+	if result.Address != address {
+		t.Errorf("address = %q, want %q", result.Address, address)
+	}
+}
@@ -2,21 +2,84 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
 
 	"netbird-coredns/internal/logger"
+	"netbird-coredns/internal/process"
 	"netbird-coredns/pkg/dns"
 )
 
-// HealthHandler handles health check requests
+// HealthHandler handles health check requests. The service is degraded, and
+// answers 503, while the last write of the records failed.
 func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
+	response := map[string]interface{}{
 		"status": "ok",
-	})
+	}
+	status := http.StatusOK
+
+	save := s.storage.SaveStatus()
+	if !save.LastSuccess.IsZero() {
+		response["last_save"] = save.LastSuccess.UTC()
+	}
+	if save.Error != nil {
+		response["status"] = "degraded"
+		response["last_save_error"] = save.Error.Error()
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// generationHeader carries the records generation on list responses
+const generationHeader = "X-Records-Generation"
+
+// errorStatus maps a storage error to an HTTP status code
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, dns.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, dns.ErrInvalidRecord),
+		errors.Is(err, dns.ErrInvalidValue),
+		errors.Is(err, dns.ErrUnsupportedType):
+		return http.StatusBadRequest
+	case errors.Is(err, dns.ErrImmutableRecord):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ConfiguredHealthHandler handles the health check at NBDNS_HEALTH_PATH, answering
+// with the configured status code and a plain-text body when one is set. While
+// the last write of the records failed it answers like HealthHandler instead.
+func (s *Server) ConfiguredHealthHandler(w http.ResponseWriter, r *http.Request) {
+	if s.storage.SaveStatus().Error != nil {
+		s.HealthHandler(w, r)
+		return
+	}
+
+	if s.config.HealthBody == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(s.config.HealthStatus)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "ok",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(s.config.HealthStatus)
+	fmt.Fprintln(w, s.config.HealthBody)
 }
 
 // ListRecordsHandler handles GET /api/v1/records
@@ -26,9 +89,12 @@ func (s *Server) ListRecordsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Read the generation first so the records are never older than it claims
+	generation := s.storage.Generation()
 	records := s.storage.ListRecords()
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(generationHeader, strconv.FormatUint(generation, 10))
 	if err := json.NewEncoder(w).Encode(records); err != nil {
 		logger.Error("Error encoding response: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -36,6 +102,199 @@ func (s *Server) ListRecordsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GenerationHandler handles GET /api/v1/generation
+func (s *Server) GenerationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]uint64{
+		"generation": s.storage.Generation(),
+	})
+}
+
+// processUptime is a child process on the uptime endpoint
+type processUptime struct {
+	process.Status
+	UptimeSeconds int64 `json:"uptime_seconds"`
+}
+
+// UptimeHandler handles GET /api/v1/uptime
+func (s *Server) UptimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	processes := []processUptime{}
+	if list := s.processes.Load(); list != nil {
+		for _, status := range (*list)() {
+			entry := processUptime{Status: status}
+			// A stopped process has no uptime
+			if status.Running {
+				entry.UptimeSeconds = int64(now.Sub(status.StartedAt).Seconds())
+			}
+			processes = append(processes, entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"started_at":     s.startedAt.UTC(),
+		"uptime_seconds": int64(now.Sub(s.startedAt).Seconds()),
+		"processes":      processes,
+	})
+}
+
+// ValidateAllHandler handles GET /api/v1/validate-all
+func (s *Server) ValidateAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	problems := s.storage.Audit(s.domains())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"valid":    len(problems) == 0,
+		"problems": problems,
+	})
+}
+
+// ResolveHandler handles GET /api/v1/resolve?name=NAME[&type=TYPE][&client=IP]
+func (s *Server) ResolveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		http.Error(w, "Missing name parameter", http.StatusBadRequest)
+		return
+	}
+
+	recordType := dns.RecordTypeA
+	if typeStr := query.Get("type"); typeStr != "" {
+		var err error
+		if recordType, err = dns.ParseRecordType(typeStr); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	// Views are evaluated for the given client address, if any
+	var clientIP net.IP
+	if clientStr := query.Get("client"); clientStr != "" {
+		if clientIP = net.ParseIP(clientStr); clientIP == nil {
+			http.Error(w, fmt.Sprintf("Invalid client address: %s", clientStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	domains := s.domains()
+	matches := s.storage.Resolve(domains, name, recordType, clientIP, s.config.AQueryOrder)
+
+	// result tells apart names outside the configured domains, which the plugin
+	// passes on, from in-zone names without a matching record
+	result := "answer"
+	switch {
+	case len(matches) > 0:
+	case !InZone(domains, name):
+		result = "not_in_zone"
+	default:
+		result = "no_record"
+	}
+	if matches == nil {
+		matches = []Match{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":    dns.TrimDot(strings.ToLower(name)),
+		"type":    recordType,
+		"result":  result,
+		"matches": matches,
+	})
+}
+
+// ForwardTestHandler handles GET /api/v1/forward-test?name=NAME[&type=TYPE]
+func (s *Server) ForwardTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		http.Error(w, "Missing name parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Any query type can be forwarded, not only the ones records can be stored for
+	qtype := mdns.TypeA
+	if typeStr := query.Get("type"); typeStr != "" {
+		var ok bool
+		if qtype, ok = mdns.StringToType[strings.ToUpper(typeStr)]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown query type: %s", typeStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	results := TestForwarders(s.config.ForwardTo, name, qtype, s.config.UpstreamTimeout)
+	ok := len(results) > 0
+	for _, result := range results {
+		ok = ok && result.OK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":    dns.TrimDot(strings.ToLower(name)),
+		"type":    mdns.TypeToString[qtype],
+		"ok":      ok,
+		"results": results,
+	})
+}
+
+// DomainInfo describes a configured domain and its records
+type DomainInfo struct {
+	Domain     string `json:"domain"`
+	Records    int    `json:"records"`
+	HasRecords bool   `json:"has_records"`
+}
+
+// ListDomainsHandler handles GET /api/v1/domains
+func (s *Server) ListDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	counts := s.storage.RecordCounts()
+	configured := s.domains()
+	domains := make([]DomainInfo, 0, len(configured))
+	for _, domain := range configured {
+		domains = append(domains, DomainInfo{
+			Domain:     domain,
+			Records:    counts[domain],
+			HasRecords: counts[domain] > 0,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(domains); err != nil {
+		logger.Error("Error encoding response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
 // CreateRecordHandler handles POST /api/v1/records
 func (s *Server) CreateRecordHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -49,17 +308,34 @@ func (s *Server) CreateRecordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Single-zone setups may leave out the domain
+	if record.Domain == "" {
+		domain, ok := s.recordDomain()
+		if !ok {
+			http.Error(w, "Failed to create record: record domain is required when several domains are configured and NBDNS_DEFAULT_DOMAIN is not set", http.StatusBadRequest)
+			return
+		}
+		record.Domain = domain
+	}
+
+	// Repeated values are dropped on store (unless rejected); tell the caller which
+	duplicates := record.DuplicateValues()
+
 	if err := s.storage.SetRecord(&record); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create record: %v", err), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Failed to create record: %v", err), errorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"message": "Record created successfully",
 		"record":  record,
-	})
+	}
+	if len(duplicates) > 0 {
+		response["deduplicated"] = duplicates
+	}
+	json.NewEncoder(w).Encode(response)
 }
 
 // UpdateRecordHandler handles PUT /api/v1/records/{domain}/{name}
@@ -94,19 +370,210 @@ func (s *Server) UpdateRecordHandler(w http.ResponseWriter, r *http.Request) {
 	record.Domain = domain
 	record.Name = name
 
+	// As on create, dropped repeats are reported in the response
+	duplicates := record.DuplicateValues()
+
 	if err := s.storage.SetRecord(&record); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update record: %v", err), http.StatusBadRequest)
+		http.Error(w, fmt.Sprintf("Failed to update record: %v", err), errorStatus(err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	response := map[string]interface{}{
 		"message": "Record updated successfully",
 		"record":  record,
+	}
+	if len(duplicates) > 0 {
+		response["deduplicated"] = duplicates
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// BulkUpsertHandler handles PUT /api/v1/records/bulk[?prune=true]
+func (s *Server) BulkUpsertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prune := false
+	if pruneStr := r.URL.Query().Get("prune"); pruneStr != "" {
+		var err error
+		if prune, err = strconv.ParseBool(pruneStr); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid prune value: %s", pruneStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var records []*dns.Record
+	if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Results are returned on failure too, so clients can see which records were rejected
+	results, err := s.storage.SetRecords(records, prune)
+	status := http.StatusOK
+	message := "Records upserted successfully"
+	if err != nil {
+		status = errorStatus(err)
+		message = fmt.Sprintf("Failed to upsert records: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"results": results,
+	})
+}
+
+// DiffHandler handles POST /api/v1/diff
+func (s *Server) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	s.planHandler(w, r, "Diff computed successfully", "Failed to compute diff", s.storage.Diff)
+}
+
+// ApplyHandler handles POST /api/v1/apply
+func (s *Server) ApplyHandler(w http.ResponseWriter, r *http.Request) {
+	s.planHandler(w, r, "Changes applied successfully", "Failed to apply changes", s.storage.Apply)
+}
+
+// planHandler decodes a desired state, passes it to run and writes the
+// resulting plan, or the per-record results when the state is invalid
+func (s *Server) planHandler(w http.ResponseWriter, r *http.Request, success, failure string,
+	run func([]*dns.Record) (*Plan, []UpsertResult, error)) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var records []*dns.Record
+	if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	plan, results, err := run(records)
+	if err != nil && results == nil {
+		http.Error(w, fmt.Sprintf("%s: %v", failure, err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": fmt.Sprintf("%s: %v", failure, err),
+			"results": results,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": success,
+		"plan":    plan,
+	})
+}
+
+// exportContentTypes maps export formats to their content type
+var exportContentTypes = map[string]string{
+	ExportJSON: "application/json",
+	ExportYAML: "application/yaml",
+	ExportZone: "text/dns",
+
+	ExportDnsmasq: "text/plain; charset=utf-8",
+	ExportHosts:   "text/plain; charset=utf-8",
+}
+
+// ExportHandler handles GET /api/v1/export[?domain=DOMAIN][&type=TYPE][&label=KEY=VALUE...][&format=json|yaml|zone|dnsmasq|hosts]
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = ExportJSON
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unsupported export format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	filter := ExportFilter{Domain: query.Get("domain")}
+	if typeStr := query.Get("type"); typeStr != "" {
+		var err error
+		if filter.Type, err = dns.ParseRecordType(typeStr); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+	labels, err := ParseLabelFilters(query["label"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Labels = labels
+
+	// Formats that cannot hold every record type report how many were left out
+	records := s.storage.Export(filter)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Export-Skipped", strconv.Itoa(ExportSkipped(format, records)))
+	if err := WriteExport(w, format, records); err != nil {
+		logger.Error("Error writing export: %v", err)
+	}
+}
+
+// ImportHandler handles POST /api/v1/import?format=csv
+func (s *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if format := r.URL.Query().Get("format"); format != "" && format != "csv" {
+		http.Error(w, fmt.Sprintf("Unsupported import format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	rows, results, err := ParseCSVRecords(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid CSV: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	message := "Records imported successfully"
+	if len(results) > 0 {
+		// Rows are imported all-or-nothing, like a bulk upsert
+		status = http.StatusBadRequest
+		message = fmt.Sprintf("Failed to import records: %d rows are invalid", len(results))
+	} else {
+		records := make([]*dns.Record, len(rows))
+		for i, row := range rows {
+			records[i] = row.Record
+		}
+		results, err = s.storage.SetRecords(records, false)
+		for i := range rows {
+			results[i].Line = rows[i].Line
+		}
+		if err != nil {
+			status = errorStatus(err)
+			message = fmt.Sprintf("Failed to import records: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"results": results,
 	})
 }
 
-// DeleteRecordHandler handles DELETE /api/v1/records/{domain}/{name}
+// DeleteRecordHandler handles DELETE /api/v1/records/{domain}/{name}[?type=TYPE]
 func (s *Server) DeleteRecordHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -128,17 +595,154 @@ func (s *Server) DeleteRecordHandler(w http.ResponseWriter, r *http.Request) {
 		name = ""
 	}
 
-	if err := s.storage.DeleteRecord(domain, name); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete record: %v", err), http.StatusNotFound)
+	// Without a type, all of the name's records are deleted
+	var recordType dns.RecordType
+	if typeStr := r.URL.Query().Get("type"); typeStr != "" {
+		var err error
+		if recordType, err = dns.ParseRecordType(typeStr); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	deleted, err := s.storage.DeleteRecord(domain, name, recordType)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete record: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Record deleted successfully",
+		"record":  deleted,
+	})
+}
+
+// RestoreRecordHandler handles POST /api/v1/records/{domain}/{name}/restore[?type=TYPE]
+func (s *Server) RestoreRecordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse path: /api/v1/records/{domain}/{name}/restore
+	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/records/"), "/")
+	if len(pathParts) != 3 {
+		http.Error(w, "Invalid path format. Expected: /api/v1/records/{domain}/{name}/restore", http.StatusBadRequest)
+		return
+	}
+
+	domain := pathParts[0]
+	name := pathParts[1]
+
+	// Normalize "@" to empty string for root domain records
+	if name == "@" {
+		name = ""
+	}
+
+	// Without a type, all of the name's deleted records are restored
+	var recordType dns.RecordType
+	if typeStr := r.URL.Query().Get("type"); typeStr != "" {
+		var err error
+		if recordType, err = dns.ParseRecordType(typeStr); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	restored, err := s.storage.RestoreRecord(domain, name, recordType)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to restore record: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Record restored successfully",
+		"record":  restored,
+	})
+}
+
+// SnapshotHandler handles POST /api/v1/snapshot
+func (s *Server) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.config.BackupDir == "" {
+		http.Error(w, "Snapshots are disabled. Set NBDNS_BACKUP_DIR to enable them", http.StatusNotImplemented)
+		return
+	}
+
+	path, err := s.storage.Snapshot(s.config.BackupDir, s.config.BackupKeep)
+	if err != nil && path == "" {
+		http.Error(w, fmt.Sprintf("Failed to create snapshot: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if err != nil {
+		// The snapshot itself was written; only pruning old ones failed
+		logger.Warn("Snapshot %s created, but pruning old snapshots failed: %v", path, err)
+	}
+	logger.Info("Records snapshot written to %s", path)
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Record deleted successfully",
+		"message": "Snapshot created successfully",
+		"path":    path,
 	})
 }
 
+// MaintenanceHandler handles GET, POST and DELETE /api/v1/maintenance
+func (s *Server) MaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.maintenance.Status())
+
+	case http.MethodPost:
+		var req struct {
+			Timeout int    `json:"timeout"` // seconds
+			Rcode   string `json:"rcode"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		state, err := s.maintenance.Enable(time.Duration(req.Timeout)*time.Second, strings.ToUpper(req.Rcode))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to enable maintenance mode: %v", err), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Maintenance mode enabled until %s (rcode: %s)", state.ExpiresAt.Format(time.RFC3339), state.Rcode)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":     "Maintenance mode enabled",
+			"maintenance": state,
+		})
+
+	case http.MethodDelete:
+		if err := s.maintenance.Disable(); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to disable maintenance mode: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Maintenance mode disabled")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Maintenance mode disabled",
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // RecordHandler routes record requests based on path
 func (s *Server) RecordHandler(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
@@ -156,6 +760,21 @@ func (s *Server) RecordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if path == "/api/v1/records/bulk" {
+		if r.Method == http.MethodPut {
+			s.BulkUpsertHandler(w, r)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	// Pattern: /api/v1/records/{domain}/{name}/restore
+	if strings.HasPrefix(path, "/api/v1/records/") && strings.HasSuffix(path, "/restore") {
+		s.RestoreRecordHandler(w, r)
+		return
+	}
+
 	// Pattern: /api/v1/records/{domain}/{name}
 	if strings.HasPrefix(path, "/api/v1/records/") {
 		switch r.Method {
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"netbird-coredns/internal/config"
+)
+
+func TestBulkUpsertRejectsNullRecords(t *testing.T) {
+	storage, path := newTestStorage(t, StorageOptions{})
+	s := NewServer(storage, &config.Config{Domains: []string{"example.com"}, RecordsFile: path})
+
+	rec := httptest.NewRecorder()
+	s.RecordHandler(rec, httptest.NewRequest(http.MethodPut, "/api/v1/records/bulk", strings.NewReader(`[null]`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	var body struct {
+		Results []UpsertResult `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Results) != 1 || body.Results[0].Status != "invalid" {
+		t.Errorf("results = %+v, want one invalid result", body.Results)
+	}
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// csvColumns is the column order accepted by CSV imports; ttl may be omitted
+var csvColumns = []string{"domain", "name", "type", "value", "ttl"}
+
+// ImportRow is a record parsed from one line of an import
+type ImportRow struct {
+	Line   int
+	Record *dns.Record
+}
+
+// ParseCSVRecords reads records from CSV rows of domain,name,type,value[,ttl].
+// A leading header row is skipped. Rows that cannot be turned into a record
+// are returned as invalid results carrying their line number; a malformed
+// CSV stream is returned as an error.
+func ParseCSVRecords(r io.Reader) ([]ImportRow, []UpsertResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var rows []ImportRow
+	var invalid []UpsertResult
+	first := true
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		if first {
+			first = false
+			if isCSVHeader(fields) {
+				continue
+			}
+		}
+
+		record, err := csvRecord(fields)
+		if err != nil {
+			result := UpsertResult{Line: line, Status: "invalid", Error: err.Error()}
+			if len(fields) >= 2 {
+				result.Domain, result.Name = fields[0], fields[1]
+			}
+			invalid = append(invalid, result)
+			continue
+		}
+		rows = append(rows, ImportRow{Line: line, Record: record})
+	}
+
+	return rows, invalid, nil
+}
+
+// isCSVHeader reports whether fields name the import columns rather than a record
+func isCSVHeader(fields []string) bool {
+	if len(fields) < len(csvColumns)-1 || len(fields) > len(csvColumns) {
+		return false
+	}
+	for i, field := range fields {
+		if !strings.EqualFold(strings.TrimSpace(field), csvColumns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// csvRecord builds a record from the fields of one CSV row
+func csvRecord(fields []string) (*dns.Record, error) {
+	if len(fields) != len(csvColumns) && len(fields) != len(csvColumns)-1 {
+		return nil, fmt.Errorf("expected %d or %d columns (%s), got %d",
+			len(csvColumns)-1, len(csvColumns), strings.Join(csvColumns, ","), len(fields))
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	recordType, err := dns.ParseRecordType(fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	record := &dns.Record{
+		Domain: fields[0],
+		Name:   fields[1],
+		Type:   recordType,
+		Value:  fields[3],
+	}
+	if len(fields) == len(csvColumns) && fields[4] != "" {
+		ttl, err := strconv.ParseUint(fields[4], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %s", fields[4])
+		}
+		record.TTL = uint32(ttl)
+	}
+	return record, nil
+}
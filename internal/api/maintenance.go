@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaintenanceTimeout is how long maintenance mode stays on when no timeout is given
+	DefaultMaintenanceTimeout = 5 * time.Minute
+	// MaxMaintenanceTimeout bounds maintenance mode so it always clears itself eventually
+	MaxMaintenanceTimeout = time.Hour
+
+	// maintenanceCheckInterval limits how often the marker file is read on the query path
+	maintenanceCheckInterval = time.Second
+)
+
+// MaintenanceState describes an active maintenance window
+type MaintenanceState struct {
+	Enabled   bool       `json:"enabled"`
+	Rcode     string     `json:"rcode,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Maintenance is a maintenance flag shared between the API and the DNS plugin.
+// The two run in separate processes, so the flag is a marker file stored next
+// to the records file. The marker carries an expiry time and is ignored once it
+// has passed, so a forgotten maintenance window clears itself.
+type Maintenance struct {
+	path string
+
+	mu        sync.Mutex
+	cached    MaintenanceState
+	checkedAt time.Time
+}
+
+// NewMaintenance creates a maintenance flag for the given records file
+func NewMaintenance(recordsFile string) *Maintenance {
+	return &Maintenance{
+		path: recordsFile + ".maintenance",
+	}
+}
+
+// Enable turns maintenance mode on for the given duration
+func (m *Maintenance) Enable(timeout time.Duration, rcode string) (MaintenanceState, error) {
+	if timeout <= 0 {
+		timeout = DefaultMaintenanceTimeout
+	}
+	if timeout > MaxMaintenanceTimeout {
+		return MaintenanceState{}, fmt.Errorf("maintenance timeout cannot exceed %v", MaxMaintenanceTimeout)
+	}
+
+	switch rcode {
+	case "":
+		rcode = "SERVFAIL"
+	case "SERVFAIL", "REFUSED":
+	default:
+		return MaintenanceState{}, fmt.Errorf("unsupported maintenance rcode: %s (must be SERVFAIL or REFUSED)", rcode)
+	}
+
+	expiresAt := time.Now().Add(timeout).UTC()
+	state := MaintenanceState{
+		Enabled:   true,
+		Rcode:     rcode,
+		ExpiresAt: &expiresAt,
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return MaintenanceState{}, fmt.Errorf("failed to encode maintenance state: %w", err)
+	}
+
+	tempFile := m.path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return MaintenanceState{}, fmt.Errorf("failed to write maintenance state: %w", err)
+	}
+	defer os.Remove(tempFile) // Clean up on error
+
+	if err := os.Rename(tempFile, m.path); err != nil {
+		return MaintenanceState{}, fmt.Errorf("failed to rename maintenance state: %w", err)
+	}
+
+	return state, nil
+}
+
+// Disable turns maintenance mode off
+func (m *Maintenance) Disable() error {
+	if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove maintenance state: %w", err)
+	}
+	return nil
+}
+
+// Status reads the current maintenance state from disk
+func (m *Maintenance) Status() MaintenanceState {
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return MaintenanceState{}
+	}
+
+	var state MaintenanceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return MaintenanceState{}
+	}
+
+	// An expired window is the same as no window
+	if !state.isActive(time.Now()) {
+		return MaintenanceState{}
+	}
+
+	return state
+}
+
+// Active returns the maintenance state, re-reading the marker file at most once per second
+func (m *Maintenance) Active() (MaintenanceState, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.checkedAt) >= maintenanceCheckInterval {
+		m.cached = m.Status()
+		m.checkedAt = now
+	}
+
+	if !m.cached.isActive(now) {
+		return MaintenanceState{}, false
+	}
+	return m.cached, true
+}
+
+// isActive reports whether the maintenance window is enabled and has not yet expired
+func (s MaintenanceState) isActive(now time.Time) bool {
+	return s.Enabled && s.ExpiresAt != nil && now.Before(*s.ExpiresAt)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Storage metrics are registered by the API server only, so the DNS plugin,
+// which shares this package but never writes, does not export them
+var (
+	// saveFailuresCount counts records writes that failed
+	saveFailuresCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "netbird_coredns",
+		Subsystem: "storage",
+		Name:      "save_failures_total",
+		Help:      "Counter of failed writes of the records file.",
+	})
+
+	// lastSaveSuccess is the Unix time of the last successful records write
+	lastSaveSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "netbird_coredns",
+		Subsystem: "storage",
+		Name:      "last_save_success_timestamp_seconds",
+		Help:      "Unix time of the last successful write of the records file.",
+	})
+
+	// saveFailing is 1 while the last records write failed
+	saveFailing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "netbird_coredns",
+		Subsystem: "storage",
+		Name:      "last_save_failed",
+		Help:      "Whether the last write of the records file failed (1) or succeeded (0).",
+	})
+)
+
+// newMetricsRegistry returns a registry holding the API process metrics
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(saveFailuresCount, lastSaveSuccess, saveFailing)
+	return registry
+}
@@ -0,0 +1,333 @@
+package api
+
+import (
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"netbird-coredns/internal/logger"
+)
+
+// readOnlyMiddleware rejects every mutation when enabled. Followers serve
+// records written by the leader and must never write themselves.
+func readOnlyMiddleware(enabled bool, next http.Handler) http.Handler {
+	if !enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isMutation(r) {
+			http.Error(w, "Mutations are disabled on follower instances", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readinessMiddleware rejects mutations with 503 until ready reports true, so
+// records cannot be changed before the DNS server is serving them
+func readinessMiddleware(enabled bool, ready func() bool, next http.Handler) http.Handler {
+	if !enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isMutation(r) && !ready() {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "DNS server is not ready yet, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowedMethods are the methods browsers may use cross-origin
+const corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+
+// corsMiddleware lets browser pages on the allowed origins call the API. "*"
+// allows any origin. Preflight OPTIONS requests are answered with 204 right away.
+// Without origins, no CORS headers are sent and browsers keep blocking
+// cross-origin requests.
+func corsMiddleware(origins []string, next http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+
+	allowAll := false
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		if origin == "*" {
+			allowAll = true
+		}
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin != "" && (allowAll || allowed[origin]) {
+			if allowAll {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("Access-Control-Expose-Headers", generationHeader)
+
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				headers := r.Header.Get("Access-Control-Request-Headers")
+				if headers == "" {
+					headers = "Content-Type"
+				}
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				w.Header().Set("Access-Control-Max-Age", "600")
+			}
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accessLogEntry is one line of the API access log
+type accessLogEntry struct {
+	Time       string  `json:"time"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	ClientIP   string  `json:"client_ip"`
+}
+
+// accessLogMiddleware logs every request as a JSON line when enabled. The client
+// address is taken from X-Forwarded-For when the peer is a trusted proxy.
+func accessLogMiddleware(enabled bool, trusted []*net.IPNet, next http.Handler) http.Handler {
+	if !enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		logger.Access(accessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			ClientIP:   clientIP(r, trusted),
+		})
+	})
+}
+
+// statusRecorder captures the status code and body size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader records the status code before sending it
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts the body bytes sent
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// mutationQueueTimeout is how long a mutation waits for a free slot before being rejected
+const mutationQueueTimeout = 5 * time.Second
+
+// concurrencyLimitMiddleware bounds the number of in-flight mutation requests.
+// Requests beyond the limit queue for up to mutationQueueTimeout and are then
+// rejected with 503. Read requests are never limited.
+func concurrencyLimitMiddleware(limit int, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+
+	slots := make(chan struct{}, limit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isMutation(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timer := time.NewTimer(mutationQueueTimeout)
+		defer timer.Stop()
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		case <-timer.C:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent requests, try again later", http.StatusServiceUnavailable)
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// isMutation reports whether the request may modify records
+func isMutation(r *http.Request) bool {
+	// A diff is posted but only compares records, and a snapshot only copies them
+	switch r.URL.Path {
+	case "/api/v1/diff", "/api/v1/snapshot":
+		return false
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// gzipMinSize is the smallest response body that is worth compressing
+const gzipMinSize = 1024
+
+// gzipMiddleware compresses responses for clients that accept gzip encoding.
+// Responses smaller than gzipMinSize and responses that already carry a
+// Content-Encoding are passed through unchanged.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the client advertised gzip support
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), "gzip") {
+			continue
+		}
+		// "gzip;q=0" explicitly refuses gzip
+		for _, param := range fields[1:] {
+			if strings.TrimSpace(param) == "q=0" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it knows whether
+// the body is large enough to compress
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	buf         []byte
+	status      int
+	passthrough bool
+}
+
+// WriteHeader records the status code; it is sent once the encoding is decided
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+// Write buffers small bodies and switches to compression once gzipMinSize is reached
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	if g.passthrough {
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) < gzipMinSize {
+		return len(p), nil
+	}
+
+	if err := g.start(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// start sends the headers and the buffered body, compressing unless the handler already encoded it
+func (g *gzipResponseWriter) start() error {
+	header := g.Header()
+
+	// Never compress a body that is already encoded
+	if header.Get("Content-Encoding") != "" {
+		g.passthrough = true
+		g.ResponseWriter.WriteHeader(g.status)
+		_, err := g.ResponseWriter.Write(g.buf)
+		g.buf = nil
+		return err
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	g.ResponseWriter.WriteHeader(g.status)
+
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+	_, err := g.gz.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// Close flushes the compressed stream, or writes small responses uncompressed
+func (g *gzipResponseWriter) Close() error {
+	if g.gz != nil {
+		return g.gz.Close()
+	}
+	if g.passthrough {
+		return nil
+	}
+
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+
+	if len(g.buf) > 0 {
+		_, err := g.ResponseWriter.Write(g.buf)
+		return err
+	}
+	return nil
+}
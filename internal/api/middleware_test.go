@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadOnlyMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := readOnlyMiddleware(true, ok)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/api/v1/records", http.StatusOK},
+		{http.MethodPost, "/api/v1/records", http.StatusForbidden},
+		{http.MethodPut, "/api/v1/records/example.com/web", http.StatusForbidden},
+		{http.MethodDelete, "/api/v1/records/example.com/web", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/maintenance", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/apply", http.StatusForbidden},
+		{http.MethodPost, "/api/v1/diff", http.StatusOK},
+		{http.MethodPost, "/api/v1/snapshot", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// Plan lists the changes that turn the stored records into a desired state
+type Plan struct {
+	Create    []*dns.Record `json:"create"`
+	Update    []PlanUpdate  `json:"update"`
+	Delete    []*dns.Record `json:"delete"`
+	Unchanged int           `json:"unchanged"`
+}
+
+// PlanUpdate is a stored record and the desired record replacing it
+type PlanUpdate struct {
+	Before *dns.Record `json:"before"`
+	After  *dns.Record `json:"after"`
+}
+
+// Empty reports whether the plan changes nothing
+func (p *Plan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Update) == 0 && len(p.Delete) == 0
+}
+
+// Diff returns the changes Apply would make to reach the desired state given by
+// records, the complete set of records across all domains. Invalid records are
+// reported in the results, as with SetRecords, and no plan is returned.
+func (s *Storage) Diff(records []*dns.Record) (*Plan, []UpsertResult, error) {
+	if results, err := s.prepareDesired(records); err != nil {
+		return nil, results, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.plan(records), nil, nil
+}
+
+// Apply replaces the stored records with the desired state given by records in
+// one atomic operation and returns the changes it made. Records not in the
+// desired state are deleted from every domain.
+func (s *Storage) Apply(records []*dns.Record) (*Plan, []UpsertResult, error) {
+	if results, err := s.prepareDesired(records); err != nil {
+		return nil, results, err
+	}
+
+	s.lockWrite()
+	defer s.unlockWrite()
+
+	plan := s.plan(records)
+	if plan.Empty() {
+		return plan, nil, nil
+	}
+
+	touched := make(map[string]bool)
+	for _, record := range plan.Delete {
+		recordType := record.Type
+		kept := s.records[record.Domain][record.Name].Without(func(r *dns.Record) bool { return r.Type == recordType })
+		s.setRecords(record.Domain, record.Name, kept)
+		touched[record.Domain] = true
+	}
+	for _, record := range plan.Create {
+		s.putRecord(record)
+		touched[record.Domain] = true
+	}
+	for _, update := range plan.Update {
+		s.putRecord(update.After)
+		touched[update.After.Domain] = true
+	}
+
+	domains := make([]string, 0, len(touched))
+	for domain := range touched {
+		domains = append(domains, domain)
+	}
+
+	// Persist to disk
+	return plan, nil, s.commit(domains...)
+}
+
+// prepareDesired prepares every record of a desired state and checks that the
+// state holds each name and type once, respects the CNAME exclusivity rule and
+// has no clashing aliases
+func (s *Storage) prepareDesired(records []*dns.Record) ([]UpsertResult, error) {
+	results := make([]UpsertResult, len(records))
+	invalid := 0
+	for i, record := range records {
+		// A null entry in the payload decodes to a nil record
+		if record == nil {
+			results[i] = UpsertResult{Status: "invalid", Error: dns.Errorf(dns.ErrInvalidRecord, "invalid record: record is null").Error()}
+			invalid++
+			continue
+		}
+		results[i] = UpsertResult{Domain: record.Domain, Name: record.Name, Type: record.Type, Status: "valid"}
+		err := s.prepareRecord(record)
+		if err == nil {
+			err = s.checkSeed(record.Domain, record.Name)
+		}
+		if err != nil {
+			results[i].Status, results[i].Error = "invalid", err.Error()
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return results, dns.Errorf(dns.ErrInvalidRecord, "%d of %d records are invalid", invalid, len(records))
+	}
+
+	type key struct{ domain, name string }
+	desired := make(map[key]dns.RecordSet)
+	for i, record := range records {
+		results[i] = UpsertResult{Domain: record.Domain, Name: record.Name, Type: record.Type, Status: "valid"}
+
+		k := key{record.Domain, record.Name}
+		set := desired[k]
+		if set.Get(record.Type) != nil {
+			results[i].Status, results[i].Error = "invalid", "duplicate record for this name and type"
+			invalid++
+			continue
+		}
+		if err := set.CheckConflict(record.Type); err != nil {
+			results[i].Status, results[i].Error = "invalid", err.Error()
+			invalid++
+			continue
+		}
+		desired[k], _ = set.Put(record)
+	}
+	if invalid > 0 {
+		return results, dns.Errorf(dns.ErrInvalidRecord, "%d of %d records are invalid", invalid, len(records))
+	}
+
+	// Aliases must not clash with the names or aliases of the desired state
+	domains := make(map[string]map[string]dns.RecordSet)
+	for k, set := range desired {
+		if domains[k.domain] == nil {
+			domains[k.domain] = make(map[string]dns.RecordSet)
+		}
+		domains[k.domain][k.name] = set
+	}
+	for i, record := range records {
+		if err := checkAliases(domains[record.Domain], record); err != nil {
+			results[i].Status, results[i].Error = "invalid", err.Error()
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return results, dns.Errorf(dns.ErrInvalidRecord, "%d of %d records are invalid", invalid, len(records))
+	}
+
+	return nil, nil
+}
+
+// plan compares prepared desired records with the stored records. The caller
+// must hold the lock.
+func (s *Storage) plan(records []*dns.Record) *Plan {
+	plan := &Plan{Create: []*dns.Record{}, Update: []PlanUpdate{}, Delete: []*dns.Record{}}
+
+	wanted := make(map[string]map[string]map[dns.RecordType]bool)
+	for _, record := range records {
+		// prepareDesired rejects null entries; never plan around one
+		if record == nil {
+			continue
+		}
+		if wanted[record.Domain] == nil {
+			wanted[record.Domain] = make(map[string]map[dns.RecordType]bool)
+		}
+		if wanted[record.Domain][record.Name] == nil {
+			wanted[record.Domain][record.Name] = make(map[dns.RecordType]bool)
+		}
+		wanted[record.Domain][record.Name][record.Type] = true
+
+		current := s.records[record.Domain][record.Name].Get(record.Type)
+		switch {
+		case current == nil:
+			plan.Create = append(plan.Create, record)
+		case sameRecord(current, record):
+			plan.Unchanged++
+		default:
+			plan.Update = append(plan.Update, PlanUpdate{Before: current, After: record})
+		}
+	}
+
+	for domain, domainRecords := range s.records {
+		for name, set := range domainRecords {
+			for _, record := range set {
+				if !wanted[domain][name][record.Type] {
+					plan.Delete = append(plan.Delete, record)
+				}
+			}
+		}
+	}
+	// Map order is random; list deletions in a stable order
+	sort.Slice(plan.Delete, func(i, j int) bool {
+		a, b := plan.Delete[i], plan.Delete[j]
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type < b.Type
+	})
+
+	return plan
+}
+
+// sameRecord reports whether two prepared records store the same data
+func sameRecord(a, b *dns.Record) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"net"
+	"strings"
+
+	"netbird-coredns/internal/config"
+	"netbird-coredns/pkg/dns"
+)
+
+// DefaultRecordName is the name of a domain's catch-all record, served for
+// names below the domain that have no record of their own
+const DefaultRecordName = "_default"
+
+// MaxCNAMEDepth bounds how many in-zone CNAMEs are followed for one query
+const MaxCNAMEDepth = 8
+
+// How a record in a resolution was reached
+const (
+	// MatchExact is a record stored under the queried name
+	MatchExact = "exact"
+	// MatchDefault is the catch-all record of an enclosing domain
+	MatchDefault = "default"
+	// MatchCNAME is a record reached by following a CNAME
+	MatchCNAME = "cname"
+	// MatchAlias is a record that lists the queried name among its aliases
+	MatchAlias = "alias"
+)
+
+// Match is one record of a resolution
+type Match struct {
+	// Name is the name the record answers, without a trailing dot
+	Name  string `json:"name"`
+	Match string `json:"match"`
+	// Values are the values served to the client the resolution was made for
+	Values []string    `json:"values"`
+	Record *dns.Record `json:"record"`
+}
+
+// SplitName maps a query name to the domain and name of the record that would
+// answer it. A configured domain itself maps to its root domain record (empty
+// name); any other name is split on its first label. The catch-all record
+// cannot be queried by its own name.
+func SplitName(domains []string, queryName string) (string, string, bool) {
+	queryName = dns.TrimDot(strings.ToLower(queryName))
+	for _, domain := range domains {
+		if queryName == domain {
+			return domain, "", true
+		}
+	}
+
+	parts := strings.SplitN(queryName, ".", 2)
+	if len(parts) < 2 || parts[0] == DefaultRecordName {
+		return "", "", false
+	}
+	return parts[1], parts[0], true
+}
+
+// InZone reports whether name falls under one of the configured domains
+func InZone(domains []string, name string) bool {
+	name = dns.TrimDot(strings.ToLower(name))
+	for _, domain := range domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRecord returns the catch-all record of the nearest enclosing domain of
+// queryName and that domain. A CNAME catch-all is preferred over an A one. It
+// applies to names below a domain, never to the zone apex.
+func (s *Storage) DefaultRecord(domains []string, queryName string) (*dns.Record, string, bool) {
+	if !InZone(domains, queryName) {
+		return nil, "", false
+	}
+
+	labels := strings.Split(dns.TrimDot(strings.ToLower(queryName)), ".")
+	for i := 1; i < len(labels); i++ {
+		domain := strings.Join(labels[i:], ".")
+		if !InZone(domains, domain) {
+			break
+		}
+
+		for _, recordType := range []dns.RecordType{dns.RecordTypeCNAME, dns.RecordTypeA} {
+			if record, err := s.GetRecord(domain, DefaultRecordName, recordType); err == nil {
+				return record, domain, true
+			}
+		}
+	}
+
+	return nil, "", false
+}
+
+// Resolve returns the records the DNS plugin would answer a query for
+// queryName and recordType with, in answer order, following in-zone CNAMEs.
+// clientIP selects view values and may be nil. order is config.AQueryCNAMEFirst or
+// config.AQueryAFirst. An empty result means the name has no matching record.
+func (s *Storage) Resolve(domains []string, queryName string, recordType dns.RecordType, clientIP net.IP, order string) []Match {
+	var matches []Match
+	visited := make(map[string]bool)
+	match := MatchExact
+
+	name := dns.TrimDot(strings.ToLower(queryName))
+	for depth := 0; depth < MaxCNAMEDepth && !visited[name] && InZone(domains, name); depth++ {
+		visited[name] = true
+
+		record, how, ok := s.lookup(domains, name, recordType, order)
+		if !ok {
+			break
+		}
+		if match == MatchCNAME {
+			how = MatchCNAME
+		}
+		matches = append(matches, Match{Name: name, Match: how, Values: record.ValuesFor(clientIP), Record: record})
+
+		// Only A queries continue through a CNAME to the target's addresses
+		if record.Type != dns.RecordTypeCNAME || recordType != dns.RecordTypeA {
+			break
+		}
+		name = dns.TrimDot(strings.ToLower(record.ValueFor(clientIP)))
+		match = MatchCNAME
+	}
+
+	return matches
+}
+
+// lookup finds the record answering one name in the order the plugin checks
+// them: a CNAME, then the queried type, then the catch-all record. With
+// config.AQueryAFirst, an A query checks the name's A record before its CNAME.
+// Records whose AllowedTypes leave out recordType are skipped.
+func (s *Storage) lookup(domains []string, name string, recordType dns.RecordType, order string) (*dns.Record, string, bool) {
+	domain, label, ok := SplitName(domains, name)
+	if !ok {
+		return nil, "", false
+	}
+
+	if recordType == dns.RecordTypeA && order == config.AQueryAFirst {
+		if record, err := s.GetRecord(domain, label, recordType); err == nil && record.AllowsQuery(recordType) {
+			return record, exactMatch(record, label), true
+		}
+	}
+	// A CNAME answers every query type at its name (RFC 1034 3.6.2)
+	if record, err := s.GetRecord(domain, label, dns.RecordTypeCNAME); err == nil && record.AllowsQuery(recordType) {
+		return record, exactMatch(record, label), true
+	}
+	if record, err := s.GetRecord(domain, label, recordType); err == nil && record.AllowsQuery(recordType) {
+		return record, exactMatch(record, label), true
+	}
+
+	// Names without any record of their own fall back to the catch-all record
+	if recordType == dns.RecordTypeA || recordType == dns.RecordTypeCNAME {
+		if _, err := s.GetRecords(domain, label); err != nil {
+			// An A catch-all cannot answer a CNAME query
+			if record, _, ok := s.DefaultRecord(domains, name); ok && (record.Type == recordType || record.Type == dns.RecordTypeCNAME) && record.AllowsQuery(recordType) {
+				return record, MatchDefault, true
+			}
+		}
+	}
+
+	return nil, "", false
+}
+
+// exactMatch returns how a record stored under or aliased to label was reached
+func exactMatch(record *dns.Record, label string) string {
+	if record.Name != label {
+		return MatchAlias
+	}
+	return MatchExact
+}
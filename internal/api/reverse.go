@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// publishReverse rebuilds the address -> A record index from a published view.
+// Every address a record can answer with is indexed, including view values.
+// Catch-all records answer for many names and are left out.
+func (s *Storage) publishReverse(view map[string]map[string]dns.RecordSet) {
+	index := make(map[string][]*dns.Record)
+	for _, domainRecords := range view {
+		for name, set := range domainRecords {
+			if name == DefaultRecordName {
+				continue
+			}
+			// Alias entries share the record of the name they point at
+			record := set.Get(dns.RecordTypeA)
+			if record == nil || record.Name != name {
+				continue
+			}
+
+			for _, addr := range recordAddresses(record) {
+				index[addr] = append(index[addr], record)
+			}
+		}
+	}
+
+	// Keep answers stable across rebuilds regardless of map order
+	for _, records := range index {
+		sort.Slice(records, func(i, j int) bool { return records[i].FQDN() < records[j].FQDN() })
+	}
+	s.reverse.Store(&index)
+}
+
+// recordAddresses returns every distinct address an A record answers with
+func recordAddresses(record *dns.Record) []string {
+	seen := make(map[string]bool)
+	var addrs []string
+	add := func(values ...string) {
+		for _, value := range values {
+			ip := net.ParseIP(value).To4()
+			if ip == nil || seen[ip.String()] {
+				continue
+			}
+			seen[ip.String()] = true
+			addrs = append(addrs, ip.String())
+		}
+	}
+
+	add(record.Value)
+	add(record.Values...)
+	for _, view := range record.Views {
+		add(view.Value)
+		add(view.Values...)
+	}
+	return addrs
+}
+
+// ReverseLookup returns the A records answering with ip, ordered by name. It
+// returns nothing unless the storage was created with ReverseIndex set.
+func (s *Storage) ReverseLookup(ip net.IP) []*dns.Record {
+	index := s.reverse.Load()
+	if index == nil || ip.To4() == nil {
+		return nil
+	}
+
+	now := time.Now()
+	var records []*dns.Record
+	for _, record := range (*index)[ip.To4().String()] {
+		if !record.IsExpired(now) {
+			records = append(records, record)
+		}
+	}
+	return records
+}
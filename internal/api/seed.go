@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// loadSeeds reads the seed records file, a JSON array of records in the bulk
+// upsert format. Seed records are validated like any other record but are never
+// written to the records file.
+func (s *Storage) loadSeeds(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var records []*dns.Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to decode seed records: %w", err)
+	}
+
+	seeds := make(map[string]map[string]dns.RecordSet)
+	for _, record := range records {
+		if err := s.prepareRecord(record); err != nil {
+			return fmt.Errorf("seed record %s/%s: %w", record.Domain, record.Name, err)
+		}
+		if record.ExpiresAt != nil {
+			return fmt.Errorf("seed record %s/%s: seed records cannot expire", record.Domain, record.Name)
+		}
+
+		set := seeds[record.Domain][record.Name]
+		if set.Get(record.Type) != nil {
+			return fmt.Errorf("seed record %s/%s: duplicate %s record", record.Domain, record.Name, record.Type)
+		}
+		if err := set.CheckConflict(record.Type); err != nil {
+			return fmt.Errorf("seed record %s/%s: %w", record.Domain, record.Name, err)
+		}
+		if seeds[record.Domain] == nil {
+			seeds[record.Domain] = make(map[string]dns.RecordSet)
+		}
+		seeds[record.Domain][record.Name], _ = set.Put(record)
+	}
+
+	s.seeds = seeds
+	return nil
+}
+
+// checkSeed returns an error if a name holds seed records, which cannot be
+// changed or deleted
+func (s *Storage) checkSeed(domain, name string) error {
+	if _, ok := s.seeds[domain][name]; !ok {
+		return nil
+	}
+	fqdn := name + "." + domain
+	if name == "" {
+		fqdn = domain + " (root domain)"
+	}
+	return dns.Errorf(dns.ErrImmutableRecord, "%s holds seed records and cannot be changed", fqdn)
+}
+
+// withSeeds returns the stored records of a domain with the seed records added.
+// Seed records replace every stored record of their name. The result shares
+// record sets with the stored records and must not be modified. The caller must
+// hold the lock.
+func (s *Storage) withSeeds(domain string) map[string]dns.RecordSet {
+	seeds := s.seeds[domain]
+	if len(seeds) == 0 {
+		return s.records[domain]
+	}
+
+	merged := make(map[string]dns.RecordSet, len(s.records[domain])+len(seeds))
+	for name, set := range s.records[domain] {
+		merged[name] = set
+	}
+	for name, set := range seeds {
+		merged[name] = set
+	}
+	return merged
+}
+
+// seededDomains returns every domain holding stored or seed records. The caller
+// must hold the lock.
+func (s *Storage) seededDomains() []string {
+	domains := make([]string, 0, len(s.records)+len(s.seeds))
+	for domain := range s.records {
+		domains = append(domains, domain)
+	}
+	for domain := range s.seeds {
+		if _, ok := s.records[domain]; !ok {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
@@ -3,45 +3,150 @@ package api
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"netbird-coredns/internal/config"
 	"netbird-coredns/internal/logger"
+	"netbird-coredns/internal/process"
 )
 
 // Server represents the HTTP API server
 type Server struct {
-	storage    *Storage
-	httpServer *http.Server
-	port       int
+	storage     *Storage
+	maintenance *Maintenance
+	config      *config.Config
+	httpServer  *http.Server
+	port        int
+
+	// domainsMu guards config.Domains, which grows when the NetBird account
+	// domain is discovered after the server has started
+	domainsMu sync.RWMutex
+
+	// dnsReady is set once CoreDNS is serving
+	dnsReady atomic.Bool
+
+	// startedAt is when the service started; processes lists its child processes
+	startedAt time.Time
+	processes atomic.Pointer[func() []process.Status]
 }
 
-// NewServer creates a new API server
-func NewServer(storage *Storage, port int) *Server {
+// NewServer creates a new API server. It keeps its own copy of cfg, so the
+// caller may go on changing cfg while requests are served.
+func NewServer(storage *Storage, cfg *config.Config) *Server {
+	serverConfig := *cfg
+	serverConfig.Domains = append([]string(nil), cfg.Domains...)
+
 	return &Server{
-		storage: storage,
-		port:    port,
+		storage:     storage,
+		maintenance: NewMaintenance(cfg.RecordsFile),
+		config:      &serverConfig,
+		port:        cfg.APIPort,
+		startedAt:   time.Now(),
 	}
 }
 
+// AddDomain adds a domain discovered after the server started and reports
+// whether it was new
+func (s *Server) AddDomain(domain string) bool {
+	s.domainsMu.Lock()
+	defer s.domainsMu.Unlock()
+	return s.config.AddDomain(domain)
+}
+
+// domains returns the configured domains
+func (s *Server) domains() []string {
+	s.domainsMu.RLock()
+	defer s.domainsMu.RUnlock()
+	return s.config.Domains
+}
+
+// recordDomain returns the domain given to records created without one
+func (s *Server) recordDomain() (string, bool) {
+	s.domainsMu.RLock()
+	defer s.domainsMu.RUnlock()
+	return s.config.RecordDomain()
+}
+
+// SetDNSReady marks the DNS server as running, which lifts the mutation gate
+// enabled by NBDNS_API_WAIT_FOR_DNS
+func (s *Server) SetDNSReady() {
+	s.dnsReady.Store(true)
+}
+
+// SetProcessLister sets the function used to report child processes on the uptime endpoint
+func (s *Server) SetProcessLister(list func() []process.Status) {
+	s.processes.Store(&list)
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	mux := http.NewServeMux()
 
 	// Register handlers
-	mux.HandleFunc("/health", s.HealthHandler)
+	mux.HandleFunc(s.config.HealthPath, s.ConfiguredHealthHandler)
+	if s.config.HealthPath != "/health" {
+		// Keep the original endpoint for existing probes
+		mux.HandleFunc("/health", s.HealthHandler)
+	}
 	mux.HandleFunc("/api/v1/records", s.RecordHandler)
 	mux.HandleFunc("/api/v1/records/", s.RecordHandler)
+	mux.HandleFunc("/api/v1/domains", s.ListDomainsHandler)
+	mux.HandleFunc("/api/v1/generation", s.GenerationHandler)
+	mux.HandleFunc("/api/v1/uptime", s.UptimeHandler)
+	mux.HandleFunc("/api/v1/maintenance", s.MaintenanceHandler)
+	mux.HandleFunc("/api/v1/snapshot", s.SnapshotHandler)
+	mux.HandleFunc("/api/v1/import", s.ImportHandler)
+	mux.HandleFunc("/api/v1/export", s.ExportHandler)
+	mux.HandleFunc("/api/v1/diff", s.DiffHandler)
+	mux.HandleFunc("/api/v1/apply", s.ApplyHandler)
+	mux.HandleFunc("/api/v1/resolve", s.ResolveHandler)
+	mux.HandleFunc("/api/v1/validate-all", s.ValidateAllHandler)
+	mux.HandleFunc("/api/v1/forward-test", s.ForwardTestHandler)
+	mux.Handle("/metrics", promhttp.HandlerFor(newMetricsRegistry(), promhttp.HandlerOpts{}))
+
+	// Wrap handlers with middleware (outermost last)
+	var handler http.Handler = mux
+	handler = concurrencyLimitMiddleware(s.config.APIMaxConcurrent, handler)
+	handler = readinessMiddleware(s.config.APIWaitForDNS, s.dnsReady.Load, handler)
+	handler = readOnlyMiddleware(s.config.IsFollower(), handler)
+	handler = gzipMiddleware(handler)
+	handler = corsMiddleware(s.config.CORSOrigins, handler)
+	handler = accessLogMiddleware(s.config.APIAccessLog, s.config.TrustedProxies, handler)
+
+	// h2c lets clients speak HTTP/2 without TLS; HTTP/1.1 clients keep working
+	if s.config.APIH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+
+	// Bound to the NetBird interface, the API only listens on loopback until
+	// NetBird is connected and its address is known
+	addr := fmt.Sprintf(":%d", s.port)
+	if s.config.NetBirdInterface != "" {
+		addr = net.JoinHostPort("127.0.0.1", strconv.Itoa(s.port))
+	}
 
 	s.httpServer = &http.Server{
-		Addr:         fmt.Sprintf(":%d", s.port),
-		Handler:      mux,
+		Addr:         addr,
+		Handler:      handler,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	logger.Info("Starting API server on port %d", s.port)
+	if s.config.APIH2C {
+		logger.Info("Starting API server on port %d (HTTP/1.1 and h2c)", s.port)
+	} else {
+		logger.Info("Starting API server on port %d", s.port)
+	}
 
 	go func() {
 		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -52,12 +157,34 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop gracefully stops the HTTP server
+// ListenOn serves the API on another address, such as the NetBird interface's,
+// in addition to the one it was started on
+func (s *Server) ListenOn(host string) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(s.port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", host, err)
+	}
+	logger.Info("API server listening on %s", listener.Addr())
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("API server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Stop gracefully stops the HTTP server, waiting for active requests until ctx
+// is done. Connections still open then are closed.
 func (s *Server) Stop(ctx context.Context) error {
 	if s.httpServer == nil {
 		return nil
 	}
 
 	logger.Info("Stopping API server...")
-	return s.httpServer.Shutdown(ctx)
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		s.httpServer.Close()
+		return err
+	}
+	return nil
 }
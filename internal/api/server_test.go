@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"netbird-coredns/internal/config"
+)
+
+func TestServerAddDomainWhileServing(t *testing.T) {
+	storage, path := newTestStorage(t, StorageOptions{})
+	cfg := &config.Config{Domains: []string{"example.com"}, RecordsFile: path}
+	s := NewServer(storage, cfg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				s.ListDomainsHandler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/domains", nil))
+			}
+		}()
+	}
+	if !s.AddDomain("netbird.cloud") {
+		t.Error("AddDomain() reported a new domain as already configured")
+	}
+	if s.AddDomain("NETBIRD.cloud") {
+		t.Error("AddDomain() added a configured domain again")
+	}
+	wg.Wait()
+
+	rec := httptest.NewRecorder()
+	s.ListDomainsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/domains", nil))
+	var domains []DomainInfo
+	if err := json.NewDecoder(rec.Body).Decode(&domains); err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 || domains[1].Domain != "netbird.cloud" {
+		t.Errorf("domains = %+v, want example.com and netbird.cloud", domains)
+	}
+
+	// The caller's configuration is not shared with the server
+	if len(cfg.Domains) != 1 {
+		t.Errorf("caller's domains = %v, want them unchanged", cfg.Domains)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// signatureSuffix is appended to the records file path to locate its detached signature
+const signatureSuffix = ".sig"
+
+// ParsePublicKey decodes a base64-encoded ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key length: got %d bytes, want %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ParsePrivateKey decodes a base64-encoded ed25519 private key or 32-byte seed
+func ParsePrivateKey(encoded string) (ed25519.PrivateKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key encoding: %w", err)
+	}
+
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	default:
+		return nil, fmt.Errorf("invalid private key length: got %d bytes, want %d or %d", len(key), ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+}
+
+// maxPreviousSignatures bounds how many signatures a signature file keeps
+// besides the newest one while a data file is being replaced
+const maxPreviousSignatures = 2
+
+// verifySignature checks data against the detached signatures stored next to
+// path, one per line; data is accepted if any of them matches
+func (s *Storage) verifySignature(path string, data []byte) error {
+	encoded, err := os.ReadFile(path + signatureSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to read records signature: %w", err)
+	}
+
+	for _, line := range strings.Fields(string(encoded)) {
+		signature, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return fmt.Errorf("invalid records signature encoding: %w", err)
+		}
+		if ed25519.Verify(s.publicKey, data, signature) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("records signature verification failed for %s", path)
+}
+
+// writeSignature signs data and atomically replaces the detached signature
+// file for path. With keepPrevious, the signatures on file are kept after the
+// new one, so the old data and the new data both verify while the data file
+// is replaced; the next call without it drops them again.
+func (s *Storage) writeSignature(path string, data []byte, keepPrevious bool) error {
+	signatures := []string{base64.StdEncoding.EncodeToString(ed25519.Sign(s.privateKey, data))}
+
+	sigPath := path + signatureSuffix
+	if keepPrevious {
+		if current, err := os.ReadFile(sigPath); err == nil {
+			previous := strings.Fields(string(current))
+			if len(previous) > maxPreviousSignatures {
+				previous = previous[:maxPreviousSignatures]
+			}
+			signatures = append(signatures, previous...)
+		}
+	}
+
+	tempFile := sigPath + ".tmp"
+	if err := os.WriteFile(tempFile, []byte(strings.Join(signatures, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write records signature: %w", err)
+	}
+	defer os.Remove(tempFile) // Clean up on error
+
+	if err := os.Rename(tempFile, sigPath); err != nil {
+		return fmt.Errorf("failed to rename records signature: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"os"
+	"testing"
+
+	"netbird-coredns/pkg/dns"
+)
+
+func TestSignatureCoversReplacement(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, path := newTestStorage(t, StorageOptions{PublicKey: public, PrivateKey: private})
+
+	if err := s.SetRecord(&dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"}); err != nil {
+		t.Fatalf("SetRecord() failed: %v", err)
+	}
+	oldData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifySignature(path, oldData); err != nil {
+		t.Fatalf("saved file does not verify: %v", err)
+	}
+
+	// Between signing and renaming, both the old and the new data verify
+	newData := []byte("{}\n")
+	if err := s.writeSignature(path, newData, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifySignature(path, oldData); err != nil {
+		t.Errorf("old data does not verify while being replaced: %v", err)
+	}
+	if err := s.verifySignature(path, newData); err != nil {
+		t.Errorf("new data does not verify while being replaced: %v", err)
+	}
+
+	// The final signature only covers the new data
+	if err := s.writeSignature(path, newData, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.verifySignature(path, oldData); err == nil {
+		t.Error("old data still verifies after the replacement")
+	}
+	if err := s.verifySignature(path, newData); err != nil {
+		t.Errorf("new data does not verify: %v", err)
+	}
+}
+
+func TestSignedStorageReloads(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, path := newTestStorage(t, StorageOptions{PublicKey: public, PrivateKey: private})
+	for _, value := range []string{"100.64.0.10", "100.64.0.11"} {
+		if err := s.SetRecord(&dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: value}); err != nil {
+			t.Fatalf("SetRecord() failed: %v", err)
+		}
+	}
+
+	reader, err := NewStorageWithOptions(path, StorageOptions{PublicKey: public})
+	if err != nil {
+		t.Fatalf("signed records do not load: %v", err)
+	}
+	record, err := reader.GetRecord("example.com", "web", dns.RecordTypeA)
+	if err != nil || record.Value != "100.64.0.11" {
+		t.Errorf("GetRecord() = %v, %v, want the last saved value", record, err)
+	}
+}
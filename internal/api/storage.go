@@ -1,32 +1,182 @@
 package api
 
 import (
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"netbird-coredns/internal/logger"
 	"netbird-coredns/pkg/dns"
 )
 
+// shardSuffix is the file extension of per-domain shard files
+const shardSuffix = ".json"
+
+// Snapshot files are named records-<UTC timestamp>.json
+const (
+	snapshotPrefix     = "records-"
+	snapshotTimeFormat = "20060102T150405.000Z"
+)
+
+// saveRetryDelay is the wait before the first retry of a failed write; it
+// doubles with every further retry
+const saveRetryDelay = 100 * time.Millisecond
+
+// undoEntry is the record set a name held before a change
+type undoEntry struct {
+	domain string
+	name   string
+	set    dns.RecordSet
+}
+
 // Storage manages persistent DNS records storage
 type Storage struct {
-	filePath string
-	mu       sync.RWMutex
-	records  map[string]map[string]*dns.Record // domain -> name -> record
+	filePath   string
+	shardDir   string
+	rejectApex bool
+	rejectDups bool
+	limits     dns.Limits
+	mu         sync.RWMutex
+	// writeMu serializes changes from their first edit until they are saved or
+	// rolled back, so commit can release mu while a failed save waits to retry
+	writeMu    sync.Mutex
+	records    map[string]map[string]dns.RecordSet // domain -> name -> records by type
+	publicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+
+	// generation increases whenever the records change; loadedSum detects changes on reload
+	generation uint64
+	loadedSum  [sha256.Size]byte
+	// shards records how each shard file looked when it was last read or
+	// written, so reloading sharded storage only parses the shards that changed
+	shards map[string]shardState
+
+	// loadedVersion is the oldest format version seen by the last load
+	loadedVersion int
+
+	// lastSave is when the records were last written successfully; saveErr is
+	// the error of the last write, nil once a write succeeds again
+	lastSave time.Time
+	saveErr  error
+	// saveRetries is how often a failed write is retried before giving up
+	saveRetries int
+	// followSymlinks writes a symlinked file through to its target
+	followSymlinks bool
+	// validateOnLoad drops records failing validation when loading; invalid
+	// holds what the last load dropped
+	validateOnLoad bool
+	invalid        []string
+	// undo holds the record sets changed since the last commit, replayed in
+	// reverse to roll back a change that could not be saved
+	undo []undoEntry
+
+	// view is a read-only copy of records published after every change, so
+	// GetRecord on the DNS query path never waits for the lock
+	view atomic.Pointer[map[string]map[string]dns.RecordSet]
+	// reverse maps IPv4 addresses to the A records answering with them; it is
+	// only maintained when reverseIndex is set
+	reverse      atomic.Pointer[map[string][]*dns.Record]
+	reverseIndex bool
+
+	// seeds holds the read-only records loaded from the seed file at startup;
+	// they are served on top of the stored records and never saved
+	seeds map[string]map[string]dns.RecordSet
+
+	// softDelete keeps deleted records restorable for this long; 0 deletes
+	// them outright. trash holds them, oldest first.
+	softDelete time.Duration
+	trash      []DeletedRecord
+}
+
+// StorageOptions holds optional storage settings
+type StorageOptions struct {
+	// PublicKey, when set, is used to verify the records file signature on every load
+	PublicKey ed25519.PublicKey
+	// PrivateKey, when set, is used to re-sign the records file after every save
+	PrivateKey ed25519.PrivateKey
+	// ShardDir, when set, stores records as one <domain>.json file per domain in
+	// this directory instead of a single records file, so a write only rewrites
+	// the affected domain
+	ShardDir string
+	// RejectApex refuses records with an empty or "@" name instead of storing
+	// them as zone apex records
+	RejectApex bool
+	// RejectDuplicateValues refuses records listing the same value more than
+	// once instead of dropping the repeats
+	RejectDuplicateValues bool
+	// Limits tightens the record name and value lengths accepted on write;
+	// zero fields keep the protocol maxima
+	Limits dns.Limits
+	// Migrate rewrites files stored in an older format version in the current
+	// one after loading them. Only the instance that writes records should set it.
+	Migrate bool
+	// ReverseIndex maintains an address -> A record index for ReverseLookup
+	ReverseIndex bool
+	// SaveRetries is how often a failed write of the records is retried, with
+	// a doubling delay starting at 100ms, before the change is rolled back
+	SaveRetries int
+	// SeedFile, when set, is a JSON array of records that are always served and
+	// cannot be changed or deleted. They replace any stored records of the same
+	// name and survive every bulk replace, apply and restore.
+	SeedFile string
+	// SoftDeleteRetention, when set, keeps records removed by DeleteRecord in
+	// a file next to the records for this long, so RestoreRecord can put them back
+	SoftDeleteRetention time.Duration
+	// FollowSymlinks writes records files that are symlinks to their targets.
+	// Otherwise writing to a symlink fails, as the atomic rename would replace
+	// the symlink with a regular file.
+	FollowSymlinks bool
+	// ValidateOnLoad validates every loaded record and leaves out the invalid
+	// ones, so a records file written by other means cannot serve them. Saving
+	// would then drop them from disk, so it is meant for read-only storage.
+	ValidateOnLoad bool
 }
 
 // NewStorage creates a new storage instance
 func NewStorage(filePath string) (*Storage, error) {
+	return NewStorageWithOptions(filePath, StorageOptions{})
+}
+
+// NewStorageWithOptions creates a new storage instance with the given options
+func NewStorageWithOptions(filePath string, opts StorageOptions) (*Storage, error) {
 	s := &Storage{
-		filePath: filePath,
-		records:  make(map[string]map[string]*dns.Record),
+		filePath:       filePath,
+		loadedVersion:  RecordsFormatVersion,
+		shardDir:       opts.ShardDir,
+		rejectApex:     opts.RejectApex,
+		rejectDups:     opts.RejectDuplicateValues,
+		limits:         opts.Limits,
+		saveRetries:    opts.SaveRetries,
+		records:        make(map[string]map[string]dns.RecordSet),
+		publicKey:      opts.PublicKey,
+		privateKey:     opts.PrivateKey,
+		reverseIndex:   opts.ReverseIndex,
+		softDelete:     opts.SoftDeleteRetention,
+		followSymlinks: opts.FollowSymlinks,
+		validateOnLoad: opts.ValidateOnLoad,
 	}
+	if opts.SeedFile != "" {
+		if err := s.loadSeeds(opts.SeedFile); err != nil {
+			return nil, fmt.Errorf("failed to load seed records: %w", err)
+		}
+	}
+	s.publish()
 
 	// Ensure directory exists
 	dir := filepath.Dir(filePath)
+	if s.shardDir != "" {
+		dir = s.shardDir
+	}
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create storage directory: %w", err)
 	}
@@ -38,92 +188,331 @@ func NewStorage(filePath string) (*Storage, error) {
 			return nil, fmt.Errorf("failed to load records: %w", err)
 		}
 	}
+	if s.softDelete > 0 {
+		if err := s.loadTrash(); err != nil {
+			return nil, fmt.Errorf("failed to load deleted records: %w", err)
+		}
+	}
+
+	if opts.Migrate && s.loadedVersion < RecordsFormatVersion {
+		from := s.loadedVersion
+		domains := make([]string, 0, len(s.records))
+		for domain := range s.records {
+			domains = append(domains, domain)
+		}
+		if err := s.save(time.Sleep, domains...); err != nil {
+			return nil, fmt.Errorf("failed to migrate records from format version %d: %w", from, err)
+		}
+		s.loadedVersion = RecordsFormatVersion
+		logger.Info("Migrated records from format version %d to %d", from, RecordsFormatVersion)
+	}
 
 	return s, nil
 }
 
-// GetRecord retrieves a specific record
-func (s *Storage) GetRecord(domain, name string) (*dns.Record, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// GetRecord retrieves the record of the given type stored under a name. It
+// reads the published view and takes no lock.
+func (s *Storage) GetRecord(domain, name string, recordType dns.RecordType) (*dns.Record, error) {
+	records, err := s.GetRecords(domain, name)
+	if err != nil {
+		return nil, err
+	}
 
-	// Normalize "@" to empty string for root domain records
-	if name == "@" {
-		name = ""
+	record := records.Get(recordType)
+	if record == nil {
+		return nil, notFound(dns.TrimDot(domain), normalizeName(name), recordType)
 	}
+	return record, nil
+}
 
-	domainRecords, ok := s.records[domain]
+// View returns the published read-only records as served to DNS queries,
+// including seed records and alias names. The maps must not be modified.
+func (s *Storage) View() map[string]map[string]dns.RecordSet {
+	return *s.view.Load()
+}
+
+// GetRecords retrieves the records of every type stored under a name. It
+// reads the published view and takes no lock.
+func (s *Storage) GetRecords(domain, name string) (dns.RecordSet, error) {
+	domain = dns.TrimDot(domain)
+	name = normalizeName(name)
+
+	domainRecords, ok := (*s.view.Load())[domain]
 	if !ok {
-		return nil, fmt.Errorf("no records found for domain: %s", domain)
+		return nil, dns.Errorf(dns.ErrRecordNotFound, "no records found for domain: %s", domain)
 	}
 
-	record, ok := domainRecords[name]
-	if !ok {
-		if name == "" {
-			return nil, fmt.Errorf("record not found: %s (root domain)", domain)
-		}
-		return nil, fmt.Errorf("record not found: %s.%s", name, domain)
+	// Expired records are treated as absent even before they are purged
+	now := time.Now()
+	records := domainRecords[name].Without(func(r *dns.Record) bool { return r.IsExpired(now) })
+	if len(records) == 0 {
+		return nil, notFound(domain, name, "")
 	}
 
-	return record, nil
+	return records, nil
+}
+
+// normalizeName trims the trailing dot and maps "@" to "" for root domain records
+func normalizeName(name string) string {
+	name = dns.TrimDot(name)
+	if name == "@" {
+		return ""
+	}
+	return name
+}
+
+// notFound returns the error for a missing name, or a missing type of it when recordType is set
+func notFound(domain, name string, recordType dns.RecordType) error {
+	fqdn := name + "." + domain
+	if name == "" {
+		fqdn = domain + " (root domain)"
+	}
+	if recordType != "" {
+		return dns.Errorf(dns.ErrRecordNotFound, "%s record not found: %s", recordType, fqdn)
+	}
+	return dns.Errorf(dns.ErrRecordNotFound, "record not found: %s", fqdn)
+}
+
+// Generation returns a counter that increases whenever the records change, either
+// through this storage or on a reload that picked up different data from disk.
+// It starts over when the process restarts.
+func (s *Storage) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.generation
 }
 
 // ListRecords returns all records
-func (s *Storage) ListRecords() map[string]map[string]*dns.Record {
+func (s *Storage) ListRecords() map[string]map[string]dns.RecordSet {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	// Deep copy to prevent external modification
-	result := make(map[string]map[string]*dns.Record)
-	for domain, records := range s.records {
-		result[domain] = make(map[string]*dns.Record)
-		for name, record := range records {
+	now := time.Now()
+	result := make(map[string]map[string]dns.RecordSet)
+	for _, domain := range s.seededDomains() {
+		result[domain] = copyRecords(s.withSeeds(domain), now)
+	}
+
+	return result
+}
+
+// copyRecords deep-copies the unexpired records of a domain
+func copyRecords(records map[string]dns.RecordSet, now time.Time) map[string]dns.RecordSet {
+	result := make(map[string]dns.RecordSet)
+	for name, set := range records {
+		var copied dns.RecordSet
+		for _, record := range set {
+			if record.IsExpired(now) {
+				continue
+			}
 			recordCopy := *record
-			result[domain][name] = &recordCopy
+			copied = append(copied, &recordCopy)
+		}
+		if len(copied) > 0 {
+			result[name] = copied
 		}
 	}
-
 	return result
 }
 
 // ListRecordsByDomain returns all records for a specific domain
-func (s *Storage) ListRecordsByDomain(domain string) map[string]*dns.Record {
+func (s *Storage) ListRecordsByDomain(domain string) map[string]dns.RecordSet {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	domainRecords, ok := s.records[domain]
-	if !ok {
-		return make(map[string]*dns.Record)
-	}
+	return copyRecords(s.withSeeds(dns.TrimDot(domain)), time.Now())
+}
 
-	// Deep copy
-	result := make(map[string]*dns.Record)
-	for name, record := range domainRecords {
-		recordCopy := *record
-		result[name] = &recordCopy
+// RecordCounts returns the number of unexpired records per domain
+func (s *Storage) RecordCounts() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	counts := make(map[string]int)
+	for _, domain := range s.seededDomains() {
+		for _, set := range s.withSeeds(domain) {
+			for _, record := range set {
+				if !record.IsExpired(now) {
+					counts[domain]++
+				}
+			}
+		}
 	}
 
-	return result
+	return counts
 }
 
 // SetRecord adds or updates a record
 func (s *Storage) SetRecord(record *dns.Record) error {
-	if err := record.Validate(); err != nil {
+	if err := s.prepareRecord(record); err != nil {
+		return err
+	}
+	if err := s.checkSeed(record.Domain, record.Name); err != nil {
+		return err
+	}
+
+	s.lockWrite()
+	defer s.unlockWrite()
+
+	if err := s.records[record.Domain][record.Name].CheckConflict(record.Type); err != nil {
+		return fmt.Errorf("invalid record: %w", err)
+	}
+	if err := checkAliases(s.records[record.Domain], record); err != nil {
 		return fmt.Errorf("invalid record: %w", err)
 	}
+	s.putRecord(record)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	// Persist to disk
+	return s.commit(record.Domain)
+}
 
-	// Normalize "@" to empty string for root domain records
-	name := record.Name
-	if name == "@" {
-		name = ""
+// UpsertResult is the outcome of a single record in a bulk upsert
+type UpsertResult struct {
+	Domain string         `json:"domain"`
+	Name   string         `json:"name"`
+	Type   dns.RecordType `json:"type,omitempty"`
+	// Status is created, updated, deleted (pruned) or invalid
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Line is the source line of the record in an import
+	Line int `json:"line,omitempty"`
+}
+
+// SetRecords adds or updates all records in one atomic operation. Nothing is
+// changed unless every record is valid. With prune, records of the affected
+// domains that are not in records are deleted, making the payload the desired
+// state of those domains.
+func (s *Storage) SetRecords(records []*dns.Record, prune bool) ([]UpsertResult, error) {
+	results := make([]UpsertResult, len(records))
+	invalid := 0
+	for i, record := range records {
+		// A null entry in the payload decodes to a nil record
+		if record == nil {
+			results[i] = UpsertResult{Status: "invalid", Error: dns.Errorf(dns.ErrInvalidRecord, "invalid record: record is null").Error()}
+			invalid++
+			continue
+		}
+		err := s.prepareRecord(record)
+		if err == nil {
+			err = s.checkSeed(record.Domain, record.Name)
+		}
+		if err != nil {
+			results[i] = UpsertResult{Domain: record.Domain, Name: record.Name, Type: record.Type, Status: "invalid", Error: err.Error()}
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return results, dns.Errorf(dns.ErrInvalidRecord, "%d of %d records are invalid", invalid, len(records))
 	}
 
-	// Ensure domain map exists
-	if s.records[record.Domain] == nil {
-		s.records[record.Domain] = make(map[string]*dns.Record)
+	s.lockWrite()
+	defer s.unlockWrite()
+
+	// Check CNAME conflicts against the stored records as they will be once the
+	// earlier records of the batch are applied, so a rejected batch changes nothing
+	type key struct{ domain, name string }
+	pending := make(map[key]dns.RecordSet)
+	for i, record := range records {
+		k := key{record.Domain, record.Name}
+		set, ok := pending[k]
+		if !ok {
+			set = s.records[record.Domain][record.Name]
+			if prune {
+				// Pruned records are replaced by the batch, so only the batch can conflict
+				set = nil
+			}
+		}
+		if err := set.CheckConflict(record.Type); err != nil {
+			results[i] = UpsertResult{Domain: record.Domain, Name: record.Name, Type: record.Type, Status: "invalid", Error: err.Error()}
+			invalid++
+			continue
+		}
+		if !prune {
+			if err := checkAliases(s.records[record.Domain], record); err != nil {
+				results[i] = UpsertResult{Domain: record.Domain, Name: record.Name, Type: record.Type, Status: "invalid", Error: err.Error()}
+				invalid++
+				continue
+			}
+		}
+		pending[k], _ = set.Put(record)
+	}
+	if invalid > 0 {
+		return results, dns.Errorf(dns.ErrInvalidRecord, "%d of %d records are invalid", invalid, len(records))
+	}
+
+	var domains []string
+	wanted := make(map[string]map[string]map[dns.RecordType]bool)
+	for _, record := range records {
+		if wanted[record.Domain] == nil {
+			wanted[record.Domain] = make(map[string]map[dns.RecordType]bool)
+			domains = append(domains, record.Domain)
+		}
+		if wanted[record.Domain][record.Name] == nil {
+			wanted[record.Domain][record.Name] = make(map[dns.RecordType]bool)
+		}
+		wanted[record.Domain][record.Name][record.Type] = true
+	}
+
+	if prune {
+		for _, domain := range domains {
+			for name, set := range s.records[domain] {
+				kept := set.Without(func(r *dns.Record) bool {
+					if wanted[domain][name][r.Type] {
+						return false
+					}
+					results = append(results, UpsertResult{Domain: domain, Name: name, Type: r.Type, Status: "deleted"})
+					return true
+				})
+				s.setRecords(domain, name, kept)
+			}
+		}
+	}
+
+	for i, record := range records {
+		status := "updated"
+		if !s.putRecord(record) {
+			status = "created"
+		}
+		results[i] = UpsertResult{Domain: record.Domain, Name: record.Name, Type: record.Type, Status: status}
+	}
+
+	if len(domains) == 0 {
+		return results, nil
+	}
+
+	// Persist to disk
+	return results, s.commit(domains...)
+}
+
+// prepareRecord normalizes and validates a record before it is stored, and fills
+// in the TTL default and absolute expiry
+func (s *Storage) prepareRecord(record *dns.Record) error {
+	record.Normalize()
+	if duplicates := record.DuplicateValues(); len(duplicates) > 0 {
+		if s.rejectDups {
+			return dns.Errorf(dns.ErrInvalidValue, "invalid record: duplicate values: %s", strings.Join(duplicates, ", "))
+		}
+		record.DedupValues()
+	}
+	if err := record.ValidateWithin(s.limits); err != nil {
+		return fmt.Errorf("invalid record: %w", err)
+	}
+
+	if s.shardDir != "" {
+		if _, err := s.shardPath(record.Domain); err != nil {
+			return fmt.Errorf("invalid record: %w", err)
+		}
+	}
+
+	// Normalize "@" to empty string for root domain records
+	if record.Name == "@" {
+		record.Name = ""
+	}
+	if record.Name == "" && s.rejectApex {
+		return dns.Errorf(dns.ErrInvalidRecord, "invalid record: apex records are disabled, record name cannot be empty or @")
 	}
 
 	// Set TTL default if not specified
@@ -131,112 +520,753 @@ func (s *Storage) SetRecord(record *dns.Record) error {
 		record.TTL = 60
 	}
 
-	// Create a copy with normalized name for storage
+	// Convert the relative expiry into an absolute timestamp
+	if record.ExpiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(record.ExpiresIn) * time.Second).UTC()
+		record.ExpiresAt = &expiresAt
+		record.ExpiresIn = 0
+	}
+
+	return nil
+}
+
+// checkAliases returns an error if record's name or aliases clash with the
+// names or aliases of the other records in its domain
+func checkAliases(domainRecords map[string]dns.RecordSet, record *dns.Record) error {
+	aliases := make(map[string]bool, len(record.Aliases))
+	for _, alias := range record.Aliases {
+		if alias == DefaultRecordName {
+			return dns.Errorf(dns.ErrInvalidRecord, "alias cannot be the catch-all name %s", DefaultRecordName)
+		}
+		if _, exists := domainRecords[alias]; exists {
+			return dns.Errorf(dns.ErrInvalidRecord, "alias %s is already the name of a record", alias)
+		}
+		aliases[alias] = true
+	}
+
+	for name, set := range domainRecords {
+		if name == record.Name {
+			continue
+		}
+		for _, other := range set {
+			for _, alias := range other.Aliases {
+				if alias == record.Name {
+					return dns.Errorf(dns.ErrInvalidRecord, "name %s is already an alias of %s", alias, other.Name)
+				}
+				if aliases[alias] {
+					return dns.Errorf(dns.ErrInvalidRecord, "alias %s is already used by %s", alias, other.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// putRecord stores a copy of a prepared record, replacing the record of the same
+// type under its name, and reports whether one was replaced. The caller must
+// hold the write lock and have checked for CNAME conflicts.
+func (s *Storage) putRecord(record *dns.Record) bool {
 	recordCopy := *record
-	recordCopy.Name = name
-	s.records[record.Domain][name] = &recordCopy
+	set, replaced := s.records[record.Domain][record.Name].Put(&recordCopy)
+	s.setRecords(record.Domain, record.Name, set)
+	return replaced
+}
 
-	// Persist to disk
-	return s.save()
+// setRecords stores the record set of a name, removing the name when the set is
+// empty and the domain when it has no names left. The previous set is kept so
+// the change can be rolled back if it cannot be saved. The caller must hold the
+// write lock.
+func (s *Storage) setRecords(domain, name string, set dns.RecordSet) {
+	s.undo = append(s.undo, undoEntry{domain: domain, name: name, set: s.records[domain][name]})
+	s.assign(domain, name, set)
 }
 
-// DeleteRecord removes a record
-func (s *Storage) DeleteRecord(domain, name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// assign stores the record set of a name like setRecords, without keeping the
+// previous set. The caller must hold the write lock.
+func (s *Storage) assign(domain, name string, set dns.RecordSet) {
+	if len(set) == 0 {
+		delete(s.records[domain], name)
+		if len(s.records[domain]) == 0 {
+			delete(s.records, domain)
+		}
+		return
+	}
 
-	// Normalize "@" to empty string for root domain records
-	if name == "@" {
-		name = ""
+	// Ensure domain map exists
+	if s.records[domain] == nil {
+		s.records[domain] = make(map[string]dns.RecordSet)
+	}
+	s.records[domain][name] = set
+}
+
+// DeleteRecord removes the record of the given type stored under a name, or
+// all of the name's records when recordType is empty
+func (s *Storage) DeleteRecord(domain, name string, recordType dns.RecordType) (_ dns.RecordSet, err error) {
+	s.lockWrite()
+	defer s.unlockWrite()
+
+	domain = dns.TrimDot(domain)
+	name = normalizeName(name)
+	if err := s.checkSeed(domain, name); err != nil {
+		return nil, err
 	}
 
 	domainRecords, ok := s.records[domain]
 	if !ok {
-		return fmt.Errorf("no records found for domain: %s", domain)
+		return nil, dns.Errorf(dns.ErrRecordNotFound, "no records found for domain: %s", domain)
 	}
 
-	if _, ok := domainRecords[name]; !ok {
-		if name == "" {
-			return fmt.Errorf("record not found: %s (root domain)", domain)
+	set, ok := domainRecords[name]
+	if !ok {
+		return nil, notFound(domain, name, "")
+	}
+
+	deleted := set
+	var kept dns.RecordSet
+	if recordType != "" {
+		record := set.Get(recordType)
+		if record == nil {
+			return nil, notFound(domain, name, recordType)
 		}
-		return fmt.Errorf("record not found: %s.%s", name, domain)
+		deleted = dns.RecordSet{record}
+		kept = set.Without(func(r *dns.Record) bool { return r.Type == recordType })
 	}
 
-	delete(domainRecords, name)
+	// Keep soft-deleted records restorable before they disappear from the records
+	if s.softDelete > 0 {
+		now := time.Now().UTC()
+		previous := s.trash
+		for _, record := range deleted {
+			s.trash = append(s.trash, DeletedRecord{Record: record, DeletedAt: now})
+		}
+		if err := s.saveTrash(); err != nil {
+			s.trash = previous
+			return nil, fmt.Errorf("failed to save deleted records: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				s.trash = previous
+				s.saveTrash()
+			}
+		}()
+	}
+
+	s.setRecords(domain, name, kept)
 
-	// Clean up empty domain maps
-	if len(domainRecords) == 0 {
-		delete(s.records, domain)
+	// Persist to disk
+	if err = s.commit(domain); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// PurgeExpired removes all expired records and returns how many were removed
+func (s *Storage) PurgeExpired() (int, error) {
+	s.lockWrite()
+	defer s.unlockWrite()
+
+	now := time.Now()
+	removed := 0
+	var touched []string
+	for domain, domainRecords := range s.records {
+		before := removed
+		for name, set := range domainRecords {
+			kept := set.Without(func(r *dns.Record) bool { return r.IsExpired(now) })
+			if len(kept) != len(set) {
+				removed += len(set) - len(kept)
+				s.setRecords(domain, name, kept)
+			}
+		}
+		if removed != before {
+			touched = append(touched, domain)
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
 	}
 
 	// Persist to disk
-	return s.save()
+	return removed, s.commit(touched...)
 }
 
-// load reads records from the file with shared locking
+// Snapshot writes a point-in-time copy of all records to a timestamped file in dir
+// and returns its path. When keep is positive, only the newest keep snapshots are kept.
+func (s *Storage) Snapshot(dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	path := filepath.Join(dir, snapshotPrefix+time.Now().UTC().Format(snapshotTimeFormat)+shardSuffix)
+
+	s.mu.RLock()
+	_, err := s.writeFile(path, encodeRecords(s.records))
+	s.mu.RUnlock()
+	if err != nil {
+		return "", err
+	}
+
+	if keep > 0 {
+		if err := pruneSnapshots(dir, keep); err != nil {
+			return path, err
+		}
+	}
+
+	return path, nil
+}
+
+// pruneSnapshots removes all but the newest keep snapshots in dir
+func pruneSnapshots(dir string, keep int) error {
+	paths, err := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"+shardSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	// Timestamps sort lexically, oldest first
+	sort.Strings(paths)
+	for len(paths) > keep {
+		if err := os.Remove(paths[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old snapshot %s: %w", paths[0], err)
+		}
+		os.Remove(paths[0] + signatureSuffix)
+		paths = paths[1:]
+	}
+
+	return nil
+}
+
+// load reads records from disk. The in-memory records are only replaced once
+// every file has been verified and decoded.
 func (s *Storage) load() error {
-	file, err := os.Open(s.filePath)
+	if s.shardDir != "" {
+		return s.loadShards()
+	}
+
+	data, err := s.readFile(s.filePath)
 	if err != nil {
 		return err
 	}
+
+	// Decode JSON, upgrading older format versions
+	records, version, err := decodeRecords(data)
+	if err != nil {
+		return fmt.Errorf("failed to decode records: %w", err)
+	}
+
+	checksum := sha256.Sum256(data)
+	if s.validateOnLoad {
+		// The file is read on every reload; only report a changed one
+		s.invalid = dropInvalid(records)
+		if checksum != s.loadedSum {
+			logInvalid(s.filePath, s.invalid)
+		}
+	}
+
+	s.loadedVersion = version
+	s.swap(records, checksum)
+	return nil
+}
+
+// shardState is how a shard file looked when it was last read or written
+type shardState struct {
+	modTime time.Time
+	size    int64
+	// inode changes with every write, as shards are replaced by a rename
+	inode   uint64
+	sum     [sha256.Size]byte
+	version int
+	// invalid describes the records left out of the shard by validation
+	invalid []string
+}
+
+// newShardState describes a shard file holding data
+func newShardState(info os.FileInfo, data []byte, version int) shardState {
+	state := shardState{modTime: info.ModTime(), size: info.Size(), sum: sha256.Sum256(data), version: version}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		state.inode = stat.Ino
+	}
+	return state
+}
+
+// unchanged reports whether info describes the file the state was taken from
+func (st shardState) unchanged(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Ino == st.inode && info.Size() == st.size && info.ModTime().Equal(st.modTime)
+}
+
+// loadShards reads one records file per domain from the shard directory. A
+// shard whose file is unchanged since it was last read or written keeps the
+// records held for it, so a reload only reads and decodes the shards that changed.
+func (s *Storage) loadShards() error {
+	paths, err := filepath.Glob(filepath.Join(s.shardDir, "*"+shardSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list record shards: %w", err)
+	}
+
+	records := make(map[string]map[string]dns.RecordSet)
+	shards := make(map[string]shardState, len(paths))
+	oldest := RecordsFormatVersion
+	// Shards that disappeared change the records as well
+	changed := len(paths) != len(s.shards)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		domain := strings.TrimSuffix(filepath.Base(path), shardSuffix)
+		state, ok := s.shards[path]
+		if ok && state.unchanged(info) {
+			if domainRecords := s.records[domain]; len(domainRecords) > 0 {
+				records[domain] = domainRecords
+			}
+		} else {
+			changed = true
+			data, err := s.readFile(path)
+			if err != nil {
+				return err
+			}
+
+			domainRecords, version, err := decodeShard(domain, data)
+			if err != nil {
+				return fmt.Errorf("failed to decode records from %s: %w", path, err)
+			}
+			state = newShardState(info, data, version)
+			if s.validateOnLoad {
+				state.invalid = dropInvalid(map[string]map[string]dns.RecordSet{domain: domainRecords})
+				logInvalid(path, state.invalid)
+			}
+			if len(domainRecords) > 0 {
+				records[domain] = domainRecords
+			}
+		}
+
+		shards[path] = state
+		oldest = min(oldest, state.version)
+	}
+	if !changed {
+		return nil
+	}
+
+	if s.validateOnLoad {
+		s.invalid = nil
+		for _, path := range paths {
+			s.invalid = append(s.invalid, shards[path].invalid...)
+		}
+	}
+	s.shards = shards
+	s.loadedVersion = oldest
+	s.swap(records, shardsChecksum(shards))
+	return nil
+}
+
+// shardsChecksum combines the checksums of all shard files into one
+func shardsChecksum(shards map[string]shardState) [sha256.Size]byte {
+	paths := make([]string, 0, len(shards))
+	for path := range shards {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	sum := sha256.New()
+	for _, path := range paths {
+		state := shards[path]
+		sum.Write([]byte(path))
+		sum.Write(state.sum[:])
+	}
+
+	var checksum [sha256.Size]byte
+	sum.Sum(checksum[:0])
+	return checksum
+}
+
+// dropInvalid removes the records failing validation from records, along with
+// names left without records, and describes each one removed
+func dropInvalid(records map[string]map[string]dns.RecordSet) []string {
+	var invalid []string
+	for domain, names := range records {
+		for name, set := range names {
+			kept := set.Without(func(record *dns.Record) bool {
+				err := record.Validate()
+				if err != nil {
+					invalid = append(invalid, fmt.Sprintf("%s %s: %v", record.FQDN(), record.Type, err))
+				}
+				return err != nil
+			})
+			switch {
+			case len(kept) == 0:
+				delete(names, name)
+			case len(kept) < len(set):
+				names[name] = kept
+			}
+		}
+		if len(names) == 0 {
+			delete(records, domain)
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
+
+// logInvalid reports the records validation left out of a records file
+func logInvalid(path string, invalid []string) {
+	if len(invalid) == 0 {
+		return
+	}
+	logger.Warn("Skipping %d invalid record(s) in %s", len(invalid), path)
+	for _, detail := range invalid {
+		logger.Warn("  %s", detail)
+	}
+}
+
+// InvalidRecords returns how many records the last load left out because they
+// failed validation; always 0 unless ValidateOnLoad is set
+func (s *Storage) InvalidRecords() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.invalid)
+}
+
+// swap replaces the in-memory records with freshly loaded ones, advancing the
+// generation when the data on disk differs from the last load
+func (s *Storage) swap(records map[string]map[string]dns.RecordSet, checksum [sha256.Size]byte) {
+	if checksum != s.loadedSum {
+		s.generation++
+		s.loadedSum = checksum
+	}
+	s.records = records
+	s.undo = nil
+	s.publish()
+}
+
+// publish copies the records into a new read-only view for GetRecord. Stored
+// records and record sets are never modified in place, so copying the maps is
+// enough. The caller must hold the write lock (or own s exclusively).
+func (s *Storage) publish() {
+	view := make(map[string]map[string]dns.RecordSet, len(s.records))
+	for _, domain := range s.seededDomains() {
+		domainRecords := s.withSeeds(domain)
+		names := make(map[string]dns.RecordSet, len(domainRecords))
+		for name, set := range domainRecords {
+			names[name] = set
+		}
+
+		// Aliases answer with the records listing them, but never hide a name's own records
+		for _, set := range domainRecords {
+			for _, record := range set {
+				for _, alias := range record.Aliases {
+					if _, own := domainRecords[alias]; !own {
+						names[alias], _ = names[alias].Put(record)
+					}
+				}
+			}
+		}
+		view[domain] = names
+	}
+	s.view.Store(&view)
+	if s.reverseIndex {
+		s.publishReverse(view)
+	}
+}
+
+// readFile reads a records file with shared locking and verifies its signature
+func (s *Storage) readFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
 
 	// Acquire shared lock for reading
 	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_SH); err != nil {
-		return fmt.Errorf("failed to acquire shared lock: %w", err)
+		return nil, fmt.Errorf("failed to acquire shared lock: %w", err)
 	}
 	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
 
-	// Decode JSON
-	if err := json.NewDecoder(file).Decode(&s.records); err != nil {
-		return fmt.Errorf("failed to decode records: %w", err)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read records: %w", err)
+	}
+
+	// Refuse tampered files before looking at their contents. A symlinked file
+	// is signed next to its target, where writes put the signature.
+	if s.publicKey != nil {
+		if target, err := filepath.EvalSymlinks(path); err == nil {
+			path = target
+		}
+		if err := s.verifySignature(path, data); err != nil {
+			return nil, err
+		}
 	}
 
+	return data, nil
+}
+
+// lockWrite takes the locks a change needs: writeMu, held until the change is
+// saved or rolled back, then the write lock
+func (s *Storage) lockWrite() {
+	s.writeMu.Lock()
+	s.mu.Lock()
+}
+
+// unlockWrite releases the locks taken by lockWrite
+func (s *Storage) unlockWrite() {
+	s.mu.Unlock()
+	s.writeMu.Unlock()
+}
+
+// commit persists a change to disk, then publishes it and advances the
+// generation. A change that cannot be saved is rolled back, so neither memory
+// nor DNS answers ever hold records the disk lacks. The caller must hold the
+// locks taken by lockWrite.
+func (s *Storage) commit(domains ...string) error {
+	err := s.save(s.stepAside, domains...)
+	if err != nil {
+		s.rollback(domains)
+		s.undo = nil
+		return err
+	}
+	s.undo = nil
+	s.generation++
+	s.publish()
+	return nil
+}
+
+// stepAside waits out the delay before a failed save is retried without
+// blocking readers: the unsaved change is undone, the write lock released for
+// the delay and the change reapplied. writeMu keeps other changes out meanwhile.
+func (s *Storage) stepAside(delay time.Duration) {
+	redo := s.revert()
+	s.mu.Unlock()
+	time.Sleep(delay)
+	s.mu.Lock()
+	for _, entry := range redo {
+		s.assign(entry.domain, entry.name, entry.set)
+	}
+}
+
+// revert undoes the changes since the last commit in memory, keeping them for
+// rollback, and returns the entries that reapply them in order. The caller
+// must hold the write lock.
+func (s *Storage) revert() []undoEntry {
+	redo := make([]undoEntry, len(s.undo))
+	for i := len(s.undo) - 1; i >= 0; i-- {
+		entry := s.undo[i]
+		redo[i] = undoEntry{domain: entry.domain, name: entry.name, set: s.records[entry.domain][entry.name]}
+		s.assign(entry.domain, entry.name, entry.set)
+	}
+	return redo
+}
+
+// rollback undoes the changes since the last commit after they could not be
+// saved. They were never published. The caller must hold the write lock.
+func (s *Storage) rollback(domains []string) {
+	s.revert()
+	logger.Warn("Rolled back a records change that could not be saved")
+
+	// Shards written before the failure already hold the change
+	if s.shardDir != "" {
+		if err := s.write(domains...); err != nil {
+			logger.Error("Failed to restore record shards after a failed save: %v", err)
+			// Shards may still hold the change; parse them all on the next reload
+			s.shards = nil
+		}
+	}
+}
+
+// SaveStatus is the outcome of the most recent records write
+type SaveStatus struct {
+	// LastSuccess is zero until a write succeeds
+	LastSuccess time.Time
+	// Error is the error of the last write, nil when it succeeded
+	Error error
+}
+
+// SaveStatus returns the outcome of the most recent records write
+func (s *Storage) SaveStatus() SaveStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return SaveStatus{LastSuccess: s.lastSave, Error: s.saveErr}
+}
+
+// save persists records to disk, retrying failed writes after a doubling
+// delay, and records the outcome for SaveStatus. wait spends each delay, such
+// as time.Sleep or stepAside.
+func (s *Storage) save(wait func(time.Duration), domains ...string) error {
+	err := s.write(domains...)
+	for attempt := 1; err != nil && attempt <= s.saveRetries; attempt++ {
+		delay := saveRetryDelay << (attempt - 1)
+		logger.Warn("Failed to save records, retrying in %s (%d/%d): %v", delay, attempt, s.saveRetries, err)
+		wait(delay)
+		err = s.write(domains...)
+	}
+	s.saveErr = err
+	if err != nil {
+		saveFailuresCount.Inc()
+		saveFailing.Set(1)
+		return err
+	}
+
+	s.lastSave = time.Now()
+	lastSaveSuccess.Set(float64(s.lastSave.Unix()))
+	saveFailing.Set(0)
+	return nil
+}
+
+// write persists records to disk. In sharded mode only the given domains are
+// written; otherwise the whole records file is rewritten. The checksum of what
+// was written is remembered, so reloading it later is not seen as a change.
+func (s *Storage) write(domains ...string) error {
+	if s.shardDir == "" {
+		data, err := s.writeFile(s.filePath, encodeRecords(s.records))
+		if err != nil {
+			return err
+		}
+		s.loadedSum = sha256.Sum256(data)
+		return nil
+	}
+
+	if s.shards == nil {
+		s.shards = make(map[string]shardState)
+	}
+	defer func() { s.loadedSum = shardsChecksum(s.shards) }()
+
+	for _, domain := range domains {
+		if err := s.saveShard(domain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveShard writes a single domain's shard, removing it when the domain has no records left
+func (s *Storage) saveShard(domain string) error {
+	path, err := s.shardPath(domain)
+	if err != nil {
+		return err
+	}
+
+	if domainRecords, ok := s.records[domain]; ok && len(domainRecords) > 0 {
+		data, err := s.writeFile(path, encodeShard(domainRecords))
+		if err != nil {
+			return err
+		}
+		// A shard that cannot be described is parsed again on the next reload
+		info, err := os.Stat(path)
+		if err != nil {
+			delete(s.shards, path)
+			return nil
+		}
+		s.shards[path] = newShardState(info, data, RecordsFormatVersion)
+		return nil
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove shard %s: %w", path, err)
+	}
+	os.Remove(path + signatureSuffix)
+	delete(s.shards, path)
+
 	return nil
 }
 
-// save writes records to the file with exclusive locking
-func (s *Storage) save() error {
-	// Create temp file for atomic write
-	tempFile := s.filePath + ".tmp"
+// shardPath returns the shard file for a domain, rejecting names that would escape the shard directory
+func (s *Storage) shardPath(domain string) (string, error) {
+	if domain == "" || strings.HasPrefix(domain, ".") || strings.ContainsAny(domain, "/\\") {
+		return "", dns.Errorf(dns.ErrInvalidRecord, "invalid domain for sharded storage: %q", domain)
+	}
+	return filepath.Join(s.shardDir, domain+shardSuffix), nil
+}
+
+// writeTarget returns the file a write to path replaces. The rename that makes
+// writes atomic would turn a symlink into a regular file, so a symlink is
+// resolved to its target when following symlinks and refused otherwise.
+func (s *Storage) writeTarget(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return path, nil
+	}
+	if !s.followSymlinks {
+		return "", fmt.Errorf("%s is a symlink and saving would replace it with a regular file; set NBDNS_FOLLOW_SYMLINK=true to write to its target", path)
+	}
+
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve symlink %s: %w", path, err)
+	}
+	return target, nil
+}
+
+// writeFile atomically writes v as JSON to path with exclusive locking and
+// returns the bytes written. A symlink at path is written through to its
+// target, or refused; the signature is kept next to the file written.
+func (s *Storage) writeFile(path string, v interface{}) ([]byte, error) {
+	target, err := s.writeTarget(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create temp file for atomic write, next to the file it replaces
+	tempFile := target + ".tmp"
 
 	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer os.Remove(tempFile) // Clean up on error
 
 	// Acquire exclusive lock for writing
 	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
 		file.Close()
-		return fmt.Errorf("failed to acquire exclusive lock: %w", err)
+		return nil, fmt.Errorf("failed to acquire exclusive lock: %w", err)
 	}
 
 	// Encode JSON with pretty printing
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(s.records); err != nil {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
 		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
 		file.Close()
-		return fmt.Errorf("failed to encode records: %w", err)
+		return nil, fmt.Errorf("failed to encode records: %w", err)
+	}
+	data = append(data, '\n')
+
+	if _, err := file.Write(data); err != nil {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+		return nil, fmt.Errorf("failed to write records: %w", err)
 	}
 
 	// Release lock and close file
 	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
 	file.Close()
 
+	// Sign before the rename, keeping the old signature, so readers can verify
+	// both the old and the new file until the rename is followed by the final signature
+	if s.privateKey != nil {
+		if err := s.writeSignature(target, data, true); err != nil {
+			return nil, err
+		}
+	}
+
 	// Atomic rename
-	if err := os.Rename(tempFile, s.filePath); err != nil {
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	if err := os.Rename(tempFile, target); err != nil {
+		return nil, fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
-	return nil
+	if s.privateKey != nil {
+		if err := s.writeSignature(target, data, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
 }
 
 // Reload reloads records from disk
 func (s *Storage) Reload() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.lockWrite()
+	defer s.unlockWrite()
 
 	return s.load()
 }
@@ -0,0 +1,470 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// newTestStorage creates a storage backed by a records file in a temporary directory
+func newTestStorage(t *testing.T, opts StorageOptions) (*Storage, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "records.json")
+	s, err := NewStorageWithOptions(path, opts)
+	if err != nil {
+		t.Fatalf("NewStorageWithOptions() failed: %v", err)
+	}
+	return s, path
+}
+
+func TestTrailingDotNames(t *testing.T) {
+	s, _ := newTestStorage(t, StorageOptions{})
+
+	records := []*dns.Record{
+		{Name: "web.", Domain: "example.com.", Type: dns.RecordTypeA, Value: "100.64.0.10"},
+		{Name: "www", Domain: "example.com.", Type: dns.RecordTypeCNAME, Value: "web.example.com."},
+		{Name: "@", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.1"},
+	}
+	for _, record := range records {
+		if err := s.SetRecord(record); err != nil {
+			t.Fatalf("SetRecord(%s) failed: %v", record.FQDN(), err)
+		}
+	}
+
+	// Creating the same record without the trailing dots updates it instead of adding another
+	if err := s.SetRecord(&dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.11"}); err != nil {
+		t.Fatalf("SetRecord() failed: %v", err)
+	}
+	if records := s.ListRecords(); len(records) != 1 || len(records["example.com"]) != 3 {
+		t.Errorf("ListRecords() = %v, want 3 records in example.com", records)
+	}
+
+	gets := []struct {
+		domain, name string
+		recordType   dns.RecordType
+		want         string
+	}{
+		{"example.com", "web", dns.RecordTypeA, "100.64.0.11"},
+		{"example.com.", "web.", dns.RecordTypeA, "100.64.0.11"},
+		{"example.com.", "www", dns.RecordTypeCNAME, "web.example.com"},
+		{"example.com.", "@", dns.RecordTypeA, "100.64.0.1"},
+		{"example.com", "", dns.RecordTypeA, "100.64.0.1"},
+	}
+	for _, tt := range gets {
+		record, err := s.GetRecord(tt.domain, tt.name, tt.recordType)
+		if err != nil {
+			t.Errorf("GetRecord(%q, %q) failed: %v", tt.domain, tt.name, err)
+			continue
+		}
+		if record.Value != tt.want || record.Domain != "example.com" {
+			t.Errorf("GetRecord(%q, %q) = %s in %s, want %s in example.com", tt.domain, tt.name, record.Value, record.Domain, tt.want)
+		}
+	}
+
+	if _, err := s.DeleteRecord("example.com.", "web.", dns.RecordTypeA); err != nil {
+		t.Fatalf("DeleteRecord() with trailing dots failed: %v", err)
+	}
+	if _, err := s.GetRecord("example.com", "web", dns.RecordTypeA); err == nil {
+		t.Error("GetRecord() found a deleted record")
+	}
+	if _, err := s.DeleteRecord("example.com", "www.", dns.RecordTypeCNAME); err != nil {
+		t.Fatalf("DeleteRecord() with a trailing dot on the name failed: %v", err)
+	}
+	if records := s.ListRecordsByDomain("example.com."); len(records) != 1 {
+		t.Errorf("ListRecordsByDomain() = %v, want 1 record left in example.com", records)
+	}
+}
+
+func TestReloadOfOwnWritesKeepsGeneration(t *testing.T) {
+	for _, sharded := range []bool{false, true} {
+		opts := StorageOptions{}
+		if sharded {
+			opts.ShardDir = t.TempDir()
+		}
+		s, path := newTestStorage(t, opts)
+
+		for _, name := range []string{"web", "db"} {
+			if err := s.SetRecord(&dns.Record{Name: name, Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"}); err != nil {
+				t.Fatalf("SetRecord() failed: %v", err)
+			}
+		}
+		if _, err := s.DeleteRecord("example.com", "db", dns.RecordTypeA); err != nil {
+			t.Fatalf("DeleteRecord() failed: %v", err)
+		}
+		generation := s.Generation()
+
+		if err := s.Reload(); err != nil {
+			t.Fatalf("Reload() failed: %v", err)
+		}
+		if got := s.Generation(); got != generation {
+			t.Errorf("sharded=%v: generation after reloading own writes = %d, want %d", sharded, got, generation)
+		}
+
+		// A change made by someone else is picked up
+		data := []byte(`{"example.org": {}}`)
+		if sharded {
+			path, data = filepath.Join(opts.ShardDir, "example.org.json"), []byte(`{}`)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Reload(); err != nil {
+			t.Fatalf("Reload() failed: %v", err)
+		}
+		if got := s.Generation(); got != generation+1 {
+			t.Errorf("sharded=%v: generation after an external change = %d, want %d", sharded, got, generation+1)
+		}
+	}
+}
+
+func TestSetRecordsRejectsNullRecords(t *testing.T) {
+	s, _ := newTestStorage(t, StorageOptions{})
+
+	records := []*dns.Record{
+		{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"},
+		nil,
+	}
+	results, err := s.SetRecords(records, false)
+	if !errors.Is(err, dns.ErrInvalidRecord) {
+		t.Fatalf("SetRecords() error = %v, want ErrInvalidRecord", err)
+	}
+	if len(results) != 2 || results[0].Status != "" || results[1].Status != "invalid" {
+		t.Errorf("results = %+v, want only the null record reported invalid", results)
+	}
+	if len(s.ListRecords()) != 0 {
+		t.Error("SetRecords() stored records from a payload with a null record")
+	}
+}
+
+func TestDiffAndApplyRejectNullRecords(t *testing.T) {
+	s, _ := newTestStorage(t, StorageOptions{})
+	if err := s.SetRecord(&dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"}); err != nil {
+		t.Fatal(err)
+	}
+
+	records := []*dns.Record{
+		{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"},
+		nil,
+	}
+	for name, run := range map[string]func([]*dns.Record) (*Plan, []UpsertResult, error){"Diff": s.Diff, "Apply": s.Apply} {
+		plan, results, err := run(records)
+		if !errors.Is(err, dns.ErrInvalidRecord) {
+			t.Fatalf("%s() error = %v, want ErrInvalidRecord", name, err)
+		}
+		if plan != nil {
+			t.Errorf("%s() returned a plan for a payload with a null record", name)
+		}
+		if len(results) != 2 || results[0].Status != "valid" || results[1].Status != "invalid" {
+			t.Errorf("%s() results = %+v, want only the null record reported invalid", name, results)
+		}
+	}
+	if _, err := s.GetRecord("example.com", "web", dns.RecordTypeA); err != nil {
+		t.Errorf("Apply() with a null record changed the stored records: %v", err)
+	}
+}
+
+func TestLowercaseTypesAreStoredTyped(t *testing.T) {
+	s, path := newTestStorage(t, StorageOptions{})
+
+	for _, record := range []*dns.Record{
+		{Name: "web", Domain: "example.com", Type: "a", Value: "100.64.0.10"},
+		{Name: "www", Domain: "example.com", Type: "cname", Value: "web.example.com"},
+		{Name: "@", Domain: "example.com", Type: "Txt", Value: "hello"},
+	} {
+		if err := s.SetRecord(record); err != nil {
+			t.Fatalf("SetRecord() with type %q failed: %v", record.Type, err)
+		}
+	}
+
+	// The records are found by their uppercase type, also after a reload from disk
+	reloaded, err := NewStorageWithOptions(path, StorageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, storage := range []*Storage{s, reloaded} {
+		for name, recordType := range map[string]dns.RecordType{"web": dns.RecordTypeA, "www": dns.RecordTypeCNAME, "@": dns.RecordTypeTXT} {
+			record, err := storage.GetRecord("example.com", name, recordType)
+			if err != nil {
+				t.Errorf("GetRecord(%s, %s) failed: %v", name, recordType, err)
+				continue
+			}
+			if record.Type != recordType {
+				t.Errorf("record %s stored with type %q, want %q", name, record.Type, recordType)
+			}
+		}
+	}
+
+	// A lowercase type updates the record of the same type instead of adding another
+	if err := s.SetRecord(&dns.Record{Name: "web", Domain: "example.com", Type: "A", Value: "100.64.0.11"}); err != nil {
+		t.Fatal(err)
+	}
+	if set, _ := s.GetRecords("example.com", "web"); len(set) != 1 {
+		t.Errorf("web holds %d records, want 1", len(set))
+	}
+}
+
+func TestMigrateVersion0(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.json")
+	v0 := `{
+  "example.com": {
+    "web": {"name": "web", "domain": "example.com", "type": "A", "value": "100.64.0.10", "ttl": 120},
+    "www": {"name": "www", "domain": "example.com", "type": "CNAME", "value": "web.example.com"}
+  },
+  "internal.net": {
+    "": {"name": "", "domain": "internal.net", "type": "TXT", "value": "hello"}
+  }
+}
+`
+	if err := os.WriteFile(path, []byte(v0), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers serve old files as they are
+	reader, err := NewStorageWithOptions(path, StorageOptions{})
+	if err != nil {
+		t.Fatalf("loading a version 0 file failed: %v", err)
+	}
+	if record, err := reader.GetRecord("example.com", "web", dns.RecordTypeA); err != nil || record.TTL != 120 {
+		t.Errorf("GetRecord() = %v, %v, want the version 0 record", record, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != v0 {
+		t.Error("a storage without Migrate rewrote the records file")
+	}
+
+	s, err := NewStorageWithOptions(path, StorageOptions{Migrate: true})
+	if err != nil {
+		t.Fatalf("migrating a version 0 file failed: %v", err)
+	}
+	want := map[string]string{
+		"example.com/web/A":     "100.64.0.10",
+		"example.com/www/CNAME": "web.example.com",
+		"internal.net//TXT":     "hello",
+	}
+	for domain, names := range s.ListRecords() {
+		for name, set := range names {
+			for _, record := range set {
+				key := domain + "/" + name + "/" + string(record.Type)
+				if want[key] != record.Value {
+					t.Errorf("record %s = %q, want %q", key, record.Value, want[key])
+				}
+				delete(want, key)
+			}
+		}
+	}
+	for key := range want {
+		t.Errorf("record %s is missing after the migration", key)
+	}
+
+	// The file is rewritten in the current format, with a list of records per name
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		Version int                                 `json:"version"`
+		Records map[string]map[string][]*dns.Record `json:"records"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("migrated file does not decode: %v", err)
+	}
+	if file.Version != RecordsFormatVersion {
+		t.Errorf("migrated file version = %d, want %d", file.Version, RecordsFormatVersion)
+	}
+	if web := file.Records["example.com"]["web"]; len(web) != 1 || web[0].Value != "100.64.0.10" {
+		t.Errorf("migrated web records = %v, want one A record", web)
+	}
+
+	reloaded, err := NewStorageWithOptions(path, StorageOptions{Migrate: true})
+	if err != nil {
+		t.Fatalf("loading the migrated file failed: %v", err)
+	}
+	if _, err := reloaded.GetRecord("internal.net", "@", dns.RecordTypeTXT); err != nil {
+		t.Errorf("GetRecord() on the migrated file failed: %v", err)
+	}
+}
+
+func TestCommitFailedSaveIsNotPublished(t *testing.T) {
+	s, path := newTestStorage(t, StorageOptions{SaveRetries: 2})
+
+	// A directory in the way of the temporary file makes every write fail
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.SetRecord(&dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"})
+	}()
+
+	// Readers are neither blocked by the retries nor shown the unsaved record
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	records := s.ListRecords()
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("ListRecords() waited %v for a retrying save", elapsed)
+	}
+	if len(records) != 0 {
+		t.Errorf("ListRecords() = %v while the save is retried, want no records", records)
+	}
+	if _, err := s.GetRecord("example.com", "web", dns.RecordTypeA); err == nil {
+		t.Error("GetRecord() found a record that was not saved")
+	}
+
+	if err := <-done; err == nil {
+		t.Fatal("SetRecord() succeeded on an unwritable records file")
+	}
+	if _, err := s.GetRecord("example.com", "web", dns.RecordTypeA); err == nil {
+		t.Error("GetRecord() found a record that was rolled back")
+	}
+	if status := s.SaveStatus(); status.Error == nil {
+		t.Error("SaveStatus() reports no error after a failed save")
+	}
+}
+
+func TestReloadShardsOnlyParsesChanged(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStorageWithOptions(filepath.Join(dir, "records.json"), StorageOptions{ShardDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []*dns.Record{
+		{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"},
+		{Name: "db", Domain: "internal.net", Type: dns.RecordTypeA, Value: "100.64.0.20"},
+	} {
+		if err := s.SetRecord(record); err != nil {
+			t.Fatalf("SetRecord() failed: %v", err)
+		}
+	}
+
+	// A reader parses both shards on its first load
+	reader, err := NewStorageWithOptions(filepath.Join(dir, "records.json"), StorageOptions{ShardDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	untouched := reader.ListRecordsByDomain("internal.net")
+	generation := reader.Generation()
+
+	// Reloading without changes parses nothing and keeps the generation
+	if err := reader.Reload(); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if reader.Generation() != generation {
+		t.Error("Reload() without changes advanced the generation")
+	}
+
+	// Corrupt the internal.net shard in place, keeping its size and modification
+	// time: a reload that parsed it would fail
+	internalPath := filepath.Join(dir, "internal.net"+shardSuffix)
+	info, err := os.Stat(internalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := make([]byte, info.Size())
+	for i := range corrupt {
+		corrupt[i] = '#'
+	}
+	file, err := os.OpenFile(internalPath, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt(corrupt, 0); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if err := os.Chtimes(internalPath, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Touch the example.com shard through the writer
+	if err := s.SetRecord(&dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.11"}); err != nil {
+		t.Fatalf("SetRecord() failed: %v", err)
+	}
+
+	// The writer knows both shards from its own writes and parses neither
+	writerGeneration := s.Generation()
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() by the writer parsed a shard it wrote: %v", err)
+	}
+	if s.Generation() != writerGeneration {
+		t.Error("Reload() of the writer's own shards advanced the generation")
+	}
+
+	if err := reader.Reload(); err != nil {
+		t.Fatalf("Reload() parsed an unchanged shard: %v", err)
+	}
+	if record, err := reader.GetRecord("example.com", "web", dns.RecordTypeA); err != nil || record.Value != "100.64.0.11" {
+		t.Errorf("GetRecord() = %v, %v, want the changed shard's new value", record, err)
+	}
+	if reader.Generation() == generation {
+		t.Error("Reload() of a changed shard kept the generation")
+	}
+	kept := reader.ListRecordsByDomain("internal.net")
+	if len(kept) != 1 || kept["db"].Get(dns.RecordTypeA).Value != untouched["db"].Get(dns.RecordTypeA).Value {
+		t.Errorf("internal.net records = %v, want the records parsed before", kept)
+	}
+}
+
+func TestSymlinkedRecordsFile(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := StorageOptions{PublicKey: public, PrivateKey: private}
+
+	// The records live in another directory, linked into place
+	dir := t.TempDir()
+	target := filepath.Join(dir, "data", "records.json")
+	if err := os.Mkdir(filepath.Dir(target), 0755); err != nil {
+		t.Fatal(err)
+	}
+	original, err := NewStorageWithOptions(target, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := original.SetRecord(&dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"}); err != nil {
+		t.Fatalf("SetRecord() failed: %v", err)
+	}
+	link := filepath.Join(dir, "records.json")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	// By default the symlink is not replaced
+	refused, err := NewStorageWithOptions(link, keys)
+	if err != nil {
+		t.Fatalf("NewStorageWithOptions() on a symlink failed: %v", err)
+	}
+	if err := refused.SetRecord(&dns.Record{Name: "db", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.20"}); err == nil {
+		t.Error("SetRecord() through a symlink succeeded without FollowSymlinks")
+	}
+
+	keys.FollowSymlinks = true
+	s, err := NewStorageWithOptions(link, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetRecord(&dns.Record{Name: "db", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.20"}); err != nil {
+		t.Fatalf("SetRecord() through a followed symlink failed: %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("records file is no longer a symlink: %v", err)
+	}
+
+	// The signature sits next to the target and verifies reads through the link
+	if _, err := os.Stat(target + signatureSuffix); err != nil {
+		t.Errorf("no signature next to the target: %v", err)
+	}
+	reader, err := NewStorageWithOptions(link, StorageOptions{PublicKey: public})
+	if err != nil {
+		t.Fatalf("NewStorageWithOptions() failed to verify the linked file: %v", err)
+	}
+	if _, err := reader.GetRecord("example.com", "db", dns.RecordTypeA); err != nil {
+		t.Errorf("GetRecord() through the link failed: %v", err)
+	}
+}
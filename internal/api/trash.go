@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"netbird-coredns/internal/logger"
+	"netbird-coredns/pkg/dns"
+)
+
+// DeletedRecord is a soft-deleted record, kept for restoring until the
+// retention window has passed
+type DeletedRecord struct {
+	Record    *dns.Record `json:"record"`
+	DeletedAt time.Time   `json:"deleted_at"`
+}
+
+// trashPath returns the file holding soft-deleted records: next to the records
+// file, or inside the shard directory where it cannot be taken for a shard
+func (s *Storage) trashPath() string {
+	if s.shardDir != "" {
+		return filepath.Join(s.shardDir, ".deleted")
+	}
+	return s.filePath + ".deleted"
+}
+
+// loadTrash reads the soft-deleted records. A missing file means none.
+func (s *Storage) loadTrash() error {
+	data, err := s.readFile(s.trashPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.trash); err != nil {
+		return fmt.Errorf("failed to decode deleted records: %w", err)
+	}
+	return nil
+}
+
+// saveTrash writes the soft-deleted records. The caller must hold the write lock.
+func (s *Storage) saveTrash() error {
+	if len(s.trash) == 0 {
+		if err := os.Remove(s.trashPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove deleted records: %w", err)
+		}
+		return nil
+	}
+	_, err := s.writeFile(s.trashPath(), s.trash)
+	return err
+}
+
+// DeletedRecords returns the soft-deleted records that can still be restored
+func (s *Storage) DeletedRecords() []DeletedRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().Add(-s.softDelete)
+	deleted := []DeletedRecord{}
+	for _, entry := range s.trash {
+		if entry.DeletedAt.After(cutoff) {
+			deleted = append(deleted, entry)
+		}
+	}
+	return deleted
+}
+
+// RestoreRecord puts soft-deleted records of a name back, or only the record of
+// recordType when it is set. A name or type that was recreated since the
+// delete is not overwritten; restoring it fails instead.
+func (s *Storage) RestoreRecord(domain, name string, recordType dns.RecordType) (dns.RecordSet, error) {
+	s.lockWrite()
+	defer s.unlockWrite()
+
+	domain = dns.TrimDot(domain)
+	name = normalizeName(name)
+	if s.softDelete <= 0 {
+		return nil, dns.Errorf(dns.ErrInvalidRecord, "soft deletes are disabled")
+	}
+
+	// The newest delete of each type wins
+	cutoff := time.Now().Add(-s.softDelete)
+	var restored dns.RecordSet
+	var kept []DeletedRecord
+	for i := len(s.trash) - 1; i >= 0; i-- {
+		entry := s.trash[i]
+		record := entry.Record
+		if record.Domain != domain || record.Name != name || (recordType != "" && record.Type != recordType) ||
+			!entry.DeletedAt.After(cutoff) || restored.Get(record.Type) != nil {
+			kept = append(kept, entry)
+			continue
+		}
+		restored = append(restored, record)
+	}
+	if len(restored) == 0 {
+		return nil, notFound(domain, name, recordType)
+	}
+
+	set := s.records[domain][name]
+	for _, record := range restored {
+		if set.Get(record.Type) != nil {
+			return nil, dns.Errorf(dns.ErrInvalidRecord, "a %s record for %s has been created since it was deleted", record.Type, record.FQDN())
+		}
+		if err := set.CheckConflict(record.Type); err != nil {
+			return nil, err
+		}
+		if err := checkAliases(s.records[domain], record); err != nil {
+			return nil, err
+		}
+		set, _ = set.Put(record)
+	}
+	s.setRecords(domain, name, set)
+
+	if err := s.commit(domain); err != nil {
+		return nil, err
+	}
+
+	// kept was collected newest first
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	s.trash = kept
+	if err := s.saveTrash(); err != nil {
+		logger.Warn("Restored %s but failed to update deleted records: %v", restored[0].FQDN(), err)
+	}
+	return restored, nil
+}
+
+// PurgeDeleted drops soft-deleted records older than the retention window and
+// returns how many were dropped
+func (s *Storage) PurgeDeleted() (int, error) {
+	s.lockWrite()
+	defer s.unlockWrite()
+
+	cutoff := time.Now().Add(-s.softDelete)
+	var kept []DeletedRecord
+	for _, entry := range s.trash {
+		if entry.DeletedAt.After(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+	removed := len(s.trash) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	s.trash = kept
+	return removed, s.saveTrash()
+}
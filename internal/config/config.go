@@ -2,15 +2,66 @@ package config
 
 import (
 	"fmt"
-	"os"
+	"net"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// Instance modes
+const (
+	ModeLeader   = "leader"
+	ModeFollower = "follower"
+)
+
+// Child process output modes
+const (
+	// ChildOutputLog routes child output through the service logger with a [netbird]/[coredns] prefix
+	ChildOutputLog = "log"
+	// ChildOutputRaw copies child output to stdout/stderr unchanged
+	ChildOutputRaw = "raw"
+)
+
+// DefaultUpstreamTimeout is how long DNS queries sent by the service wait for
+// an answer unless NBDNS_UPSTREAM_TIMEOUT is set
+const DefaultUpstreamTimeout = 2 * time.Second
+
+// Responses to in-zone queries while NetBird is disconnected
+const (
+	// DisconnectedServe keeps answering from the stored records
+	DisconnectedServe = "serve"
+	// DisconnectedServfail answers with SERVFAIL so clients fail over to another server
+	DisconnectedServfail = "servfail"
+)
+
+// Resolution orders for A queries at a name holding both a CNAME and an A
+// record. The API never stores both, but a records file edited by hand can.
+const (
+	// AQueryCNAMEFirst answers with the CNAME and follows it
+	AQueryCNAMEFirst = "cname-first"
+	// AQueryAFirst answers with the name's own A record
+	AQueryAFirst = "a-first"
 )
 
+// Apex record modes for records with an empty or "@" name
+const (
+	// ApexRecordsAllow stores such records and serves them at the zone apex
+	ApexRecordsAllow = "allow"
+	// ApexRecordsReject refuses such records with a validation error
+	ApexRecordsReject = "reject"
+)
+
+// MaxDNSSockets is the most servers NBDNS_DNS_REUSEPORT can start on the DNS port
+const MaxDNSSockets = 1024
+
 // Config holds all configuration for the netbird-coredns service
 type Config struct {
 	// General configuration
 	LogLevel string
+	Mode     string
 
 	// NetBird configuration (for peer registration)
 	SetupKey      string
@@ -20,39 +71,177 @@ type Config struct {
 
 	// DNS configuration
 	Domains     []string
+	AutoDomains bool
 	ForwardTo   string
 	RecordsFile string
+	RecordsDir  string
+	SeedFile    string
 	DNSPort     int
+	ApexRecords string
+	// DefaultDomain fills in the domain of records created without one
+	DefaultDomain string
+	// NetBirdInterface, when set, restricts DNS and the API to the address of
+	// this interface once NetBird is connected, and to loopback
+	NetBirdInterface string
+	// BindAddresses are the addresses DNS listens on, all of them when empty.
+	// They are filled in from NetBirdInterface after connecting.
+	BindAddresses []string
+	// DisconnectedResponse is how in-zone queries are answered while NetBird is
+	// disconnected: DisconnectedServe or DisconnectedServfail
+	DisconnectedResponse string
+	// AQueryOrder is AQueryCNAMEFirst or AQueryAFirst
+	AQueryOrder string
+	// DedupValues drops repeated values of multi-value records; when false
+	// such records are rejected instead
+	DedupValues bool
+	// SaveRetries is how often a failed records write is retried before the
+	// change is rolled back
+	SaveRetries int
+	// FollowSymlink writes a symlinked records file through to its target
+	// instead of refusing to replace the symlink
+	FollowSymlink bool
+	// Longest record name and value accepted by the API, at most the protocol maxima
+	MaxNameLength  int
+	MaxValueLength int
+
+	// UpstreamTimeout bounds every DNS query the service sends itself
+	UpstreamTimeout time.Duration
+
+	// In-zone queries without a custom record are passed on to the next plugin
+	// when Fallthrough is set, limited to FallthroughZones when non-empty
+	Fallthrough      bool
+	FallthroughZones []string
+
+	// Records snapshots (BackupKeep 0 keeps every snapshot, BackupInterval 0
+	// disables periodic snapshots)
+	BackupDir      string
+	BackupKeep     int
+	BackupInterval time.Duration
+
+	// Records file signing (base64-encoded ed25519 keys)
+	RecordsPublicKey  string
+	RecordsPrivateKey string
 
 	// API configuration
-	APIPort int
+	APIPort          int
+	APIMaxConcurrent int
+	APIH2C           bool
+	APIWaitForDNS    bool
+	APIAccessLog     bool
+	// TrustedProxies are the peers whose X-Forwarded-For header is believed
+	TrustedProxies []*net.IPNet
+	// CORSOrigins are the browser origins allowed to call the API, "*" for any
+	CORSOrigins []string
+	// APIDrainTimeout is how long in-flight API requests may run on shutdown
+	APIDrainTimeout time.Duration
+	// SoftDelete keeps deleted records restorable for SoftDeleteRetention
+	SoftDelete          bool
+	SoftDeleteRetention time.Duration
+
+	// Health check endpoint (an empty HealthBody keeps the JSON response)
+	HealthPath   string
+	HealthBody   string
+	HealthStatus int
+
+	// Metrics configuration (0 disables the CoreDNS prometheus endpoint)
+	MetricsPort int
 
 	// Refresh settings
 	RefreshInterval int
+
+	// Process settings
+	CoreDNSStartRetries int
+	CoreDNSStartDelay   int
+	ChildOutput         string
+
+	// Corefile hot-reload (interval in seconds)
+	CoreDNSReload         bool
+	CoreDNSReloadInterval int
+
+	// CoreDNS errors plugin: repeated errors matching CoreDNSErrorsPattern are
+	// consolidated over CoreDNSErrorsConsolidate when it is non-zero
+	CoreDNSErrorsConsolidate time.Duration
+	CoreDNSErrorsPattern     string
+	CoreDNSErrorsStacktrace  bool
+
+	// DNSReusePort starts DNSSockets servers sharing the DNS port via
+	// SO_REUSEPORT (CoreDNS multisocket plugin); 0 sockets means GOMAXPROCS
+	DNSReusePort bool
+	DNSSockets   int
+
+	// Extra copy of the generated Corefile for troubleshooting, empty to skip
+	CorefileDumpPath string
 }
 
 // LoadFromEnv loads configuration from environment variables
 func LoadFromEnv() (*Config, error) {
 	config := &Config{}
 
-	// Required: Domains
-	domainsStr := os.Getenv("NBDNS_DOMAINS")
-	if domainsStr == "" {
+	// Secrets may be supplied as NBDNS_*_FILE; fail early if one cannot be read
+	if err := checkEnvFiles(); err != nil {
+		return nil, err
+	}
+
+	// Optional: Discover the NetBird account domain at startup
+	autoDomains, err := getEnvBool("NBDNS_AUTO_DOMAINS")
+	if err != nil {
+		return nil, err
+	}
+	config.AutoDomains = autoDomains
+
+	// Required: Domains (unless they are discovered from NetBird)
+	domainsStr := getEnv("NBDNS_DOMAINS")
+	if domainsStr == "" && !config.AutoDomains {
 		return nil, fmt.Errorf("NBDNS_DOMAINS is required")
 	}
 	config.Domains = parseDomains(domainsStr)
-	if len(config.Domains) == 0 {
+	if len(config.Domains) == 0 && !config.AutoDomains {
 		return nil, fmt.Errorf("NBDNS_DOMAINS must contain at least one valid domain")
 	}
 
+	// Optional: Domain of records created without one (defaults to the only configured domain)
+	if defaultDomain := getEnv("NBDNS_DEFAULT_DOMAIN"); defaultDomain != "" {
+		defaultDomain = dns.TrimDot(strings.ToLower(defaultDomain))
+		if !dns.IsValidDomain(defaultDomain) {
+			return nil, fmt.Errorf("invalid NBDNS_DEFAULT_DOMAIN value: %s", defaultDomain)
+		}
+		config.DefaultDomain = defaultDomain
+	}
+
+	// Optional: Listen only on the NetBird interface (and loopback)
+	config.NetBirdInterface = strings.TrimSpace(getEnv("NBDNS_NETBIRD_INTERFACE"))
+
 	// Optional: Forward server
-	config.ForwardTo = os.Getenv("NBDNS_FORWARD_TO")
+	config.ForwardTo = getEnv("NBDNS_FORWARD_TO")
 	if config.ForwardTo == "" {
 		config.ForwardTo = "8.8.8.8"
 	}
 
+	// Optional: How long DNS queries sent by the service wait for an answer
+	config.UpstreamTimeout = DefaultUpstreamTimeout
+	if timeoutStr := getEnv("NBDNS_UPSTREAM_TIMEOUT"); timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil || timeout <= 0 || timeout > time.Minute {
+			return nil, fmt.Errorf("invalid NBDNS_UPSTREAM_TIMEOUT value: %s. Must be a positive duration of at most 1m, such as 2s", timeoutStr)
+		}
+		config.UpstreamTimeout = timeout
+	}
+
+	// Optional: Fall through to forwarding for in-zone misses ("true", "false" or a list of zones)
+	config.Fallthrough = true
+	if fallthroughStr := getEnv("NBDNS_FALLTHROUGH"); fallthroughStr != "" {
+		if b, err := strconv.ParseBool(fallthroughStr); err == nil {
+			config.Fallthrough = b
+		} else {
+			config.FallthroughZones = parseDomains(fallthroughStr)
+			if len(config.FallthroughZones) == 0 {
+				return nil, fmt.Errorf("invalid NBDNS_FALLTHROUGH value: %s", fallthroughStr)
+			}
+		}
+	}
+
 	// Optional: DNS port
-	dnsPortStr := os.Getenv("NBDNS_DNS_PORT")
+	dnsPortStr := getEnv("NBDNS_DNS_PORT")
 	if dnsPortStr != "" {
 		port, err := strconv.Atoi(dnsPortStr)
 		if err != nil || port <= 0 || port > 65535 {
@@ -64,7 +253,7 @@ func LoadFromEnv() (*Config, error) {
 	}
 
 	// Optional: API port
-	apiPortStr := os.Getenv("NBDNS_API_PORT")
+	apiPortStr := getEnv("NBDNS_API_PORT")
 	if apiPortStr != "" {
 		port, err := strconv.Atoi(apiPortStr)
 		if err != nil || port <= 0 || port > 65535 {
@@ -75,8 +264,109 @@ func LoadFromEnv() (*Config, error) {
 		config.APIPort = 8080
 	}
 
+	// Optional: Maximum concurrent API mutations (0 means unlimited)
+	maxConcurrentStr := getEnv("NBDNS_API_MAX_CONCURRENT")
+	if maxConcurrentStr != "" {
+		maxConcurrent, err := strconv.Atoi(maxConcurrentStr)
+		if err != nil || maxConcurrent < 0 {
+			return nil, fmt.Errorf("invalid NBDNS_API_MAX_CONCURRENT value: %s", maxConcurrentStr)
+		}
+		config.APIMaxConcurrent = maxConcurrent
+	}
+
+	// Optional: How long in-flight API requests may finish on shutdown
+	config.APIDrainTimeout = 5 * time.Second
+	if drainTimeoutStr := getEnv("NBDNS_API_DRAIN_TIMEOUT"); drainTimeoutStr != "" {
+		drainTimeout, err := time.ParseDuration(drainTimeoutStr)
+		if err != nil || drainTimeout < 0 {
+			return nil, fmt.Errorf("invalid NBDNS_API_DRAIN_TIMEOUT value: %s. Must be a duration such as 10s, or 0 to close connections at once", drainTimeoutStr)
+		}
+		config.APIDrainTimeout = drainTimeout
+	}
+
+	// Optional: Keep deleted records restorable for a retention window
+	softDelete, err := getEnvBool("NBDNS_SOFT_DELETE")
+	if err != nil {
+		return nil, err
+	}
+	config.SoftDelete = softDelete
+
+	config.SoftDeleteRetention = 24 * time.Hour
+	if retentionStr := getEnv("NBDNS_SOFT_DELETE_RETENTION"); retentionStr != "" {
+		retention, err := time.ParseDuration(retentionStr)
+		if err != nil || retention <= 0 {
+			return nil, fmt.Errorf("invalid NBDNS_SOFT_DELETE_RETENTION value: %s. Must be a positive duration such as 72h", retentionStr)
+		}
+		config.SoftDeleteRetention = retention
+	}
+
+	// Optional: Serve the API over cleartext HTTP/2 in addition to HTTP/1.1
+	apiH2C, err := getEnvBool("NBDNS_API_H2C")
+	if err != nil {
+		return nil, err
+	}
+	config.APIH2C = apiH2C
+
+	// Optional: Reject API mutations until CoreDNS has started
+	apiWaitForDNS, err := getEnvBool("NBDNS_API_WAIT_FOR_DNS")
+	if err != nil {
+		return nil, err
+	}
+	config.APIWaitForDNS = apiWaitForDNS
+
+	// Optional: JSON access log for the API
+	apiAccessLog, err := getEnvBool("NBDNS_API_ACCESS_LOG")
+	if err != nil {
+		return nil, err
+	}
+	config.APIAccessLog = apiAccessLog
+
+	// Optional: Reverse proxies trusted to report the client address
+	if proxiesStr := getEnv("NBDNS_TRUSTED_PROXIES"); proxiesStr != "" {
+		proxies, err := parseCIDRs(proxiesStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NBDNS_TRUSTED_PROXIES value: %w", err)
+		}
+		config.TrustedProxies = proxies
+	}
+
+	// Optional: Browser origins allowed to call the API
+	if originsStr := getEnv("NBDNS_CORS_ORIGINS"); originsStr != "" {
+		config.CORSOrigins = parseList(originsStr)
+	}
+
+	// Optional: Health check path, body and status code
+	config.HealthPath = getEnv("NBDNS_HEALTH_PATH")
+	if config.HealthPath == "" {
+		config.HealthPath = "/health"
+	}
+	if !strings.HasPrefix(config.HealthPath, "/") {
+		return nil, fmt.Errorf("invalid NBDNS_HEALTH_PATH value: %s. Must start with /", config.HealthPath)
+	}
+	config.HealthBody = getEnv("NBDNS_HEALTH_BODY")
+	healthStatusStr := getEnv("NBDNS_HEALTH_STATUS")
+	if healthStatusStr != "" {
+		status, err := strconv.Atoi(healthStatusStr)
+		if err != nil || status < 200 || status > 299 {
+			return nil, fmt.Errorf("invalid NBDNS_HEALTH_STATUS value: %s", healthStatusStr)
+		}
+		config.HealthStatus = status
+	} else {
+		config.HealthStatus = 200
+	}
+
+	// Optional: Metrics port
+	metricsPortStr := getEnv("NBDNS_METRICS_PORT")
+	if metricsPortStr != "" {
+		port, err := strconv.Atoi(metricsPortStr)
+		if err != nil || port < 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid NBDNS_METRICS_PORT value: %s", metricsPortStr)
+		}
+		config.MetricsPort = port
+	}
+
 	// Optional: Refresh interval
-	intervalStr := os.Getenv("NBDNS_REFRESH_INTERVAL")
+	intervalStr := getEnv("NBDNS_REFRESH_INTERVAL")
 	if intervalStr != "" {
 		interval, err := strconv.Atoi(intervalStr)
 		if err != nil || interval <= 0 {
@@ -87,14 +377,220 @@ func LoadFromEnv() (*Config, error) {
 		config.RefreshInterval = 15
 	}
 
+	// Optional: CoreDNS start retries
+	retriesStr := getEnv("NBDNS_COREDNS_START_RETRIES")
+	if retriesStr != "" {
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid NBDNS_COREDNS_START_RETRIES value: %s", retriesStr)
+		}
+		config.CoreDNSStartRetries = retries
+	} else {
+		config.CoreDNSStartRetries = 3
+	}
+
+	// Optional: Maximum seconds to wait for the DNS label to resolve before starting CoreDNS
+	startDelayStr := getEnv("NBDNS_COREDNS_START_DELAY")
+	if startDelayStr != "" {
+		delay, err := strconv.Atoi(startDelayStr)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("invalid NBDNS_COREDNS_START_DELAY value: %s", startDelayStr)
+		}
+		config.CoreDNSStartDelay = delay
+	}
+
+	// Optional: Hot-reload the Corefile via the CoreDNS reload plugin
+	coreDNSReload, err := getEnvBool("NBDNS_COREDNS_RELOAD")
+	if err != nil {
+		return nil, err
+	}
+	config.CoreDNSReload = coreDNSReload
+	reloadIntervalStr := getEnv("NBDNS_COREDNS_RELOAD_INTERVAL")
+	if reloadIntervalStr != "" {
+		interval, err := strconv.Atoi(reloadIntervalStr)
+		if err != nil || interval < 2 {
+			return nil, fmt.Errorf("invalid NBDNS_COREDNS_RELOAD_INTERVAL value: %s. Must be at least 2 seconds", reloadIntervalStr)
+		}
+		config.CoreDNSReloadInterval = interval
+	} else {
+		config.CoreDNSReloadInterval = 30
+	}
+
+	// Optional: Consolidate repeated errors in the CoreDNS errors plugin ("DURATION [REGEXP]")
+	if consolidateStr := getEnv("NBDNS_COREDNS_ERRORS_CONSOLIDATE"); consolidateStr != "" {
+		durationStr, pattern, _ := strings.Cut(strings.TrimSpace(consolidateStr), " ")
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid NBDNS_COREDNS_ERRORS_CONSOLIDATE value: %s. Must be a duration, optionally followed by a regular expression", consolidateStr)
+		}
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			pattern = ".*"
+		}
+		if _, err := regexp.Compile(pattern); err != nil || strings.Contains(pattern, `"`) {
+			return nil, fmt.Errorf("invalid NBDNS_COREDNS_ERRORS_CONSOLIDATE value: %s. The regular expression must compile and cannot contain double quotes", consolidateStr)
+		}
+		config.CoreDNSErrorsConsolidate = duration
+		config.CoreDNSErrorsPattern = pattern
+	}
+	errorsStacktrace, err := getEnvBool("NBDNS_COREDNS_ERRORS_STACKTRACE")
+	if err != nil {
+		return nil, err
+	}
+	config.CoreDNSErrorsStacktrace = errorsStacktrace
+
+	// Optional: Share the DNS port between several CoreDNS servers ("true" or a socket count)
+	if reusePortStr := getEnv("NBDNS_DNS_REUSEPORT"); reusePortStr != "" {
+		if sockets, err := strconv.Atoi(reusePortStr); err == nil {
+			if sockets < 0 || sockets > MaxDNSSockets {
+				return nil, fmt.Errorf("invalid NBDNS_DNS_REUSEPORT value: %s. Must be true, false or a socket count between 0 and %d", reusePortStr, MaxDNSSockets)
+			}
+			config.DNSReusePort = sockets > 0
+			config.DNSSockets = sockets
+		} else if reusePort, err := strconv.ParseBool(reusePortStr); err == nil {
+			config.DNSReusePort = reusePort
+		} else {
+			return nil, fmt.Errorf("invalid NBDNS_DNS_REUSEPORT value: %s. Must be true, false or a socket count between 0 and %d", reusePortStr, MaxDNSSockets)
+		}
+	}
+
+	// Optional: Where to write a copy of the generated Corefile
+	config.CorefileDumpPath = getEnv("NBDNS_COREFILE_DUMP_PATH")
+
+	// Optional: How NetBird and CoreDNS output is logged
+	childOutput := strings.ToLower(getEnv("NBDNS_CHILD_OUTPUT"))
+	switch childOutput {
+	case "":
+		config.ChildOutput = ChildOutputLog
+	case ChildOutputLog, ChildOutputRaw:
+		config.ChildOutput = childOutput
+	default:
+		return nil, fmt.Errorf("invalid NBDNS_CHILD_OUTPUT value: %s. Must be one of: log, raw", childOutput)
+	}
+
 	// Optional: Records file
-	config.RecordsFile = os.Getenv("NBDNS_RECORDS_FILE")
+	config.RecordsFile = getEnv("NBDNS_RECORDS_FILE")
 	if config.RecordsFile == "" {
 		config.RecordsFile = "/etc/nb-dns/records/records.json"
 	}
 
+	// Optional: Records directory (enables one shard file per domain)
+	config.RecordsDir = getEnv("NBDNS_RECORDS_DIR")
+
+	// Optional: Read-only records served on top of the stored ones
+	config.SeedFile = getEnv("NBDNS_SEED_RECORDS_FILE")
+
+	// Optional: Whether records may use an empty or "@" name for the zone apex
+	apexRecords := strings.ToLower(getEnv("NBDNS_APEX_RECORDS"))
+	switch apexRecords {
+	case "":
+		config.ApexRecords = ApexRecordsAllow
+	case ApexRecordsAllow, ApexRecordsReject:
+		config.ApexRecords = apexRecords
+	default:
+		return nil, fmt.Errorf("invalid NBDNS_APEX_RECORDS value: %s. Must be one of: allow, reject", apexRecords)
+	}
+
+	// Optional: How in-zone queries are answered while NetBird is disconnected
+	disconnectedResponse := strings.ToLower(getEnv("NBDNS_DISCONNECTED_RESPONSE"))
+	switch disconnectedResponse {
+	case "":
+		config.DisconnectedResponse = DisconnectedServe
+	case DisconnectedServe, DisconnectedServfail:
+		config.DisconnectedResponse = disconnectedResponse
+	default:
+		return nil, fmt.Errorf("invalid NBDNS_DISCONNECTED_RESPONSE value: %s. Must be one of: serve, servfail", disconnectedResponse)
+	}
+
+	// Optional: Whether A queries check a name's CNAME or its A record first
+	aQueryOrder := strings.ToLower(getEnv("NBDNS_A_QUERY_ORDER"))
+	switch aQueryOrder {
+	case "":
+		config.AQueryOrder = AQueryCNAMEFirst
+	case AQueryCNAMEFirst, AQueryAFirst:
+		config.AQueryOrder = aQueryOrder
+	default:
+		return nil, fmt.Errorf("invalid NBDNS_A_QUERY_ORDER value: %s. Must be one of: cname-first, a-first", aQueryOrder)
+	}
+
+	// Optional: Whether repeated values of multi-value records are dropped or rejected
+	config.DedupValues = true
+	if dedupStr := getEnv("NBDNS_DEDUP_VALUES"); dedupStr != "" {
+		dedup, err := strconv.ParseBool(dedupStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NBDNS_DEDUP_VALUES value: %s", dedupStr)
+		}
+		config.DedupValues = dedup
+	}
+
+	// Optional: Retries of failed records writes
+	config.SaveRetries = 2
+	if retriesStr := getEnv("NBDNS_SAVE_RETRIES"); retriesStr != "" {
+		retries, err := strconv.Atoi(retriesStr)
+		if err != nil || retries < 0 || retries > 10 {
+			return nil, fmt.Errorf("invalid NBDNS_SAVE_RETRIES value: %s. Must be between 0 and 10", retriesStr)
+		}
+		config.SaveRetries = retries
+	}
+
+	// Optional: Write through a symlinked records file to its target
+	followSymlink, err := getEnvBool("NBDNS_FOLLOW_SYMLINK")
+	if err != nil {
+		return nil, err
+	}
+	config.FollowSymlink = followSymlink
+
+	// Optional: Record name and value length limits
+	config.MaxNameLength = dns.MaxNameLength
+	if maxNameStr := getEnv("NBDNS_MAX_NAME_LEN"); maxNameStr != "" {
+		maxName, err := strconv.Atoi(maxNameStr)
+		if err != nil || maxName < 1 || maxName > dns.MaxNameLength {
+			return nil, fmt.Errorf("invalid NBDNS_MAX_NAME_LEN value: %s. Must be between 1 and %d", maxNameStr, dns.MaxNameLength)
+		}
+		config.MaxNameLength = maxName
+	}
+	config.MaxValueLength = dns.MaxTXTLength
+	if maxValueStr := getEnv("NBDNS_MAX_VALUE_LEN"); maxValueStr != "" {
+		maxValue, err := strconv.Atoi(maxValueStr)
+		if err != nil || maxValue < 1 || maxValue > dns.MaxTXTLength {
+			return nil, fmt.Errorf("invalid NBDNS_MAX_VALUE_LEN value: %s. Must be between 1 and %d", maxValueStr, dns.MaxTXTLength)
+		}
+		config.MaxValueLength = maxValue
+	}
+
+	// Optional: Records snapshot directory and retention
+	config.BackupDir = getEnv("NBDNS_BACKUP_DIR")
+	backupKeepVar := "NBDNS_BACKUP_KEEP"
+	backupKeepStr := getEnv(backupKeepVar)
+	if backupKeepStr == "" {
+		backupKeepVar = "NBDNS_BACKUP_RETAIN"
+		backupKeepStr = getEnv(backupKeepVar)
+	}
+	if backupKeepStr != "" {
+		keep, err := strconv.Atoi(backupKeepStr)
+		if err != nil || keep < 0 {
+			return nil, fmt.Errorf("invalid %s value: %s", backupKeepVar, backupKeepStr)
+		}
+		config.BackupKeep = keep
+	}
+	backupIntervalStr := getEnv("NBDNS_BACKUP_INTERVAL")
+	if backupIntervalStr != "" {
+		interval, err := time.ParseDuration(backupIntervalStr)
+		if err != nil || interval < 0 || (interval > 0 && interval < time.Minute) {
+			return nil, fmt.Errorf("invalid NBDNS_BACKUP_INTERVAL value: %s. Must be a duration of at least 1m, or 0 to disable", backupIntervalStr)
+		}
+		if interval > 0 && config.BackupDir == "" {
+			return nil, fmt.Errorf("NBDNS_BACKUP_INTERVAL requires NBDNS_BACKUP_DIR to be set")
+		}
+		config.BackupInterval = interval
+	}
+
+	// Optional: Records file signing keys
+	config.RecordsPublicKey = getEnv("NBDNS_RECORDS_PUBKEY")
+	config.RecordsPrivateKey = getEnv("NBDNS_RECORDS_PRIVKEY")
+
 	// Optional: Log level
-	logLevel := strings.ToLower(os.Getenv("NBDNS_LOG_LEVEL"))
+	logLevel := strings.ToLower(getEnv("NBDNS_LOG_LEVEL"))
 	validLogLevels := map[string]bool{
 		"debug": true,
 		"info":  true,
@@ -109,28 +605,43 @@ func LoadFromEnv() (*Config, error) {
 		return nil, fmt.Errorf("invalid NBDNS_LOG_LEVEL value: %s. Must be one of: debug, info, warn, error", logLevel)
 	}
 
-	// Required: NetBird Setup Key (for peer registration)
-	config.SetupKey = os.Getenv("NBDNS_SETUP_KEY")
-	if config.SetupKey == "" {
-		return nil, fmt.Errorf("NBDNS_SETUP_KEY is required")
+	// Optional: Instance mode
+	mode := strings.ToLower(getEnv("NBDNS_MODE"))
+	switch mode {
+	case "":
+		config.Mode = ModeLeader
+	case ModeLeader, ModeFollower:
+		config.Mode = mode
+	default:
+		return nil, fmt.Errorf("invalid NBDNS_MODE value: %s. Must be one of: leader, follower", mode)
 	}
 
+	// Optional: NetBird Setup Key, only needed until the peer has joined once
+	// and NetBird's login is persisted
+	config.SetupKey = getEnv("NBDNS_SETUP_KEY")
+
 	// Optional: NetBird Management URL (defaults to official service if not set)
-	config.ManagementURL = os.Getenv("NBDNS_MANAGEMENT_URL")
+	config.ManagementURL = getEnv("NBDNS_MANAGEMENT_URL")
 	if config.ManagementURL == "" {
 		config.ManagementURL = "https://api.netbird.io"
 	}
 
 	// Optional: Hostname (defaults to nb-dns)
-	config.Hostname = os.Getenv("NBDNS_HOSTNAME")
+	config.Hostname = getEnv("NBDNS_HOSTNAME")
 	if config.Hostname == "" {
 		config.Hostname = "nb-dns"
 	}
 
 	// Optional: DNS labels (defaults to nb-dns)
-	dnsLabelsStr := os.Getenv("NBDNS_DNS_LABELS")
+	dnsLabelsStr := getEnv("NBDNS_DNS_LABELS")
 	if dnsLabelsStr != "" {
-		config.DNSLabels = parseList(dnsLabelsStr)
+		for _, label := range parseList(dnsLabelsStr) {
+			label = strings.ToLower(label)
+			if !isValidDNSLabel(label) {
+				return nil, fmt.Errorf("invalid NBDNS_DNS_LABELS value: %q is not a valid DNS label", label)
+			}
+			config.DNSLabels = append(config.DNSLabels, label)
+		}
 	} else {
 		config.DNSLabels = []string{"nb-dns"}
 	}
@@ -144,7 +655,7 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("setup key is required")
 	}
 
-	if len(c.Domains) == 0 {
+	if len(c.Domains) == 0 && !c.AutoDomains {
 		return fmt.Errorf("at least one domain is required")
 	}
 
@@ -160,9 +671,53 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("DNS port must be between 1 and 65535")
 	}
 
+	if c.APIMaxConcurrent < 0 {
+		return fmt.Errorf("API max concurrent requests cannot be negative")
+	}
+
+	if c.MetricsPort < 0 || c.MetricsPort > 65535 {
+		return fmt.Errorf("metrics port must be between 0 and 65535")
+	}
+
+	if c.RecordsPrivateKey != "" && c.RecordsPublicKey == "" {
+		return fmt.Errorf("records public key is required when a private key is set")
+	}
+
+	if c.CoreDNSStartRetries < 0 {
+		return fmt.Errorf("CoreDNS start retries cannot be negative")
+	}
+
 	return nil
 }
 
+// IsFollower reports whether this instance only serves records written by another instance
+func (c *Config) IsFollower() bool {
+	return c.Mode == ModeFollower
+}
+
+// RecordDomain returns the domain given to records created without one:
+// NBDNS_DEFAULT_DOMAIN, or the configured domain when there is only one
+func (c *Config) RecordDomain() (string, bool) {
+	if c.DefaultDomain != "" {
+		return c.DefaultDomain, true
+	}
+	if len(c.Domains) == 1 {
+		return c.Domains[0], true
+	}
+	return "", false
+}
+
+// AddDomain appends a domain to the list if it is not already present
+func (c *Config) AddDomain(domain string) bool {
+	for _, existing := range c.Domains {
+		if strings.EqualFold(existing, domain) {
+			return false
+		}
+	}
+	c.Domains = append(c.Domains, domain)
+	return true
+}
+
 // GetPrimaryDomain returns the first domain in the list
 func (c *Config) GetPrimaryDomain() string {
 	if len(c.Domains) > 0 {
@@ -176,6 +731,33 @@ func parseDomains(domainsStr string) []string {
 	return parseList(domainsStr)
 }
 
+// isValidDNSLabel checks a NetBird DNS label, which may span several dot-separated DNS labels
+func isValidDNSLabel(label string) bool {
+	if len(label) > 253 {
+		return false
+	}
+	for _, part := range strings.Split(label, ".") {
+		if !dns.IsValidLabel(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// getEnvBool parses an optional boolean environment variable, defaulting to false
+func getEnvBool(key string) (bool, error) {
+	value := getEnv(key)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value: %s", key, value)
+	}
+	return b, nil
+}
+
 // parseList parses a comma-separated list of strings
 func parseList(listStr string) []string {
 	parts := strings.Split(listStr, ",")
@@ -190,3 +772,29 @@ func parseList(listStr string) []string {
 
 	return result
 }
+
+// parseCIDRs parses a comma-separated list of CIDRs. A plain address stands for
+// itself alone.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if ip := net.ParseIP(part); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a CIDR or IP address", part)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
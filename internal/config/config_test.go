@@ -0,0 +1,201 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUpstreamTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: DefaultUpstreamTimeout},
+		{value: "500ms", want: 500 * time.Millisecond},
+		{value: "1m", want: time.Minute},
+		{value: "0", wantErr: true},
+		{value: "-1s", wantErr: true},
+		{value: "2m", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("NBDNS_DOMAINS", "example.com")
+			t.Setenv("NBDNS_SETUP_KEY", "test-key")
+			t.Setenv("NBDNS_UPSTREAM_TIMEOUT", tt.value)
+
+			cfg, err := LoadFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadFromEnv() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFromEnv() failed: %v", err)
+			}
+			if cfg.UpstreamTimeout != tt.want {
+				t.Errorf("UpstreamTimeout = %v, want %v", cfg.UpstreamTimeout, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoreDNSReload(t *testing.T) {
+	tests := []struct {
+		reload, interval string
+		wantReload       bool
+		wantInterval     int
+		wantErr          bool
+	}{
+		{reload: "", interval: "", wantInterval: 30},
+		{reload: "true", interval: "", wantReload: true, wantInterval: 30},
+		{reload: "true", interval: "2", wantReload: true, wantInterval: 2},
+		{reload: "true", interval: "1", wantErr: true},
+		{reload: "true", interval: "30s", wantErr: true},
+		{reload: "maybe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.reload+"/"+tt.interval, func(t *testing.T) {
+			t.Setenv("NBDNS_DOMAINS", "example.com")
+			t.Setenv("NBDNS_SETUP_KEY", "test-key")
+			t.Setenv("NBDNS_COREDNS_RELOAD", tt.reload)
+			t.Setenv("NBDNS_COREDNS_RELOAD_INTERVAL", tt.interval)
+
+			cfg, err := LoadFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadFromEnv() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFromEnv() failed: %v", err)
+			}
+			if cfg.CoreDNSReload != tt.wantReload || cfg.CoreDNSReloadInterval != tt.wantInterval {
+				t.Errorf("reload = %v every %ds, want %v every %ds", cfg.CoreDNSReload, cfg.CoreDNSReloadInterval, tt.wantReload, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestCoreDNSErrorsConsolidate(t *testing.T) {
+	tests := []struct {
+		value       string
+		want        time.Duration
+		wantPattern string
+		wantErr     bool
+	}{
+		{value: "", want: 0},
+		{value: "1m", want: time.Minute, wantPattern: ".*"},
+		{value: "5m .* i/o timeout", want: 5 * time.Minute, wantPattern: ".* i/o timeout"},
+		{value: "0s", wantErr: true},
+		{value: "soon", wantErr: true},
+		{value: "1m [unclosed", wantErr: true},
+		{value: `1m "quoted"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("NBDNS_DOMAINS", "example.com")
+			t.Setenv("NBDNS_SETUP_KEY", "test-key")
+			t.Setenv("NBDNS_COREDNS_ERRORS_CONSOLIDATE", tt.value)
+
+			cfg, err := LoadFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadFromEnv() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFromEnv() failed: %v", err)
+			}
+			if cfg.CoreDNSErrorsConsolidate != tt.want || cfg.CoreDNSErrorsPattern != tt.wantPattern {
+				t.Errorf("consolidate = %v %q, want %v %q", cfg.CoreDNSErrorsConsolidate, cfg.CoreDNSErrorsPattern, tt.want, tt.wantPattern)
+			}
+		})
+	}
+}
+
+func TestSeedRecordsFileIsNotASecret(t *testing.T) {
+	t.Setenv("NBDNS_DOMAINS", "example.com")
+	t.Setenv("NBDNS_SETUP_KEY", "test-key")
+	// A path that does not exist yet must not be read as the value of NBDNS_SEED_RECORDS
+	t.Setenv("NBDNS_SEED_RECORDS_FILE", "/nonexistent/seed.json")
+
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv() failed: %v", err)
+	}
+	if cfg.SeedFile != "/nonexistent/seed.json" {
+		t.Errorf("SeedFile = %q, want the configured path", cfg.SeedFile)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("NBDNS_DOMAINS", "example.com")
+	t.Setenv("NBDNS_SETUP_KEY", "test-key")
+
+	t.Setenv("NBDNS_TRUSTED_PROXIES", "10.0.0.0/8, 172.16.0.1, fd00::/8")
+	cfg, err := LoadFromEnv()
+	if err != nil {
+		t.Fatalf("LoadFromEnv() failed: %v", err)
+	}
+	var got []string
+	for _, network := range cfg.TrustedProxies {
+		got = append(got, network.String())
+	}
+	if want := "[10.0.0.0/8 172.16.0.1/32 fd00::/8]"; fmt.Sprint(got) != want {
+		t.Errorf("TrustedProxies = %v, want %s", got, want)
+	}
+
+	t.Setenv("NBDNS_TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+	if _, err := LoadFromEnv(); err == nil {
+		t.Error("LoadFromEnv() accepted a hostname as a trusted proxy")
+	}
+}
+
+func TestDNSReusePort(t *testing.T) {
+	tests := []struct {
+		value       string
+		want        bool
+		wantSockets int
+		wantErr     bool
+	}{
+		{value: "", want: false},
+		{value: "true", want: true},
+		{value: "false", want: false},
+		{value: "8", want: true, wantSockets: 8},
+		{value: "0", want: false},
+		{value: "-1", wantErr: true},
+		{value: "1025", wantErr: true},
+		{value: "many", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("NBDNS_DOMAINS", "example.com")
+			t.Setenv("NBDNS_SETUP_KEY", "test-key")
+			t.Setenv("NBDNS_DNS_REUSEPORT", tt.value)
+
+			cfg, err := LoadFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadFromEnv() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFromEnv() failed: %v", err)
+			}
+			if cfg.DNSReusePort != tt.want || cfg.DNSSockets != tt.wantSockets {
+				t.Errorf("reuseport = %v with %d sockets, want %v with %d", cfg.DNSReusePort, cfg.DNSSockets, tt.want, tt.wantSockets)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// fileSuffix marks a variable whose value is read from the file it names
+const fileSuffix = "_FILE"
+
+// Getenv returns the value of the environment variable key. When key is unset and
+// key_FILE names a file, the file's contents (without trailing newlines) are
+// returned instead, so secrets such as the setup key can be mounted as Docker or
+// Kubernetes secret files rather than passed in the environment.
+func Getenv(key string) (string, error) {
+	if value := os.Getenv(key); value != "" {
+		return value, nil
+	}
+
+	path := os.Getenv(key + fileSuffix)
+	if path == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s%s: %w", key, fileSuffix, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// getEnv returns the value of key, falling back to key_FILE. Unreadable files
+// are reported up front by checkEnvFiles.
+func getEnv(key string) string {
+	value, _ := Getenv(key)
+	return value
+}
+
+// checkEnvFiles makes sure every NBDNS_*_FILE variable points at a readable file
+func checkEnvFiles() error {
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, "NBDNS_") || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+
+		// NBDNS_RECORDS_FILE and NBDNS_SEED_RECORDS_FILE are path settings in their
+		// own right, not secret files
+		if name == "NBDNS_RECORDS_FILE" || name == "NBDNS_SEED_RECORDS_FILE" {
+			continue
+		}
+
+		if _, err := Getenv(strings.TrimSuffix(name, fileSuffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
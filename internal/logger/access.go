@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Access writes entry as a single JSON line. Access logs are enabled explicitly,
+// so they are written regardless of the current level and without the usual
+// timestamp prefix, keeping every line valid JSON.
+func Access(entry interface{}) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		Error("Failed to encode access log entry: %v", err)
+		return
+	}
+	fmt.Fprintln(logger.Writer(), string(data))
+}
+
+// Event writes entry as a single JSON line at info level, without the
+// timestamp prefix, for events log pipelines key on
+func Event(entry interface{}) {
+	if currentLevel > LevelInfo {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		Error("Failed to encode log event: %v", err)
+		return
+	}
+	fmt.Fprintln(logger.Writer(), string(data))
+}
@@ -0,0 +1,57 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// maxLineLength bounds how much unterminated output is buffered before it is logged anyway
+const maxLineLength = 64 * 1024
+
+// lineWriter logs every line written to it with a source prefix
+type lineWriter struct {
+	source string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// NewLineWriter returns a writer that logs each complete line written to it as "[source] line".
+// It is meant for child process output, which is already filtered by the child's own log level,
+// so lines are logged regardless of the current level.
+func NewLineWriter(source string) io.Writer {
+	return &lineWriter{source: source}
+}
+
+// Write logs the complete lines in p and buffers any trailing partial line
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logLine(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+
+	// Don't let a child that never writes a newline grow the buffer without bound
+	if len(w.buf) > maxLineLength {
+		w.logLine(w.buf)
+		w.buf = nil
+	}
+
+	return len(p), nil
+}
+
+// logLine logs a single line of child output
+func (w *lineWriter) logLine(line []byte) {
+	text := strings.TrimRight(string(line), "\r")
+	if text != "" {
+		logger.Printf("[%s] %s", w.source, text)
+	}
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ACL actions
+const (
+	aclAllow = "allow"
+	aclDeny  = "deny"
+)
+
+// queryACL decides which clients may query the configured domains. The rule
+// with the most specific CIDR containing the client wins, like views do;
+// clients that match no rule are allowed.
+type queryACL []aclRule
+
+type aclRule struct {
+	network *net.IPNet
+	allow   bool
+}
+
+// parseQueryACL parses a comma-separated list of CIDR=allow|deny rules,
+// e.g. "100.64.0.0/10=allow,0.0.0.0/0=deny"
+func parseQueryACL(value string) (queryACL, error) {
+	var acl queryACL
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		cidr, action, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule '%s': expected CIDR=allow or CIDR=deny", entry)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR in rule '%s'", entry)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(action)) {
+		case aclAllow:
+			acl = append(acl, aclRule{network: network, allow: true})
+		case aclDeny:
+			acl = append(acl, aclRule{network: network, allow: false})
+		default:
+			return nil, fmt.Errorf("invalid action in rule '%s': must be allow or deny", entry)
+		}
+	}
+	return acl, nil
+}
+
+// allowed reports whether ip may query the configured domains
+func (acl queryACL) allowed(ip net.IP) bool {
+	allow, bestBits := true, -1
+	for _, rule := range acl {
+		if ip == nil || !rule.network.Contains(ip) {
+			continue
+		}
+		if bits, _ := rule.network.Mask.Size(); bits > bestBits {
+			allow, bestBits = rule.allow, bits
+		}
+	}
+	return allow
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"net"
+	"testing"
+)
+
+func TestQueryACL(t *testing.T) {
+	acl, err := parseQueryACL("100.64.0.0/10=allow, 100.64.5.0/24=deny, 100.64.5.7/32=allow, 0.0.0.0/0=deny, fd00::/8=ALLOW")
+	if err != nil {
+		t.Fatalf("parseQueryACL() failed: %v", err)
+	}
+
+	// The most specific CIDR containing the client wins, whatever the rule order
+	tests := []struct {
+		ip    string
+		allow bool
+	}{
+		{"100.64.0.1", true},
+		{"100.127.255.254", true},
+		{"100.64.5.1", false},
+		{"100.64.5.7", true},
+		{"192.168.1.10", false},
+		{"fd00::1", true},
+		// IPv6 clients outside every rule are allowed
+		{"2001:db8::1", true},
+	}
+	for _, tt := range tests {
+		if got := acl.allowed(net.ParseIP(tt.ip)); got != tt.allow {
+			t.Errorf("allowed(%s) = %v, want %v", tt.ip, got, tt.allow)
+		}
+	}
+	if !acl.allowed(nil) {
+		t.Error("allowed(nil) = false, want clients without an address to match no rule")
+	}
+}
+
+func TestParseQueryACLErrors(t *testing.T) {
+	for _, value := range []string{
+		"100.64.0.0/10",
+		"100.64.0.0/10=maybe",
+		"100.64.0.0=allow",
+		"not-a-cidr=deny",
+		"100.64.0.0/10=allow,10.0.0.0/33=deny",
+	} {
+		if _, err := parseQueryACL(value); err == nil {
+			t.Errorf("parseQueryACL(%q) succeeded, want an error", value)
+		}
+	}
+
+	acl, err := parseQueryACL(" , 10.0.0.0/8=deny ,")
+	if err != nil || len(acl) != 1 {
+		t.Errorf("parseQueryACL() with empty entries = %v, %v, want one rule", acl, err)
+	}
+}
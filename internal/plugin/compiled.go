@@ -0,0 +1,176 @@
+package plugin
+
+import (
+	"strings"
+	"time"
+
+	"netbird-coredns/internal/api"
+	"netbird-coredns/pkg/dns"
+)
+
+// zoneSet holds the configured domains for matching query names by suffix.
+// A name is checked one label at a time, so matching costs O(labels) map
+// lookups however many domains are configured.
+type zoneSet map[string]bool
+
+// newZoneSet builds the zone set of domains, given without trailing dots
+func newZoneSet(domains []string) zoneSet {
+	zones := make(zoneSet, len(domains))
+	for _, domain := range domains {
+		zones[strings.ToLower(domain)] = true
+	}
+	return zones
+}
+
+// contains reports whether name falls under one of the configured domains
+func (z zoneSet) contains(name string) bool {
+	_, ok := z.outermost(dns.TrimDot(strings.ToLower(name)))
+	return ok
+}
+
+// outermost returns the shortest configured domain that name, given in lower
+// case without a trailing dot, falls under. Every suffix of name at least as
+// long is in zone as well.
+func (z zoneSet) outermost(name string) (string, bool) {
+	zone, found := "", false
+	for {
+		if z[name] {
+			zone, found = name, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return zone, found
+		}
+		name = name[i+1:]
+	}
+}
+
+// innermost returns the longest configured domain that name, given in lower
+// case without a trailing dot, falls under: the zone that owns the name
+func (z zoneSet) innermost(name string) (string, bool) {
+	for {
+		if z[name] {
+			return name, true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return "", false
+		}
+		name = name[i+1:]
+	}
+}
+
+// compiledName is a record set indexed by its fully qualified name, with the
+// domain and name it is stored under
+type compiledName struct {
+	domain string
+	name   string
+	set    dns.RecordSet
+}
+
+// compiledZone is an immutable index of the stored records by fully
+// qualified name, rebuilt whenever the records change and swapped in
+// atomically. Lookups take one map access instead of splitting the query
+// name and walking the storage's domain and name maps.
+type compiledZone struct {
+	// generation is the storage generation the index was built from
+	generation uint64
+	names      map[string]compiledName
+	// defaults holds the catch-all record set of each domain that has one
+	defaults map[string]dns.RecordSet
+}
+
+// compileZone indexes view, the storage's published records, the way
+// api.SplitName maps query names to them: a configured domain answers from its
+// root domain record, any other name from the record stored under its first
+// label in the domain formed by the remaining labels.
+func compileZone(zones zoneSet, view map[string]map[string]dns.RecordSet, generation uint64) *compiledZone {
+	c := &compiledZone{
+		generation: generation,
+		names:      make(map[string]compiledName),
+		defaults:   make(map[string]dns.RecordSet),
+	}
+	for domain, names := range view {
+		for name, set := range names {
+			switch {
+			case name == api.DefaultRecordName:
+				c.defaults[domain] = set
+			case name == "":
+				// Only configured domains are answered from their root domain record
+				if zones[domain] {
+					c.names[domain] = compiledName{domain: domain, set: set}
+				}
+			case !strings.Contains(name, "."):
+				// A configured domain's apex takes precedence over a record of the same name
+				if fqdn := name + "." + domain; !zones[fqdn] {
+					c.names[fqdn] = compiledName{domain: domain, name: name, set: set}
+				}
+			}
+		}
+	}
+	return c
+}
+
+// lookup returns the unexpired records stored for queryName
+func (c *compiledZone) lookup(queryName string) (compiledName, bool) {
+	entry, ok := c.names[dns.TrimDot(strings.ToLower(queryName))]
+	if !ok {
+		return compiledName{}, false
+	}
+
+	// Expired records are treated as absent even before they are purged
+	now := time.Now()
+	for _, record := range entry.set {
+		if record.IsExpired(now) {
+			entry.set = entry.set.Without(func(r *dns.Record) bool { return r.IsExpired(now) })
+			break
+		}
+	}
+	return entry, len(entry.set) > 0
+}
+
+// defaultRecord returns the catch-all record of the nearest enclosing domain of
+// queryName, as api.Storage.DefaultRecord does. A CNAME catch-all is preferred
+// over an A one, and the zone apex itself never gets one.
+func (c *compiledZone) defaultRecord(zones zoneSet, queryName string) (*dns.Record, string, bool) {
+	name := dns.TrimDot(strings.ToLower(queryName))
+	outer, ok := zones.outermost(name)
+	if !ok {
+		return nil, "", false
+	}
+
+	now := time.Now()
+	for i := strings.IndexByte(name, '.'); i >= 0 && len(name)-i-1 >= len(outer); i = strings.IndexByte(name, '.') {
+		name = name[i+1:]
+
+		set := c.defaults[name]
+		for _, recordType := range []dns.RecordType{dns.RecordTypeCNAME, dns.RecordTypeA} {
+			if record := set.Get(recordType); record != nil && !record.IsExpired(now) {
+				return record, name, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// zone returns the compiled index of the stored records, which is empty
+// before the first compile
+func (n *NetBird) zone() *compiledZone {
+	if c := n.compiled.Load(); c != nil {
+		return c
+	}
+	return &compiledZone{}
+}
+
+// compile rebuilds the index of the stored records unless it is already
+// current. It runs after every reload, so queries never rebuild it.
+func (n *NetBird) compile() {
+	if n.storage == nil {
+		return
+	}
+	generation := n.storage.Generation()
+	if c := n.compiled.Load(); c != nil && c.generation == generation {
+		return
+	}
+	n.compiled.Store(compileZone(n.zones, n.storage.View(), generation))
+}
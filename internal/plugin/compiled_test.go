@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+
+	"netbird-coredns/internal/api"
+	"netbird-coredns/pkg/dns"
+)
+
+// benchmarkRecords stores count A records in example.com and returns the plugin serving them
+func benchmarkRecords(b *testing.B, count int) *NetBird {
+	records := make([]*dns.Record, 0, count)
+	for i := 0; i < count; i++ {
+		records = append(records, &dns.Record{
+			Name:   fmt.Sprintf("peer%d", i),
+			Domain: "example.com",
+			Type:   dns.RecordTypeA,
+			Value:  fmt.Sprintf("100.64.%d.%d", i/256%256, i%256),
+		})
+	}
+	return newTestPlugin(b, writeRecords(b, records...))
+}
+
+func TestCompileZone(t *testing.T) {
+	n := newTestPlugin(t, writeRecords(t,
+		&dns.Record{Name: "", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.1"},
+		&dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"},
+		&dns.Record{Name: "db", Domain: "prod.example.com", Type: dns.RecordTypeA, Value: "100.64.0.20"},
+		&dns.Record{Name: api.DefaultRecordName, Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.99"},
+	))
+
+	// Every name resolves to the record set api.SplitName maps it to
+	for _, name := range []string{"example.com.", "WEB.example.com", "db.prod.example.com.", "missing.example.com."} {
+		domain, recordName, _ := api.SplitName(n.Domains, name)
+		want, _ := n.storage.GetRecords(domain, recordName)
+
+		entry, ok := n.zone().lookup(name)
+		if ok != (len(want) > 0) {
+			t.Errorf("lookup(%s) found = %v, want %v", name, ok, len(want) > 0)
+			continue
+		}
+		if ok && (entry.set.Get(dns.RecordTypeA).Value != want.Get(dns.RecordTypeA).Value || entry.domain != domain || entry.name != recordName) {
+			t.Errorf("lookup(%s) = %s in %q/%q, want %s in %q/%q", name,
+				entry.set.Get(dns.RecordTypeA).Value, entry.domain, entry.name,
+				want.Get(dns.RecordTypeA).Value, domain, recordName)
+		}
+	}
+
+	// The catch-all record is only served through defaultRecord
+	if _, ok := n.zone().lookup(api.DefaultRecordName + ".example.com."); ok {
+		t.Error("lookup() returned the catch-all record by name")
+	}
+	if record, domain, ok := n.zone().defaultRecord(n.zones, "missing.example.com."); !ok || domain != "example.com" || record.Value != "100.64.0.99" {
+		t.Errorf("defaultRecord() = %v, %q, %v, want the catch-all record of example.com", record, domain, ok)
+	}
+}
+
+// BenchmarkCompiledLookup and BenchmarkStorageLookup compare the compiled
+// index with splitting the query name and looking it up in the storage
+func BenchmarkCompiledLookup(b *testing.B) {
+	for _, count := range []int{10, 1000, 100000} {
+		b.Run(fmt.Sprintf("records=%d", count), func(b *testing.B) {
+			n := benchmarkRecords(b, count)
+			name := fmt.Sprintf("peer%d.example.com.", count/2)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, ok := n.zone().lookup(name); !ok {
+					b.Fatalf("lookup(%s) found nothing", name)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStorageLookup(b *testing.B) {
+	for _, count := range []int{10, 1000, 100000} {
+		b.Run(fmt.Sprintf("records=%d", count), func(b *testing.B) {
+			n := benchmarkRecords(b, count)
+			name := fmt.Sprintf("peer%d.example.com.", count/2)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				domain, recordName, ok := api.SplitName(n.Domains, name)
+				if !ok {
+					b.Fatalf("SplitName(%s) failed", name)
+				}
+				if _, err := n.storage.GetRecords(domain, recordName); err != nil {
+					b.Fatalf("GetRecords(%s) failed: %v", name, err)
+				}
+			}
+		})
+	}
+}
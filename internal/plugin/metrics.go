@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"github.com/coredns/coredns/plugin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// recordHitsCount counts lookups that matched a custom record for the
+	// NBDNS_TOP_RECORDS most queried records, keyed by domain and name
+	recordHitsCount = registerTopRecords()
+
+	// rrlTruncatedCount counts UDP responses truncated by response rate limiting
+	rrlTruncatedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "rrl_truncated_total",
+		Help:      "Counter of UDP responses truncated by response rate limiting.",
+	})
+
+	// responsesCount counts responses answered by this plugin, keyed by rcode and query type
+	responsesCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "responses_total",
+		Help:      "Counter of responses answered by the plugin, by rcode and query type.",
+	}, []string{"rcode", "qtype"})
+
+	// truncatedCount counts responses sent with the TC bit, keyed by reason and query type
+	truncatedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "truncated_responses_total",
+		Help:      "Counter of truncated responses, by reason (size, force_tcp or rate_limit) and query type.",
+	}, []string{"reason", "qtype"})
+
+	// delayedCount counts responses held back by NBDNS_RESPONSE_DELAY
+	delayedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "delayed_responses_total",
+		Help:      "Counter of responses delayed for chaos testing.",
+	})
+
+	// responseDelaySeconds reports the delay injected before every response
+	responseDelaySeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "response_delay_seconds",
+		Help:      "Delay injected before every response for chaos testing, 0 when disabled.",
+	})
+
+	// shedCount counts in-zone queries answered with SERVFAIL because of the concurrency limit
+	shedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "queries_shed_total",
+		Help:      "Counter of queries shed because the concurrent lookup limit was reached.",
+	})
+
+	// aclRefusedCount counts in-zone queries refused by the query ACL
+	aclRefusedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "acl_refused_total",
+		Help:      "Counter of queries refused by the query ACL.",
+	})
+
+	// refreshCount counts record reloads from disk, keyed by result
+	refreshCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "refresh_total",
+		Help:      "Counter of record reloads from disk, by result.",
+	}, []string{"result"})
+
+	// refreshDuration observes how long each record reload from disk takes
+	refreshDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "refresh_duration_seconds",
+		Help:      "Histogram of the time taken to reload records from disk.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+
+	// invalidRecords reports the records left out by NBDNS_VALIDATE_ON_RELOAD
+	invalidRecords = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "invalid_records",
+		Help:      "Number of records skipped by the last reload because they failed validation.",
+	})
+
+	// refreshLastSuccess records when records were last reloaded successfully
+	refreshLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "netbird",
+		Name:      "refresh_last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful record reload from disk.",
+	})
+)
+
+// registerTopRecords registers the per-record hit counters, which are disabled
+// until a limit is set
+func registerTopRecords() *topRecords {
+	t := newTopRecords()
+	prometheus.MustRegister(t)
+	return t
+}
@@ -1,49 +1,295 @@
 package plugin
 
 import (
+	"fmt"
+	"math/rand"
 	"net"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/fall"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 
 	"netbird-coredns/internal/api"
+	"netbird-coredns/internal/config"
+	"netbird-coredns/pkg/dns"
+)
+
+// defaultTTL is served for records stored without a TTL
+const defaultTTL = 60
+
+// Multi-value answer orders
+const (
+	// answerOrderFixed keeps the stored order, so the first value is always the primary
+	answerOrderFixed = "fixed"
+	// answerOrderShuffle randomizes the order of every answer
+	answerOrderShuffle = "shuffle"
+	// answerOrderRoundRobin rotates the starting value on every answer
+	answerOrderRoundRobin = "roundrobin"
 )
 
 type record struct {
-	IPv4 net.IP
+	IPv4   []net.IP
+	Target string
+	TTL    uint32
+}
+
+// parseIPv4s parses the addresses of an A record, skipping any that are invalid
+func parseIPv4s(values []string) []net.IP {
+	ips := make([]net.IP, 0, len(values))
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// recordTTL returns the TTL to serve for a stored record. The record's own TTL
+// (or defaultTTL when unset) is taken first and spread by TTLJitter, then raised
+// to TTLMin and lowered to TTLMax, so the clamp always wins over an explicit
+// record TTL.
+func (n *NetBird) recordTTL(r *dns.Record) uint32 {
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	ttl = n.jitterTTL(ttl)
+	if n.TTLMin > 0 && ttl < n.TTLMin {
+		ttl = n.TTLMin
+	}
+	if n.TTLMax > 0 && ttl > n.TTLMax {
+		ttl = n.TTLMax
+	}
+	return ttl
+}
+
+// jitterTTL moves ttl by a random amount of up to TTLJitter percent in either
+// direction, so answers cached at the same time do not all expire together. The
+// result is never below 1.
+func (n *NetBird) jitterTTL(ttl uint32) uint32 {
+	spread := int64(ttl) * int64(n.TTLJitter) / 100
+	if spread == 0 {
+		return ttl
+	}
+	jittered := int64(ttl) - spread + rand.Int63n(2*spread+1)
+	if jittered < 1 {
+		return 1
+	}
+	return uint32(jittered)
+}
+
+// nsTTL returns the TTL served for NBDNS_ZONE_NS answers: the default record
+// TTL, clamped like the TTL of a stored record
+func (n *NetBird) nsTTL() uint32 {
+	return n.recordTTL(&dns.Record{})
 }
 
 // NetBird represents the NetBird CoreDNS plugin
 type NetBird struct {
-	Next    plugin.Handler
-	Domains []string
-	storage *api.Storage
+	Next     plugin.Handler
+	Domains  []string
+	Compress bool
+	// Fall passes in-zone queries without a custom record on to the next plugin
+	Fall fall.F
+	// TTLMin and TTLMax clamp served TTLs; 0 leaves that side unbounded
+	TTLMin uint32
+	TTLMax uint32
+	// TTLJitter spreads served TTLs randomly by up to this percentage
+	TTLJitter int
+	// Forward, when set, is the upstream address queries the plugin does not
+	// answer are sent to instead of the next plugin
+	Forward string
+	// UpstreamTimeout bounds each query sent to Forward
+	UpstreamTimeout time.Duration
+	// ForceTCPOverBytes truncates UDP responses larger than this many bytes so
+	// clients retry over TCP; 0 only truncates to the client's buffer size
+	ForceTCPOverBytes int
+	// ResponseDelay holds back every response the plugin writes, for testing
+	// how clients cope with slow DNS; it is only set when NBDNS_CHAOS is enabled
+	ResponseDelay time.Duration
+	// ChaosVersion, when set, answers version.bind and hostname.bind CHAOS queries
+	ChaosVersion  string
+	ChaosHostname string
+	storage       *api.Storage
+
+	// AnswerOrder controls how multi-value answers are ordered: fixed, shuffle or roundrobin
+	AnswerOrder string
+	// AQueryOrder decides whether A queries check a name's CNAME or its A
+	// record first: config.AQueryCNAMEFirst or config.AQueryAFirst
+	AQueryOrder string
+	rrCounter   atomic.Uint64
+
+	// rrl limits UDP responses per client prefix; nil disables rate limiting
+	rrl *rateLimiter
+	// inflight bounds concurrent in-zone lookups; nil leaves them unbounded
+	inflight chan struct{}
+	// acl refuses in-zone queries from denied clients; nil allows everyone
+	acl queryACL
+	// AutoPTR answers reverse queries for addresses of in-zone A records
+	AutoPTR bool
+	// zones matches query names against Domains
+	zones zoneSet
+	// compiled indexes the stored records by name; rebuilt after every reload
+	compiled atomic.Pointer[compiledZone]
+
+	// ZoneNS lists the fully qualified nameservers answered for NS queries at
+	// the apex of every configured domain
+	ZoneNS []string
+	// SOA is served with authoritative negative answers
+	SOA SOA
+
+	maintenance *api.Maintenance
+	// connection, when set, makes in-zone queries fail with SERVFAIL while
+	// NetBird is disconnected
+	connection *api.ConnectionStatus
+
+	refreshInterval time.Duration
+}
+
+// Options holds plugin settings that can be given in the Corefile. Zero values
+// fall back to the NBDNS_* environment variables.
+type Options struct {
+	// RecordsFile overrides NBDNS_RECORDS_FILE
+	RecordsFile string
+	// RefreshInterval overrides NBDNS_REFRESH_INTERVAL
+	RefreshInterval time.Duration
 }
 
 // New creates a new NetBird plugin instance
-func New(domains []string) (*NetBird, error) {
+func New(domains []string, opts Options) (*NetBird, error) {
+	// Domains are matched without their trailing dot, like stored records
+	for i, domain := range domains {
+		domains[i] = strings.TrimSuffix(domain, ".")
+	}
+
 	nb := &NetBird{
-		Domains: domains,
+		Domains:      domains,
+		zones:        newZoneSet(domains),
+		Compress:     getCompress(),
+		ChaosVersion: getenv("NBDNS_CHAOS_VERSION"),
+		AnswerOrder:  getAnswerOrder(),
+		AQueryOrder:  getAQueryOrder(),
+		AutoPTR:      getAutoPTR(),
+
+		refreshInterval: opts.RefreshInterval,
+	}
+	if nb.refreshInterval <= 0 {
+		nb.refreshInterval = getRefreshInterval()
 	}
+	nb.UpstreamTimeout = getUpstreamTimeout()
+	nb.TTLMin, nb.TTLMax = getTTLBounds()
+	nb.TTLJitter = getTTLJitter()
+	nb.ForceTCPOverBytes = getForceTCPOverBytes()
+	nb.ResponseDelay = getResponseDelay()
+	responseDelaySeconds.Set(nb.ResponseDelay.Seconds())
+	if nb.ResponseDelay > 0 {
+		clog.Warningf("Chaos testing: delaying every response by %s", nb.ResponseDelay)
+	}
+	recordHitsCount.SetLimit(getTopRecords())
+
+	if aclStr := getenv("NBDNS_QUERY_ACL"); aclStr != "" {
+		acl, err := parseQueryACL(aclStr)
+		if err != nil {
+			clog.Errorf("Invalid NBDNS_QUERY_ACL: %v", err)
+			return nil, fmt.Errorf("invalid NBDNS_QUERY_ACL value: %w", err)
+		}
+		nb.acl = acl
+		clog.Infof("Query ACL enabled with %d rule(s)", len(acl))
+	}
+
+	if nsStr := getenv("NBDNS_ZONE_NS"); nsStr != "" {
+		ns, err := parseZoneNS(nsStr)
+		if err != nil {
+			clog.Errorf("Invalid NBDNS_ZONE_NS: %v", err)
+			return nil, fmt.Errorf("invalid NBDNS_ZONE_NS value: %w", err)
+		}
+		nb.ZoneNS = ns
+		clog.Infof("Answering NS queries at the zone apex with %s", strings.Join(ns, ", "))
+	}
+
+	soa, err := getSOA()
+	if err != nil {
+		clog.Errorf("Invalid SOA settings: %v", err)
+		return nil, err
+	}
+	nb.SOA = soa
 
-	// Initialize storage from environment variable
-	recordsFile := os.Getenv("NBDNS_RECORDS_FILE")
+	if limit := getMaxConcurrentQueries(); limit > 0 {
+		nb.inflight = make(chan struct{}, limit)
+		clog.Infof("Limiting concurrent in-zone lookups to %d", limit)
+	}
+
+	if rate := getRRLRate(); rate > 0 {
+		nb.rrl = newRateLimiter(rate)
+		clog.Infof("Response rate limiting enabled: %d responses/sec per client prefix", rate)
+	}
+
+	// hostname.bind reports the NetBird peer hostname when known
+	if nb.ChaosVersion != "" {
+		nb.ChaosHostname = getenv("NBDNS_HOSTNAME")
+		if nb.ChaosHostname == "" {
+			nb.ChaosHostname, _ = os.Hostname()
+		}
+	}
+
+	// Initialize storage from the Corefile or environment variable
+	recordsFile := opts.RecordsFile
+	if recordsFile == "" {
+		recordsFile = getenv("NBDNS_RECORDS_FILE")
+	}
 	if recordsFile == "" {
 		recordsFile = "/etc/nb-dns/records/records.json"
 	}
 
-	storage, err := api.NewStorage(recordsFile)
+	storageOpts := api.StorageOptions{
+		ShardDir:     getenv("NBDNS_RECORDS_DIR"),
+		SeedFile:     getenv("NBDNS_SEED_RECORDS_FILE"),
+		ReverseIndex: nb.AutoPTR,
+		// The plugin never saves, so records left out cannot be lost from disk
+		ValidateOnLoad: getValidateOnReload(),
+	}
+	encoded, err := config.Getenv("NBDNS_RECORDS_PUBKEY")
+	if err != nil {
+		clog.Errorf("Failed to read records public key: %v", err)
+		return nil, err
+	}
+	if encoded != "" {
+		publicKey, err := api.ParsePublicKey(encoded)
+		if err != nil {
+			clog.Errorf("Invalid NBDNS_RECORDS_PUBKEY: %v", err)
+			return nil, err
+		}
+		storageOpts.PublicKey = publicKey
+	}
+
+	storage, err := api.NewStorageWithOptions(recordsFile, storageOpts)
 	if err != nil {
 		clog.Errorf("Failed to initialize storage: %v", err)
 		return nil, err
 	}
 
 	nb.storage = storage
-	clog.Infof("Initialized storage with records file: %s", recordsFile)
+	nb.compile()
+	invalidRecords.Set(float64(storage.InvalidRecords()))
+	nb.maintenance = api.NewMaintenance(recordsFile)
+	if getDisconnectedServfail() {
+		nb.connection = api.NewConnectionStatus(recordsFile)
+		clog.Info("Answering in-zone queries with SERVFAIL while NetBird is disconnected")
+	}
+	if storageOpts.ShardDir != "" {
+		clog.Infof("Initialized sharded storage in records directory: %s", storageOpts.ShardDir)
+	} else {
+		clog.Infof("Initialized storage with records file: %s", recordsFile)
+	}
+
+	if nb.AutoPTR {
+		clog.Info("Automatic PTR answers enabled for addresses of in-zone A records")
+	}
 
 	// Start periodic refresh for storage
 	go nb.periodicRefresh()
@@ -54,14 +300,52 @@ func New(domains []string) (*NetBird, error) {
 // Initialize sets up the storage after configuration is loaded
 func (n *NetBird) Initialize(storage *api.Storage) {
 	n.storage = storage
+	if n.refreshInterval <= 0 {
+		n.refreshInterval = getRefreshInterval()
+	}
 
 	// Start periodic refresh
 	go n.periodicRefresh()
 }
 
+// getenv returns the value of the environment variable key, falling back to
+// the file named by key_FILE like the service's own configuration
+func getenv(key string) string {
+	value, err := config.Getenv(key)
+	if err != nil {
+		clog.Warningf("%v, ignoring %s", err, key)
+	}
+	return value
+}
+
+// parseZoneNS parses a comma-separated list of nameserver hostnames into fully
+// qualified names
+func parseZoneNS(s string) ([]string, error) {
+	var servers []string
+	seen := make(map[string]bool)
+	for _, host := range strings.Split(s, ",") {
+		host = strings.ToLower(dns.TrimDot(strings.TrimSpace(host)))
+		if host == "" {
+			continue
+		}
+		if !dns.IsValidDomain(host) {
+			return nil, fmt.Errorf("invalid nameserver hostname: %s", host)
+		}
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		servers = append(servers, host+".")
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no nameserver hostnames given")
+	}
+	return servers, nil
+}
+
 // getRefreshInterval returns the refresh interval in seconds from environment variable
 func getRefreshInterval() time.Duration {
-	if intervalStr := os.Getenv("NBDNS_REFRESH_INTERVAL"); intervalStr != "" {
+	if intervalStr := getenv("NBDNS_REFRESH_INTERVAL"); intervalStr != "" {
 		if interval, err := strconv.Atoi(intervalStr); err == nil && interval > 0 {
 			return time.Duration(interval) * time.Second
 		}
@@ -70,10 +354,189 @@ func getRefreshInterval() time.Duration {
 	return 15 * time.Second
 }
 
+// getCompress returns whether DNS name compression is enabled from environment variable
+func getCompress() bool {
+	if compressStr := getenv("NBDNS_DNS_COMPRESS"); compressStr != "" {
+		if compress, err := strconv.ParseBool(compressStr); err == nil {
+			return compress
+		}
+		clog.Warningf("invalid NBDNS_DNS_COMPRESS value '%s', using default true", compressStr)
+	}
+	return true
+}
+
+// getAutoPTR returns whether PTR answers are synthesized from A records from environment variable
+func getAutoPTR() bool {
+	if autoPTRStr := getenv("NBDNS_AUTO_PTR"); autoPTRStr != "" {
+		if autoPTR, err := strconv.ParseBool(autoPTRStr); err == nil {
+			return autoPTR
+		}
+		clog.Warningf("invalid NBDNS_AUTO_PTR value '%s', using default false", autoPTRStr)
+	}
+	return false
+}
+
+// getValidateOnReload returns whether loaded records are validated from the environment
+func getValidateOnReload() bool {
+	if validateStr := getenv("NBDNS_VALIDATE_ON_RELOAD"); validateStr != "" {
+		if validate, err := strconv.ParseBool(validateStr); err == nil {
+			return validate
+		}
+		clog.Warningf("invalid NBDNS_VALIDATE_ON_RELOAD value '%s', using default false", validateStr)
+	}
+	return false
+}
+
+// getDisconnectedServfail returns whether in-zone queries fail while NetBird is disconnected from environment variable
+func getDisconnectedServfail() bool {
+	switch response := strings.ToLower(getenv("NBDNS_DISCONNECTED_RESPONSE")); response {
+	case "servfail":
+		return true
+	case "", "serve":
+	default:
+		clog.Warningf("invalid NBDNS_DISCONNECTED_RESPONSE value '%s', using default serve", response)
+	}
+	return false
+}
+
+// getAQueryOrder returns whether A queries check CNAME or A records first from environment variable
+func getAQueryOrder() string {
+	order := strings.ToLower(getenv("NBDNS_A_QUERY_ORDER"))
+	switch order {
+	case config.AQueryCNAMEFirst, config.AQueryAFirst:
+		return order
+	case "":
+	default:
+		clog.Warningf("invalid NBDNS_A_QUERY_ORDER value '%s', using default %s", order, config.AQueryCNAMEFirst)
+	}
+	return config.AQueryCNAMEFirst
+}
+
+// getAnswerOrder returns how multi-value answers are ordered from environment variable
+func getAnswerOrder() string {
+	order := strings.ToLower(getenv("NBDNS_ANSWER_ORDER"))
+	switch order {
+	case answerOrderFixed, answerOrderShuffle, answerOrderRoundRobin:
+		return order
+	case "":
+	default:
+		clog.Warningf("invalid NBDNS_ANSWER_ORDER value '%s', using default %s", order, answerOrderFixed)
+	}
+	return answerOrderFixed
+}
+
+// getUpstreamTimeout returns how long forwarded queries wait for an answer from environment variable
+func getUpstreamTimeout() time.Duration {
+	if timeoutStr := getenv("NBDNS_UPSTREAM_TIMEOUT"); timeoutStr != "" {
+		if timeout, err := time.ParseDuration(timeoutStr); err == nil && timeout > 0 && timeout <= time.Minute {
+			return timeout
+		}
+		clog.Warningf("invalid NBDNS_UPSTREAM_TIMEOUT value '%s', using default %s", timeoutStr, config.DefaultUpstreamTimeout)
+	}
+	return config.DefaultUpstreamTimeout
+}
+
+// getMaxConcurrentQueries returns the limit on concurrent in-zone lookups from environment variable (0 disables it)
+func getMaxConcurrentQueries() int {
+	if limitStr := getenv("NBDNS_MAX_CONCURRENT_QUERIES"); limitStr != "" {
+		if limit, err := strconv.Atoi(limitStr); err == nil && limit >= 0 {
+			return limit
+		}
+		clog.Warningf("invalid NBDNS_MAX_CONCURRENT_QUERIES value '%s', using default 0 (unlimited)", limitStr)
+	}
+	return 0
+}
+
+// getRRLRate returns the response rate limit per client prefix from environment variable (0 disables it)
+func getRRLRate() int {
+	if rateStr := getenv("NBDNS_RRL_RATE"); rateStr != "" {
+		if rate, err := strconv.Atoi(rateStr); err == nil && rate >= 0 {
+			return rate
+		}
+		clog.Warningf("invalid NBDNS_RRL_RATE value '%s', rate limiting disabled", rateStr)
+	}
+	return 0
+}
+
+// getTTLBounds returns the served TTL clamp from NBDNS_TTL_MIN and NBDNS_TTL_MAX
+// (0 means unbounded). A minimum above the maximum is ignored.
+func getTTLBounds() (uint32, uint32) {
+	parse := func(key string) uint32 {
+		if v := getenv(key); v != "" {
+			if ttl, err := strconv.ParseUint(v, 10, 32); err == nil {
+				return uint32(ttl)
+			}
+			clog.Warningf("invalid %s value '%s', ignoring", key, v)
+		}
+		return 0
+	}
+
+	minTTL, maxTTL := parse("NBDNS_TTL_MIN"), parse("NBDNS_TTL_MAX")
+	if minTTL > 0 && maxTTL > 0 && minTTL > maxTTL {
+		clog.Warningf("NBDNS_TTL_MIN (%d) is greater than NBDNS_TTL_MAX (%d), ignoring NBDNS_TTL_MIN", minTTL, maxTTL)
+		minTTL = 0
+	}
+	return minTTL, maxTTL
+}
+
+// getTTLJitter returns the TTL jitter percentage from environment variable
+func getTTLJitter() int {
+	if jitterStr := getenv("NBDNS_TTL_JITTER"); jitterStr != "" {
+		if jitter, err := strconv.Atoi(jitterStr); err == nil && jitter >= 0 && jitter <= 50 {
+			return jitter
+		}
+		clog.Warningf("invalid NBDNS_TTL_JITTER value '%s' (0-50), using default 0", jitterStr)
+	}
+	return 0
+}
+
+// getForceTCPOverBytes returns the UDP response size above which clients are
+// sent to TCP from the environment (0 disables it). Responses under 512 bytes
+// always fit a UDP datagram, so smaller thresholds are rejected.
+func getForceTCPOverBytes() int {
+	if sizeStr := getenv("NBDNS_FORCE_TCP_OVER_BYTES"); sizeStr != "" {
+		if size, err := strconv.Atoi(sizeStr); err == nil && (size == 0 || (size >= 512 && size <= 65535)) {
+			return size
+		}
+		clog.Warningf("invalid NBDNS_FORCE_TCP_OVER_BYTES value '%s' (0 or 512-65535), using default 0", sizeStr)
+	}
+	return 0
+}
+
+// getResponseDelay returns the delay injected before every response from the
+// environment. As a testing feature it is ignored unless NBDNS_CHAOS=true, so a
+// stray setting cannot slow down production DNS.
+func getResponseDelay() time.Duration {
+	delayStr := getenv("NBDNS_RESPONSE_DELAY")
+	if delayStr == "" {
+		return 0
+	}
+	if chaos, _ := strconv.ParseBool(getenv("NBDNS_CHAOS")); !chaos {
+		clog.Warningf("NBDNS_RESPONSE_DELAY is ignored unless NBDNS_CHAOS=true")
+		return 0
+	}
+	if delay, err := time.ParseDuration(delayStr); err == nil && delay >= 0 && delay <= 30*time.Second {
+		return delay
+	}
+	clog.Warningf("invalid NBDNS_RESPONSE_DELAY value '%s' (0-30s), using default 0", delayStr)
+	return 0
+}
+
+// getTopRecords returns how many of the most queried records get a hit counter
+// from the environment (0 disables per-record counters)
+func getTopRecords() int {
+	if topStr := getenv("NBDNS_TOP_RECORDS"); topStr != "" {
+		if top, err := strconv.Atoi(topStr); err == nil && top >= 0 && top <= 10000 {
+			return top
+		}
+		clog.Warningf("invalid NBDNS_TOP_RECORDS value '%s' (0-10000), using default 0", topStr)
+	}
+	return 0
+}
+
 // periodicRefresh periodically reloads the DNS records from disk
 func (n *NetBird) periodicRefresh() {
-	interval := getRefreshInterval()
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(n.refreshInterval)
 	defer ticker.Stop()
 
 	// Initial refresh
@@ -88,135 +551,178 @@ func (n *NetBird) periodicRefresh() {
 func (n *NetBird) refresh() {
 	// Reload custom DNS records from disk
 	if n.storage != nil {
-		if err := n.storage.Reload(); err != nil {
+		start := time.Now()
+		err := n.storage.Reload()
+		refreshDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			refreshCount.WithLabelValues("failure").Inc()
 			clog.Errorf("failed to reload storage from disk: %v", err)
 		} else {
+			refreshCount.WithLabelValues("success").Inc()
+			refreshLastSuccess.SetToCurrentTime()
+			invalidRecords.Set(float64(n.storage.InvalidRecords()))
+			n.compile()
 			clog.Debugf("Reloaded custom DNS records from disk")
 		}
 	}
 }
 
-// lookupCustomRecord checks for custom DNS records in storage
-func (n *NetBird) lookupCustomRecord(queryName string) (record, bool) {
-	if n.storage == nil {
+// lookupCustomRecord returns the A record stored for queryName if it answers
+// queries of qtype. CNAME records are resolved separately by ResolveCNAME.
+// clientIP selects the record's view value, if any; it may be nil.
+func (n *NetBird) lookupCustomRecord(queryName, qtype string, clientIP net.IP) (record, bool) {
+	customRecord, domain, name, ok := n.findRecord(queryName, dns.RecordTypeA, qtype)
+	if !ok {
 		return record{}, false
 	}
+	clog.Debugf("Found custom record: %+v", customRecord)
 
-	// Check if this is a root domain query (query name exactly matches a configured domain)
-	queryNameTrimmed := strings.TrimSuffix(queryName, ".")
-	for _, domain := range n.Domains {
-		if queryNameTrimmed == domain {
-			// This is a root domain query
-			clog.Debugf("Looking up root domain record: domain=%s", domain)
-			customRecord, err := n.storage.GetRecord(domain, "")
-			if err != nil {
-				clog.Debugf("Root domain record lookup failed: %v", err)
-				return record{}, false
-			}
-			clog.Debugf("Found root domain record: %+v", customRecord)
-
-			var rec record
-			switch customRecord.Type {
-			case "A":
-				rec.IPv4 = net.ParseIP(customRecord.Value)
-				return rec, true
-			case "CNAME":
-				// For CNAME, we need to resolve the target
-				// This is handled differently in serve.go
-				return record{}, false
+	recordHitsCount.Inc(domain, name)
+	return record{
+		IPv4: parseIPv4s(customRecord.ValuesFor(clientIP)),
+		TTL:  n.recordTTL(customRecord),
+	}, true
+}
+
+// restricted reports whether a record of queryName, or the catch-all record
+// of a name without records, would answer a query of qtype but for its
+// AllowedTypes. Such queries are answered with NODATA, never passed on.
+func (n *NetBird) restricted(queryName, qtype string) bool {
+	if n.storage == nil {
+		return false
+	}
+
+	queryType := dns.RecordType(qtype)
+	if entry, ok := n.zone().lookup(queryName); ok {
+		for _, customRecord := range entry.set {
+			if answersQuery(customRecord, queryType) && !customRecord.AllowsQuery(queryType) {
+				return true
 			}
-			return rec, true
 		}
+		return false
 	}
 
-	// Parse domain and name from query
-	// queryName is in format: "name.domain."
-	parts := strings.Split(queryNameTrimmed, ".")
-	if len(parts) < 2 {
-		return record{}, false
+	if queryType != dns.RecordTypeA && queryType != dns.RecordTypeCNAME {
+		return false
 	}
+	customRecord, _, ok := n.zone().defaultRecord(n.zones, queryName)
+	return ok && answersQuery(customRecord, queryType) && !customRecord.AllowsQuery(queryType)
+}
 
-	name := parts[0]
-	domain := strings.Join(parts[1:], ".")
+// answersQuery reports whether record answers queries of queryType at its
+// name: a CNAME answers every query type, other records their own type and ANY
+func answersQuery(record *dns.Record, queryType dns.RecordType) bool {
+	return record.Type == dns.RecordTypeCNAME || record.Type == queryType || queryType == dns.QueryTypeANY
+}
 
-	clog.Debugf("Looking up custom record: domain=%s, name=%s", domain, name)
-	customRecord, err := n.storage.GetRecord(domain, name)
-	if err != nil {
-		clog.Debugf("Custom record lookup failed: %v", err)
-		return record{}, false
+// hasName reports whether any custom record exists for queryName, regardless of its type
+func (n *NetBird) hasName(queryName string) bool {
+	if n.storage == nil {
+		return false
 	}
-	clog.Debugf("Found custom record: %+v", customRecord)
 
-	var rec record
+	// The zone apex always exists
+	if _, ok := n.apexDomain(queryName); ok {
+		return true
+	}
 
-	switch customRecord.Type {
-	case "A":
-		rec.IPv4 = net.ParseIP(customRecord.Value)
-	case "CNAME":
-		// For CNAME, we need to resolve the target
-		// This is handled differently in serve.go
+	_, ok := n.zone().lookup(queryName)
+	return ok
+}
+
+// lookupDefaultRecord returns the catch-all record of the nearest enclosing domain
+// of queryName if it answers queries of qtype. It applies to names below a
+// domain, never to the zone apex.
+func (n *NetBird) lookupDefaultRecord(queryName, qtype string, clientIP net.IP) (record, bool) {
+	if n.storage == nil {
 		return record{}, false
 	}
 
-	return rec, true
-}
+	customRecord, domain, ok := n.zone().defaultRecord(n.zones, queryName)
+	if !ok {
+		return record{}, false
+	}
+	if !customRecord.AllowsQuery(dns.RecordType(qtype)) {
+		clog.Debugf("Catch-all record of %s does not answer %s queries", domain, qtype)
+		return record{}, false
+	}
+	clog.Debugf("Using catch-all record of %s for %s", domain, queryName)
+	recordHitsCount.Inc(domain, api.DefaultRecordName)
 
-// Name returns the plugin name
-func (n *NetBird) Name() string {
-	return "netbird"
+	rec := record{TTL: n.recordTTL(customRecord)}
+	switch customRecord.Type {
+	case dns.RecordTypeA:
+		rec.IPv4 = parseIPv4s(customRecord.ValuesFor(clientIP))
+	case dns.RecordTypeCNAME:
+		rec.Target = dns.TrimDot(customRecord.ValueFor(clientIP)) + "."
+	}
+	return rec, true
 }
 
-// ResolveCNAME resolves a CNAME record from storage
-func (n *NetBird) ResolveCNAME(queryName string) (string, bool) {
-	if n.storage == nil {
-		return "", false
+// lookupTXT returns the strings of the TXT record for queryName if it answers
+// queries of qtype, with values longer than 255 bytes split into several
+// strings of one TXT record
+func (n *NetBird) lookupTXT(queryName, qtype string, clientIP net.IP) ([]string, uint32, bool) {
+	customRecord, domain, name, ok := n.findRecord(queryName, dns.RecordTypeTXT, qtype)
+	if !ok {
+		return nil, 0, false
 	}
 
-	// Check if this is a root domain query (query name exactly matches a configured domain)
-	queryNameTrimmed := strings.TrimSuffix(queryName, ".")
-	for _, domain := range n.Domains {
-		if queryNameTrimmed == domain {
-			// This is a root domain query
-			customRecord, err := n.storage.GetRecord(domain, "")
-			if err != nil {
-				return "", false
-			}
-
-			if customRecord.Type == "CNAME" {
-				// Ensure CNAME value ends with dot
-				target := customRecord.Value
-				if !strings.HasSuffix(target, ".") {
-					target += "."
-				}
-				return target, true
-			}
+	recordHitsCount.Inc(domain, name)
+	return dns.SplitTXT(customRecord.ValueFor(clientIP)), n.recordTTL(customRecord), true
+}
 
-			return "", false
-		}
+// findRecord returns the stored record of recordType for queryName along with its
+// domain and name, as mapped by api.SplitName. A record whose AllowedTypes
+// leave out qtype, the name of the query type, is not returned.
+func (n *NetBird) findRecord(queryName string, recordType dns.RecordType, qtype string) (*dns.Record, string, string, bool) {
+	entry, ok := n.zone().lookup(queryName)
+	if !ok {
+		clog.Debugf("No custom records for %s", queryName)
+		return nil, "", "", false
 	}
 
-	// Parse domain and name from query
-	parts := strings.Split(queryNameTrimmed, ".")
-	if len(parts) < 2 {
-		return "", false
+	customRecord := entry.set.Get(recordType)
+	if customRecord == nil {
+		clog.Debugf("No custom %s record for %s", recordType, queryName)
+		return nil, "", "", false
 	}
+	if !customRecord.AllowsQuery(dns.RecordType(qtype)) {
+		clog.Debugf("Custom %s record for %s does not answer %s queries", recordType, queryName, qtype)
+		return nil, "", "", false
+	}
+	return customRecord, entry.domain, entry.name, true
+}
 
-	name := parts[0]
-	domain := strings.Join(parts[1:], ".")
+// Name returns the plugin name
+func (n *NetBird) Name() string {
+	return "netbird"
+}
 
-	customRecord, err := n.storage.GetRecord(domain, name)
-	if err != nil {
-		return "", false
+// preferA reports whether an A query for queryName is answered from its A
+// record before any CNAME, which only happens with config.AQueryAFirst
+func (n *NetBird) preferA(queryName string) bool {
+	if n.AQueryOrder != config.AQueryAFirst {
+		return false
 	}
+	_, _, _, ok := n.findRecord(queryName, dns.RecordTypeA, string(dns.RecordTypeA))
+	return ok
+}
 
-	if customRecord.Type == "CNAME" {
-		// Ensure CNAME value ends with dot
-		target := customRecord.Value
-		if !strings.HasSuffix(target, ".") {
-			target += "."
-		}
-		return target, true
+// ResolveCNAME resolves a CNAME record from storage and returns its target and TTL
+// if the record answers queries of qtype.
+// clientIP selects the record's view value, if any; it may be nil.
+func (n *NetBird) ResolveCNAME(queryName, qtype string, clientIP net.IP) (string, uint32, bool) {
+	customRecord, domain, name, ok := n.findRecord(queryName, dns.RecordTypeCNAME, qtype)
+	if !ok {
+		return "", 0, false
 	}
+	recordHitsCount.Inc(domain, name)
 
-	return "", false
+	// Ensure CNAME value ends with dot
+	target := customRecord.ValueFor(clientIP)
+	if !strings.HasSuffix(target, ".") {
+		target += "."
+	}
+	return target, n.recordTTL(customRecord), true
 }
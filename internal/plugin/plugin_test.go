@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netbird-coredns/internal/api"
+	"netbird-coredns/pkg/dns"
+)
+
+// writeRecords writes records to a records file as they would be stored,
+// without validating them, so tests can also set up hand-edited files
+func writeRecords(tb testing.TB, records ...*dns.Record) string {
+	tb.Helper()
+	domains := make(map[string]map[string][]*dns.Record)
+	for _, record := range records {
+		if domains[record.Domain] == nil {
+			domains[record.Domain] = make(map[string][]*dns.Record)
+		}
+		domains[record.Domain][record.Name] = append(domains[record.Domain][record.Name], record)
+	}
+	data, err := json.Marshal(map[string]any{"version": api.RecordsFormatVersion, "records": domains})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	path := filepath.Join(tb.TempDir(), "records.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// newTestPlugin creates the plugin for example.com serving the records in
+// path. Settings are read from the environment, so set them first.
+func newTestPlugin(tb testing.TB, path string) *NetBird {
+	tb.Helper()
+	nb, err := New([]string{"example.com"}, Options{RecordsFile: path, RefreshInterval: time.Hour})
+	if err != nil {
+		tb.Fatalf("New() failed: %v", err)
+	}
+	return nb
+}
+
+func TestRecordTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		min, max  uint32
+		recordTTL uint32
+		want      uint32
+	}{
+		{"default TTL without bounds", 0, 0, 0, defaultTTL},
+		{"record TTL without bounds", 0, 0, 3600, 3600},
+		{"record TTL within bounds", 30, 600, 300, 300},
+		{"record TTL below minimum", 30, 600, 10, 30},
+		{"record TTL above maximum", 30, 600, 3600, 600},
+		{"default TTL below minimum", 120, 0, 0, 120},
+		{"default TTL above maximum", 0, 30, 0, 30},
+		{"only a minimum", 300, 0, 86400, 86400},
+		{"only a maximum", 0, 300, 1, 1},
+		{"minimum equal to maximum", 90, 90, 3600, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &NetBird{TTLMin: tt.min, TTLMax: tt.max}
+			if got := n.recordTTL(&dns.Record{TTL: tt.recordTTL}); got != tt.want {
+				t.Errorf("recordTTL() with bounds %d-%d and record TTL %d = %d, want %d", tt.min, tt.max, tt.recordTTL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTTLBounds(t *testing.T) {
+	tests := []struct {
+		min, max         string
+		wantMin, wantMax uint32
+	}{
+		{"", "", 0, 0},
+		{"30", "600", 30, 600},
+		{"30", "", 30, 0},
+		{"", "600", 0, 600},
+		// A minimum above the maximum is ignored so the maximum still caps TTLs
+		{"600", "30", 0, 30},
+		{"soon", "600", 0, 600},
+	}
+	for _, tt := range tests {
+		t.Setenv("NBDNS_TTL_MIN", tt.min)
+		t.Setenv("NBDNS_TTL_MAX", tt.max)
+		if gotMin, gotMax := getTTLBounds(); gotMin != tt.wantMin || gotMax != tt.wantMax {
+			t.Errorf("getTTLBounds() with NBDNS_TTL_MIN=%q NBDNS_TTL_MAX=%q = %d, %d, want %d, %d",
+				tt.min, tt.max, gotMin, gotMax, tt.wantMin, tt.wantMax)
+		}
+	}
+}
+
+func TestParseZoneNS(t *testing.T) {
+	servers, err := parseZoneNS(" NS1.example.com., ns2.example.org,,ns1.example.com ")
+	if err != nil {
+		t.Fatalf("parseZoneNS() failed: %v", err)
+	}
+	if len(servers) != 2 || servers[0] != "ns1.example.com." || servers[1] != "ns2.example.org." {
+		t.Errorf("parseZoneNS() = %v, want ns1.example.com. and ns2.example.org.", servers)
+	}
+
+	for _, value := range []string{"", " , ", "ns1.example.com,not a host", "-ns.example.com"} {
+		if _, err := parseZoneNS(value); err == nil {
+			t.Errorf("parseZoneNS(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// Reasons a response was truncated
+const (
+	truncatedRateLimit = "rate_limit"
+	truncatedSize      = "size"
+	truncatedForceTCP  = "force_tcp"
+)
+
+// responseRecorder sees the responses this plugin writes so they can be
+// counted. Queries passed on to the next plugin or the forward upstream are
+// not recorded.
+type responseRecorder struct {
+	dns.ResponseWriter
+	req *dns.Msg
+	// forceTCPOver truncates UDP responses larger than this many bytes; 0 disables it
+	forceTCPOver int
+	// hold keeps the response from being written, so ServeDNS can delay it
+	hold bool
+
+	msg       *dns.Msg
+	truncated string
+	passed    bool
+}
+
+// WriteMsg fits m to the client's buffer the way the CoreDNS server does on
+// write, so truncation can be observed, and records it. SizeAndDo echoes the
+// query's OPT record with its buffer size and DO bit. A held response is only
+// recorded; ServeDNS writes it later.
+func (rw *responseRecorder) WriteMsg(m *dns.Msg) error {
+	rateLimited := m.Truncated
+
+	state := request.Request{W: rw.ResponseWriter, Req: rw.req}
+	state.SizeAndDo(m)
+	state.Scrub(m)
+
+	// Middleboxes that drop fragmented UDP lose large answers even when they fit
+	// the client's buffer, so send them empty and truncated to move the client to TCP
+	forceTCP := rw.forceTCPOver > 0 && state.Proto() == "udp" && m.Len() > rw.forceTCPOver
+	if forceTCP {
+		m.Truncated = true
+		m.Answer, m.Ns = nil, nil
+		if opt := m.IsEdns0(); opt != nil {
+			m.Extra = []dns.RR{opt}
+		} else {
+			m.Extra = nil
+		}
+	}
+
+	rw.msg = m
+	switch {
+	case rateLimited:
+		rw.truncated = truncatedRateLimit
+	case forceTCP:
+		rw.truncated = truncatedForceTCP
+	case m.Truncated:
+		rw.truncated = truncatedSize
+	}
+
+	if rw.hold {
+		return nil
+	}
+	return rw.ResponseWriter.WriteMsg(m)
+}
+
+// passOn returns the writer under w's recorder, if any, marking the query as
+// passed on so the response written to it is not counted as this plugin's own
+func passOn(w dns.ResponseWriter) dns.ResponseWriter {
+	if rw, ok := w.(*responseRecorder); ok {
+		rw.passed = true
+		return rw.ResponseWriter
+	}
+	return w
+}
+
+// observeResponse counts the response to r by rcode and, when it was
+// truncated, by reason. rcode is what ServeDNS returned; CoreDNS writes the
+// response itself for rcodes the plugin did not write.
+func observeResponse(rw *responseRecorder, r *dns.Msg, rcode int) {
+	if rw.passed || len(r.Question) == 0 {
+		return
+	}
+	qtype := qtypeLabel(r.Question[0].Qtype)
+
+	if rw.msg != nil {
+		rcode = rw.msg.Rcode
+	}
+	rcodeName := dns.RcodeToString[rcode]
+	responsesCount.WithLabelValues(rcodeName, qtype).Inc()
+	if rcode != dns.RcodeSuccess {
+		clog.Debugf("Answered %s %s with %s", qtype, r.Question[0].Name, rcodeName)
+	}
+
+	if rw.truncated != "" {
+		truncatedCount.WithLabelValues(rw.truncated, qtype).Inc()
+		clog.Debugf("Truncated %s response for %s (%s)", qtype, r.Question[0].Name, rw.truncated)
+	}
+}
+
+// qtypeLabel returns the metric label for a query type, folding types without
+// a name into one value to keep the label bounded
+func qtypeLabel(qtype uint16) string {
+	if name, ok := dns.TypeToString[qtype]; ok {
+		return name
+	}
+	return "other"
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// rrlSweepInterval is how often idle client buckets are dropped
+const rrlSweepInterval = time.Minute
+
+// rateLimiter is a per-client-prefix token bucket for response rate limiting
+type rateLimiter struct {
+	rate      float64
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a rate limiter allowing rate responses per second per client prefix,
+// with bursts of up to one second's worth of responses
+func newRateLimiter(rate int) *rateLimiter {
+	return &rateLimiter{
+		rate:      float64(rate),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether a response to ip may be sent now
+func (l *rateLimiter) allow(ip net.IP, now time.Time) bool {
+	key := clientPrefix(ip)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rrlSweepInterval {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > rrlSweepInterval {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.rate, last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill for the time elapsed since the last response, capped at the burst size
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.rate {
+		b.tokens = l.rate
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clientPrefix groups clients by /24 (IPv4) or /56 (IPv6) so a spoofed range shares one bucket
+func clientPrefix(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(56, 128)).String()
+}
@@ -2,46 +2,183 @@ package plugin
 
 import (
 	"context"
+	"math/rand"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
+
+	"netbird-coredns/internal/api"
 )
 
 // ServeDNS handles DNS requests for the NetBird domains
 func (n *NetBird) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	rw := &responseRecorder{ResponseWriter: w, req: r, forceTCPOver: n.ForceTCPOverBytes, hold: n.ResponseDelay > 0}
+	rcode, err := n.serveDNS(ctx, rw, r)
+
+	// Delay replies only after serveDNS has given back its concurrency slot, so
+	// slow responses do not shed other queries. Replies CoreDNS writes from the
+	// returned rcode are held back here as well.
+	if rw.hold && !rw.passed {
+		time.Sleep(n.ResponseDelay)
+		delayedCount.Inc()
+		if rw.msg != nil {
+			if err = rw.ResponseWriter.WriteMsg(rw.msg); err != nil {
+				rcode = dns.RcodeServerFailure
+			}
+		}
+	}
+	observeResponse(rw, r, rcode)
+	return rcode, err
+}
+
+// serveDNS answers a query from the stored records or passes it on
+func (n *NetBird) serveDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	state := request.Request{W: w, Req: r}
 	queryName := state.Name()
+	clientIP := net.ParseIP(state.IP())
+	// qtype names the query type, as records list it in their AllowedTypes
+	qtype := dns.TypeToString[state.QType()]
 
-	// Check if query is for any of our NetBird domains
-	matchesDomain := false
-	for _, domain := range n.Domains {
-		if strings.HasSuffix(queryName, domain+".") {
-			matchesDomain = true
-			clog.Debugf("Query %s matches configured domain %s", queryName, domain)
-			break
+	// Answer version.bind and hostname.bind ourselves so the real CoreDNS version is not disclosed
+	if n.ChaosVersion != "" && state.QClass() == dns.ClassCHAOS && state.QType() == dns.TypeTXT {
+		if txt, ok := n.chaosAnswer(queryName); ok {
+			m := n.newReply(r)
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: queryName, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0},
+				Txt: []string{txt},
+			})
+
+			if err := w.WriteMsg(m); err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			return dns.RcodeSuccess, nil
 		}
 	}
 
-	if !matchesDomain {
+	// Check if query is for any of our NetBird domains
+	domain, matchesDomain := n.zones.outermost(strings.TrimSuffix(queryName, "."))
+	if matchesDomain {
+		clog.Debugf("Query %s matches configured domain %s", queryName, domain)
+	}
+
+	// Reverse queries for addresses of in-zone A records are answered like in-zone queries
+	var ptrAnswers []dns.RR
+	if n.AutoPTR && state.QType() == dns.TypePTR {
+		ptrAnswers = n.reverseAnswers(queryName, state.QClass())
+	}
+
+	if !matchesDomain && len(ptrAnswers) == 0 {
 		clog.Debugf("Query %s does not match any configured domains: %v", queryName, n.Domains)
-		return plugin.NextOrFailure(n.Name(), n.Next, ctx, w, r)
+		return n.next(ctx, w, r)
+	}
+
+	// Shed load beyond the concurrency limit right away instead of queueing, so a
+	// query storm cannot pile up goroutines; clients retry or use another server
+	if n.inflight != nil {
+		select {
+		case n.inflight <- struct{}{}:
+			defer func() { <-n.inflight }()
+		default:
+			clog.Debugf("Concurrent query limit reached, answering %s with SERVFAIL", queryName)
+			shedCount.Inc()
+			return dns.RcodeServerFailure, nil
+		}
+	}
+
+	// Refuse clients the query ACL denies before revealing anything about the zone
+	if n.acl != nil && !n.acl.allowed(clientIP) {
+		clog.Debugf("Query ACL denies %s, refusing %s", state.IP(), queryName)
+		aclRefusedCount.Inc()
+		return dns.RcodeRefused, nil
 	}
 
-	// Check custom records (CNAME)
-	if state.QType() == dns.TypeCNAME || state.QType() == dns.TypeA {
-		if target, ok := n.ResolveCNAME(queryName); ok {
-			m := new(dns.Msg)
-			m.SetReply(r)
-			m.Authoritative = true
+	// Truncate UDP answers to clients over the rate limit so they cannot be used for
+	// amplification; genuine clients retry over TCP, which is exempt
+	if n.rrl != nil && state.Proto() == "udp" && !n.rrl.allow(clientIP, time.Now()) {
+		clog.Debugf("Rate limit exceeded for %s, truncating response to %s", state.IP(), queryName)
+		rrlTruncatedCount.Inc()
+		m := n.newReply(r)
+		m.Truncated = true
+
+		if err := w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return dns.RcodeSuccess, nil
+	}
+
+	// Tell clients to retry elsewhere while records are being reloaded in bulk
+	if n.maintenance != nil {
+		if mstate, active := n.maintenance.Active(); active {
+			clog.Debugf("Maintenance mode active, answering %s with %s", queryName, mstate.Rcode)
+			if mstate.Rcode == "REFUSED" {
+				return dns.RcodeRefused, nil
+			}
+			return dns.RcodeServerFailure, nil
+		}
+	}
+
+	// Let clients fail over to another server rather than hand out answers peers may not reach
+	if n.connection != nil && !n.connection.Connected() {
+		clog.Debugf("NetBird is disconnected, answering %s with SERVFAIL", queryName)
+		return dns.RcodeServerFailure, nil
+	}
+
+	if len(ptrAnswers) > 0 {
+		m := n.newReply(r)
+		m.Answer = ptrAnswers
+
+		if err := w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return dns.RcodeSuccess, nil
+	}
+
+	// The zone apex answers NS queries with the configured nameservers
+	if state.QType() == dns.TypeNS {
+		if answers := n.nsAnswers(queryName, state.QClass()); len(answers) > 0 {
+			m := n.newReply(r)
+			m.Answer = answers
+			for _, ns := range n.ZoneNS {
+				m.Extra = append(m.Extra, n.glueFor(ns, qtype, state.QClass(), clientIP)...)
+			}
+
+			if err := w.WriteMsg(m); err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			return dns.RcodeSuccess, nil
+		}
+	}
+
+	// ANY queries get every record stored under the name
+	if state.QType() == dns.TypeANY {
+		if answers := n.anyAnswers(queryName, state.QClass(), clientIP); len(answers) > 0 {
+			m := n.newReply(r)
+			m.Answer = answers
+
+			if err := w.WriteMsg(m); err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			return dns.RcodeSuccess, nil
+		}
+	}
+
+	// A name holding a CNAME answers every query type with it (RFC 1034 3.6.2).
+	// A queries check the CNAME first unless NBDNS_A_QUERY_ORDER=a-first and the
+	// name also holds an A record.
+	if !(state.QType() == dns.TypeA && n.preferA(queryName)) {
+		if target, ttl, ok := n.ResolveCNAME(queryName, qtype, clientIP); ok {
+			m := n.newReply(r)
 
 			header := dns.RR_Header{
 				Name:   queryName,
 				Rrtype: dns.TypeCNAME,
 				Class:  state.QClass(),
-				Ttl:    60,
+				Ttl:    ttl,
 			}
 
 			m.Answer = append(m.Answer, &dns.CNAME{
@@ -49,6 +186,25 @@ func (n *NetBird) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 				Target: target,
 			})
 
+			// Save the client a round-trip when the target lives in one of our zones
+			n.addTargetRecords(m, state.QType(), target, state.QClass(), clientIP)
+
+			if err := w.WriteMsg(m); err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			return dns.RcodeSuccess, nil
+		}
+	}
+
+	// Check custom TXT records
+	if state.QType() == dns.TypeTXT {
+		if txt, ttl, ok := n.lookupTXT(queryName, qtype, clientIP); ok {
+			m := n.newReply(r)
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: queryName, Rrtype: dns.TypeTXT, Class: state.QClass(), Ttl: ttl},
+				Txt: txt,
+			})
+
 			if err := w.WriteMsg(m); err != nil {
 				return dns.RcodeServerFailure, err
 			}
@@ -57,19 +213,19 @@ func (n *NetBird) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 	}
 
 	// Check custom A records
-	customRec, ok := n.lookupCustomRecord(queryName)
+	customRec, ok := n.lookupCustomRecord(queryName, qtype, clientIP)
 	if ok {
 		clog.Debugf("Found custom record for %s: %v", queryName, customRec)
-		m := new(dns.Msg)
-		m.SetReply(r)
-		m.Authoritative = true
+		m := n.newReply(r)
 
-		header := dns.RR_Header{Name: queryName, Rrtype: state.QType(), Class: state.QClass(), Ttl: 60}
+		header := dns.RR_Header{Name: queryName, Rrtype: state.QType(), Class: state.QClass(), Ttl: customRec.TTL}
 
 		switch state.QType() {
 		case dns.TypeA:
-			if customRec.IPv4 != nil {
-				m.Answer = append(m.Answer, &dns.A{Hdr: header, A: customRec.IPv4})
+			if len(customRec.IPv4) > 0 {
+				for _, ip := range n.orderAnswers(customRec.IPv4) {
+					m.Answer = append(m.Answer, &dns.A{Hdr: header, A: ip})
+				}
 				if err := w.WriteMsg(m); err != nil {
 					return dns.RcodeServerFailure, err
 				}
@@ -78,6 +234,335 @@ func (n *NetBird) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg
 		}
 	}
 
-	// No custom records found, pass to next plugin
-	return plugin.NextOrFailure(n.Name(), n.Next, ctx, w, r)
+	// Names without any record of their own fall back to the domain's catch-all record
+	if (state.QType() == dns.TypeA || state.QType() == dns.TypeCNAME) && !n.hasName(queryName) {
+		if def, ok := n.lookupDefaultRecord(queryName, qtype, clientIP); ok {
+			m := n.newReply(r)
+
+			switch {
+			case def.Target != "":
+				m.Answer = append(m.Answer, &dns.CNAME{
+					Hdr:    dns.RR_Header{Name: queryName, Rrtype: dns.TypeCNAME, Class: state.QClass(), Ttl: def.TTL},
+					Target: def.Target,
+				})
+				n.addTargetRecords(m, state.QType(), def.Target, state.QClass(), clientIP)
+			case state.QType() == dns.TypeA:
+				for _, ip := range n.orderAnswers(def.IPv4) {
+					m.Answer = append(m.Answer, &dns.A{
+						Hdr: dns.RR_Header{Name: queryName, Rrtype: dns.TypeA, Class: state.QClass(), Ttl: def.TTL},
+						A:   ip,
+					})
+				}
+			}
+
+			if err := w.WriteMsg(m); err != nil {
+				return dns.RcodeServerFailure, err
+			}
+			return dns.RcodeSuccess, nil
+		}
+	}
+
+	// The zone apex is answered authoritatively once an apex record or NBDNS_ZONE_NS
+	// is configured, so other query types get a clean NODATA instead of being forwarded
+	if domain, ok := n.apexDomain(queryName); ok && (len(n.ZoneNS) > 0 || n.hasApexRecord(domain)) {
+		m := n.newReply(r)
+		// The apex answers SOA queries with the SOA served in negative answers
+		if soa, ok := n.soaRecord(queryName, state.QClass()); ok && state.QType() == dns.TypeSOA {
+			m.Answer = append(m.Answer, soa)
+		} else {
+			clog.Debugf("Returning NODATA for %s %s at zone apex", dns.TypeToString[state.QType()], queryName)
+			n.addNegativeSOA(m, queryName, state.QClass())
+		}
+
+		if err := w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return dns.RcodeSuccess, nil
+	}
+
+	// No custom records found, pass on when falling through. A record withheld
+	// from this query type by its AllowedTypes gets NODATA instead.
+	restricted := n.restricted(queryName, qtype)
+	if n.Fall.Through(queryName) && !restricted {
+		return n.next(ctx, w, r)
+	}
+
+	// Otherwise answer authoritatively: NODATA when the name holds another type, NXDOMAIN when it does not exist.
+	// The zone's SOA lets resolvers cache the answer for the negative TTL.
+	m := n.newReply(r)
+	if !n.hasName(queryName) && !restricted {
+		m.Rcode = dns.RcodeNameError
+	}
+	n.addNegativeSOA(m, queryName, state.QClass())
+	clog.Debugf("No custom record for %s %s, answering %s", dns.TypeToString[state.QType()], queryName, dns.RcodeToString[m.Rcode])
+
+	if err := w.WriteMsg(m); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// next passes a query the plugin does not answer on: to the Forward upstream
+// when one is configured, otherwise to the next plugin. A forwarded query that
+// fails or times out is answered with SERVFAIL and counted as this plugin's own
+// response; other responses are written to the client directly.
+func (n *NetBird) next(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if n.Forward == "" {
+		return plugin.NextOrFailure(n.Name(), n.Next, ctx, passOn(w), r)
+	}
+
+	state := request.Request{W: w, Req: r}
+	client := &dns.Client{Net: state.Proto(), Timeout: n.UpstreamTimeout}
+	resp, _, err := client.ExchangeContext(ctx, r, n.Forward)
+	if err != nil {
+		clog.Warningf("Failed to forward %s to %s: %v", state.Name(), n.Forward, err)
+		return dns.RcodeServerFailure, nil
+	}
+
+	if err := passOn(w).WriteMsg(resp); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// anyAnswers returns the records of every type stored for queryName. A CNAME
+// never shares its name with other records, so it is returned on its own.
+func (n *NetBird) anyAnswers(queryName string, qclass uint16, clientIP net.IP) []dns.RR {
+	qtype := dns.TypeToString[dns.TypeANY]
+	if target, ttl, ok := n.ResolveCNAME(queryName, qtype, clientIP); ok {
+		return []dns.RR{&dns.CNAME{
+			Hdr:    dns.RR_Header{Name: queryName, Rrtype: dns.TypeCNAME, Class: qclass, Ttl: ttl},
+			Target: target,
+		}}
+	}
+
+	answers := n.nsAnswers(queryName, qclass)
+	if rec, ok := n.lookupCustomRecord(queryName, qtype, clientIP); ok {
+		for _, ip := range n.orderAnswers(rec.IPv4) {
+			answers = append(answers, &dns.A{
+				Hdr: dns.RR_Header{Name: queryName, Rrtype: dns.TypeA, Class: qclass, Ttl: rec.TTL},
+				A:   ip,
+			})
+		}
+	}
+	if txt, ttl, ok := n.lookupTXT(queryName, qtype, clientIP); ok {
+		answers = append(answers, &dns.TXT{
+			Hdr: dns.RR_Header{Name: queryName, Rrtype: dns.TypeTXT, Class: qclass, Ttl: ttl},
+			Txt: txt,
+		})
+	}
+	return answers
+}
+
+// nsAnswers returns an NS answer for every configured nameserver when
+// queryName is the apex of one of the configured domains
+func (n *NetBird) nsAnswers(queryName string, qclass uint16) []dns.RR {
+	if len(n.ZoneNS) == 0 {
+		return nil
+	}
+	if _, ok := n.apexDomain(queryName); !ok {
+		return nil
+	}
+
+	ttl := n.nsTTL()
+	answers := make([]dns.RR, 0, len(n.ZoneNS))
+	for _, ns := range n.ZoneNS {
+		answers = append(answers, &dns.NS{
+			Hdr: dns.RR_Header{Name: queryName, Rrtype: dns.TypeNS, Class: qclass, Ttl: ttl},
+			Ns:  ns,
+		})
+	}
+	return answers
+}
+
+// glueFor returns the A records of an in-zone nameserver for the additional
+// section, so resolvers need not look up the nameserver separately. Like
+// every additional record, they are left out unless they answer qtype.
+func (n *NetBird) glueFor(ns, qtype string, qclass uint16, clientIP net.IP) []dns.RR {
+	if !n.isInZone(ns) {
+		return nil
+	}
+	rec, ok := n.lookupCustomRecord(ns, qtype, clientIP)
+	if !ok {
+		return nil
+	}
+
+	glue := make([]dns.RR, 0, len(rec.IPv4))
+	for _, ip := range rec.IPv4 {
+		glue = append(glue, &dns.A{
+			Hdr: dns.RR_Header{Name: ns, Rrtype: dns.TypeA, Class: qclass, Ttl: rec.TTL},
+			A:   ip,
+		})
+	}
+	return glue
+}
+
+// reverseAnswers returns a PTR answer for every in-zone A record answering with
+// the address of an in-addr.arpa query name
+func (n *NetBird) reverseAnswers(queryName string, qclass uint16) []dns.RR {
+	if n.storage == nil {
+		return nil
+	}
+	ip := reverseIP(queryName)
+	if ip == nil {
+		return nil
+	}
+
+	var answers []dns.RR
+	for _, record := range n.storage.ReverseLookup(ip) {
+		// Only names in the configured zones are served, even if the records file holds others
+		if !n.isInZone(record.Domain) {
+			continue
+		}
+		// PTR is never among a record's AllowedTypes, so restricted records stay out of reverse answers
+		if !record.AllowsQuery("PTR") {
+			continue
+		}
+		answers = append(answers, &dns.PTR{
+			Hdr: dns.RR_Header{Name: queryName, Rrtype: dns.TypePTR, Class: qclass, Ttl: n.recordTTL(record)},
+			Ptr: record.FQDN(),
+		})
+	}
+	return answers
+}
+
+// reverseIP returns the IPv4 address named by a full in-addr.arpa name, or nil
+func reverseIP(name string) net.IP {
+	const suffix = ".in-addr.arpa."
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, suffix) {
+		return nil
+	}
+
+	labels := strings.Split(strings.TrimSuffix(name, suffix), ".")
+	if len(labels) != net.IPv4len {
+		return nil
+	}
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return net.ParseIP(strings.Join(labels, ".")).To4()
+}
+
+// newReply creates an authoritative reply to r. The zones are not signed, so
+// the reply never claims validated data, even when the query sets AD or DO;
+// the OPT record, including the DO bit, is echoed when the reply is written.
+func (n *NetBird) newReply(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	m.AuthenticatedData = false
+	m.Compress = n.Compress
+	return m
+}
+
+// chaosAnswer returns the TXT value for a CHAOS-class identity query
+func (n *NetBird) chaosAnswer(name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "version.bind.", "version.server.":
+		return n.ChaosVersion, true
+	case "hostname.bind.", "id.server.":
+		return n.ChaosHostname, n.ChaosHostname != ""
+	}
+	return "", false
+}
+
+// orderAnswers returns the addresses of a multi-value record in the configured answer order
+func (n *NetBird) orderAnswers(ips []net.IP) []net.IP {
+	if len(ips) < 2 {
+		return ips
+	}
+
+	ordered := make([]net.IP, len(ips))
+	switch n.AnswerOrder {
+	case answerOrderShuffle:
+		copy(ordered, ips)
+		rand.Shuffle(len(ordered), func(i, j int) {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		})
+	case answerOrderRoundRobin:
+		start := int(n.rrCounter.Add(1) % uint64(len(ips)))
+		copy(ordered, ips[start:])
+		copy(ordered[len(ips)-start:], ips[:start])
+	default:
+		copy(ordered, ips)
+	}
+
+	return ordered
+}
+
+// addTargetRecords adds the in-zone records of a CNAME target to a reply. For A
+// queries they complete the answer, following the CNAME in the answer section as
+// RFC 1034 requires; for other query types they are added to the additional section.
+// Records whose AllowedTypes leave out the query type are not added.
+func (n *NetBird) addTargetRecords(m *dns.Msg, qtype uint16, target string, qclass uint16, clientIP net.IP) {
+	records := n.targetRecords(target, dns.TypeToString[qtype], qclass, clientIP)
+	if qtype == dns.TypeA {
+		m.Answer = append(m.Answer, records...)
+		return
+	}
+	m.Extra = append(m.Extra, records...)
+}
+
+// targetRecords returns the in-zone CNAME chain and A records for target.
+// In-zone CNAME chains are followed up to api.MaxCNAMEDepth, and a name already visited ends the
+// chain so that CNAME loops cannot recurse forever.
+func (n *NetBird) targetRecords(target, qtype string, qclass uint16, clientIP net.IP) []dns.RR {
+	var extra []dns.RR
+	visited := make(map[string]bool)
+
+	for depth := 0; depth < api.MaxCNAMEDepth; depth++ {
+		target = strings.ToLower(target)
+		if visited[target] || !n.isInZone(target) {
+			break
+		}
+		visited[target] = true
+
+		if !n.preferA(target) {
+			if next, ttl, ok := n.ResolveCNAME(target, qtype, clientIP); ok {
+				extra = append(extra, &dns.CNAME{
+					Hdr:    dns.RR_Header{Name: target, Rrtype: dns.TypeCNAME, Class: qclass, Ttl: ttl},
+					Target: next,
+				})
+				target = next
+				continue
+			}
+		}
+
+		if rec, ok := n.lookupCustomRecord(target, qtype, clientIP); ok {
+			for _, ip := range n.orderAnswers(rec.IPv4) {
+				extra = append(extra, &dns.A{
+					Hdr: dns.RR_Header{Name: target, Rrtype: dns.TypeA, Class: qclass, Ttl: rec.TTL},
+					A:   ip,
+				})
+			}
+		}
+		break
+	}
+
+	if len(extra) > 0 {
+		clog.Debugf("Found %d in-zone record(s) for CNAME target %s", len(extra), target)
+	}
+
+	return extra
+}
+
+// isInZone reports whether name falls under one of the configured domains
+func (n *NetBird) isInZone(name string) bool {
+	return n.zones.contains(name)
+}
+
+// apexDomain returns the configured domain when name is exactly its zone apex
+func (n *NetBird) apexDomain(name string) (string, bool) {
+	domain, ok := strings.CutSuffix(name, ".")
+	if !ok || !n.zones[domain] {
+		return "", false
+	}
+	return domain, true
+}
+
+// hasApexRecord reports whether a root domain record is configured for domain
+func (n *NetBird) hasApexRecord(domain string) bool {
+	_, ok := n.zone().lookup(domain)
+	return ok
 }
@@ -0,0 +1,539 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	ctest "github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+
+	pkgdns "netbird-coredns/pkg/dns"
+)
+
+// exchange sends a query for name to the plugin through w and returns the
+// response it wrote, nil if it wrote none, and the rcode it returned
+func exchange(tb testing.TB, n *NetBird, w *ctest.ResponseWriter, name string, qtype uint16) (*dns.Msg, int) {
+	tb.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	return exchangeMsg(tb, n, w, r)
+}
+
+// exchangeMsg sends r to the plugin through w, like exchange
+func exchangeMsg(tb testing.TB, n *NetBird, w *ctest.ResponseWriter, r *dns.Msg) (*dns.Msg, int) {
+	tb.Helper()
+	rec := dnstest.NewRecorder(w)
+	rcode, err := n.ServeDNS(context.Background(), rec, r)
+	if err != nil {
+		tb.Fatalf("ServeDNS(%s) failed: %v", r.Question[0].String(), err)
+	}
+	return rec.Msg, rcode
+}
+
+func BenchmarkLookup(b *testing.B) {
+	records := make([]*pkgdns.Record, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		records = append(records, &pkgdns.Record{
+			Name:   fmt.Sprintf("peer%d", i),
+			Domain: "example.com",
+			Type:   pkgdns.RecordTypeA,
+			Value:  fmt.Sprintf("100.64.%d.%d", i/256, i%256),
+		})
+	}
+	n := newTestPlugin(b, writeRecords(b, records...))
+	w := &ctest.ResponseWriter{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if m, _ := exchange(b, n, w, "peer500.example.com", dns.TypeA); m == nil || len(m.Answer) != 1 {
+			b.Fatalf("got %v, want one A record", m)
+		}
+	}
+}
+
+func TestLongTXTRecord(t *testing.T) {
+	// A 2048-bit DKIM key is longer than one 255-byte TXT string; it is stored
+	// under its first label, as the README describes
+	dkim := "v=DKIM1; k=rsa; p=" + strings.Repeat("MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8A", 12)
+	dkim = dkim[:400]
+	n := newTestPlugin(t, writeRecords(t, &pkgdns.Record{Name: "mail", Domain: "_domainkey.example.com", Type: pkgdns.RecordTypeTXT, Value: dkim}))
+
+	m, _ := exchange(t, n, &ctest.ResponseWriter{}, "mail._domainkey.example.com", dns.TypeTXT)
+	if m == nil || len(m.Answer) != 1 {
+		t.Fatalf("got %v, want one TXT record", m)
+	}
+	txt, ok := m.Answer[0].(*dns.TXT)
+	if !ok {
+		t.Fatalf("answer is %T, want a TXT record", m.Answer[0])
+	}
+	if len(txt.Txt) != 2 || len(txt.Txt[0]) != 255 || len(txt.Txt[1]) != 145 {
+		t.Errorf("TXT strings of %d bytes, want 255 and 145", len(strings.Join(txt.Txt, "")))
+	}
+	if strings.Join(txt.Txt, "") != dkim {
+		t.Error("TXT strings do not join back to the stored value")
+	}
+
+	// The record survives the wire format
+	if _, err := m.Pack(); err != nil {
+		t.Errorf("Pack() failed: %v", err)
+	}
+}
+
+func TestServedTTLIsClamped(t *testing.T) {
+	t.Setenv("NBDNS_TTL_MIN", "30")
+	t.Setenv("NBDNS_TTL_MAX", "600")
+	n := newTestPlugin(t, writeRecords(t,
+		&pkgdns.Record{Name: "long", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10", TTL: 86400},
+		&pkgdns.Record{Name: "short", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.11", TTL: 5},
+		&pkgdns.Record{Name: "default", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.12"},
+	))
+
+	for name, want := range map[string]uint32{"long": 600, "short": 30, "default": defaultTTL} {
+		m, _ := exchange(t, n, &ctest.ResponseWriter{}, name+".example.com", dns.TypeA)
+		if m == nil || len(m.Answer) != 1 {
+			t.Fatalf("%s: got %v, want one A record", name, m)
+		}
+		if ttl := m.Answer[0].Header().Ttl; ttl != want {
+			t.Errorf("%s: served TTL %d, want %d", name, ttl, want)
+		}
+	}
+}
+
+func TestForwardServfailsOnSilentUpstream(t *testing.T) {
+	// A UDP listener that reads queries but never answers them
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	timeout := 200 * time.Millisecond
+	t.Setenv("NBDNS_UPSTREAM_TIMEOUT", timeout.String())
+	n := newTestPlugin(t, writeRecords(t))
+	n.Forward = conn.LocalAddr().String()
+
+	start := time.Now()
+	m, rcode := exchange(t, n, &ctest.ResponseWriter{}, "www.example.org", dns.TypeA)
+	elapsed := time.Since(start)
+
+	if m != nil || rcode != dns.RcodeServerFailure {
+		t.Errorf("got %v and rcode %s, want SERVFAIL", m, dns.RcodeToString[rcode])
+	}
+	if elapsed > timeout+time.Second {
+		t.Errorf("forwarding took %v, want at most %v", elapsed, timeout)
+	}
+}
+
+func TestForwardRelaysUpstreamAnswer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		w.WriteMsg(m)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	n := newTestPlugin(t, writeRecords(t))
+	n.Forward = conn.LocalAddr().String()
+
+	n.Fall = fall.Root
+
+	// Out-of-zone queries and in-zone misses that fall through are both forwarded
+	for _, name := range []string{"www.example.org", "missing.example.com"} {
+		m, _ := exchange(t, n, &ctest.ResponseWriter{}, name, dns.TypeA)
+		if m == nil || len(m.Answer) != 1 {
+			t.Fatalf("%s: got %v, want the upstream's A record", name, m)
+		}
+		if a, ok := m.Answer[0].(*dns.A); !ok || !a.A.Equal(net.ParseIP("192.0.2.1")) {
+			t.Errorf("%s: answer %v, want 192.0.2.1", name, m.Answer[0])
+		}
+	}
+}
+
+func TestQueryACLRefusesDeniedClients(t *testing.T) {
+	t.Setenv("NBDNS_QUERY_ACL", "100.64.0.0/10=allow,100.64.9.0/24=deny,0.0.0.0/0=deny")
+	n := newTestPlugin(t, writeRecords(t, &pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"}))
+	n.Next = ctest.NextHandler(dns.RcodeSuccess, nil)
+
+	tests := []struct {
+		ip    string
+		query string
+		rcode int
+	}{
+		{"100.64.0.1", "web.example.com", dns.RcodeSuccess},
+		{"100.100.3.4", "web.example.com", dns.RcodeSuccess},
+		{"100.64.9.20", "web.example.com", dns.RcodeRefused},
+		{"192.168.1.10", "web.example.com", dns.RcodeRefused},
+		// Denied clients get REFUSED rather than an NXDOMAIN that reveals what the zone holds
+		{"192.168.1.10", "missing.example.com", dns.RcodeRefused},
+		// Queries for other zones are passed on whatever the ACL says
+		{"192.168.1.10", "example.org", dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		m, rcode := exchange(t, n, &ctest.ResponseWriter{RemoteIP: tt.ip}, tt.query, dns.TypeA)
+		if rcode != tt.rcode {
+			t.Errorf("%s from %s: rcode %s, want %s", tt.query, tt.ip, dns.RcodeToString[rcode], dns.RcodeToString[tt.rcode])
+		}
+		if tt.rcode == dns.RcodeRefused && m != nil {
+			t.Errorf("%s from %s: answer %v written to a denied client", tt.query, tt.ip, m)
+		}
+	}
+}
+
+func TestAQueries(t *testing.T) {
+	path := writeRecords(t,
+		&pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"},
+		&pkgdns.Record{Name: "www", Domain: "example.com", Type: pkgdns.RecordTypeCNAME, Value: "web.example.com"},
+		&pkgdns.Record{Name: "docs", Domain: "example.com", Type: pkgdns.RecordTypeCNAME, Value: "docs.example.org"},
+		&pkgdns.Record{Name: "mail", Domain: "example.com", Type: pkgdns.RecordTypeTXT, Value: "v=spf1 -all"},
+	)
+
+	tests := []struct {
+		name   string
+		query  string
+		fall   bool
+		rcode  int
+		answer []string
+		passed bool
+	}{
+		{"CNAME chased in zone", "www.example.com", false, dns.RcodeSuccess, []string{"CNAME web.example.com.", "A 100.64.0.10"}, false},
+		{"CNAME out of zone", "docs.example.com", false, dns.RcodeSuccess, []string{"CNAME docs.example.org."}, false},
+		{"A record", "web.example.com", false, dns.RcodeSuccess, []string{"A 100.64.0.10"}, false},
+		{"other type only", "mail.example.com", false, dns.RcodeSuccess, nil, false},
+		{"no records", "missing.example.com", false, dns.RcodeNameError, nil, false},
+		{"no records with fallthrough", "missing.example.com", true, dns.RcodeSuccess, nil, true},
+		{"CNAME with fallthrough", "www.example.com", true, dns.RcodeSuccess, []string{"CNAME web.example.com.", "A 100.64.0.10"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestPlugin(t, path)
+			if tt.fall {
+				n.Fall = fall.Root
+			}
+			next := false
+			n.Next = ctest.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				next = true
+				return dns.RcodeSuccess, nil
+			})
+
+			m, _ := exchange(t, n, &ctest.ResponseWriter{}, tt.query, dns.TypeA)
+			if next != tt.passed {
+				t.Fatalf("passed on = %v, want %v", next, tt.passed)
+			}
+			if tt.passed {
+				return
+			}
+			if m == nil {
+				t.Fatal("no answer written")
+			}
+			if m.Rcode != tt.rcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[m.Rcode], dns.RcodeToString[tt.rcode])
+			}
+			if got := answerValues(m); fmt.Sprint(got) != fmt.Sprint(tt.answer) {
+				t.Errorf("answer = %v, want %v", got, tt.answer)
+			}
+		})
+	}
+}
+
+// answerValues returns the type and value of each answer record, in order
+func answerValues(m *dns.Msg) []string {
+	var values []string
+	for _, rr := range m.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			values = append(values, "A "+rr.A.String())
+		case *dns.CNAME:
+			values = append(values, "CNAME "+rr.Target)
+		default:
+			values = append(values, rr.String())
+		}
+	}
+	return values
+}
+
+func TestAQueryOrder(t *testing.T) {
+	// A hand-edited file can give a name both a CNAME and an A record
+	path := writeRecords(t,
+		&pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"},
+		&pkgdns.Record{Name: "both", Domain: "example.com", Type: pkgdns.RecordTypeCNAME, Value: "web.example.com"},
+		&pkgdns.Record{Name: "both", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.50"},
+	)
+	cnameFirst := []string{"CNAME web.example.com.", "A 100.64.0.10"}
+
+	tests := []struct {
+		order  string
+		qtype  uint16
+		answer []string
+	}{
+		{"", dns.TypeA, cnameFirst},
+		{"invalid", dns.TypeA, cnameFirst},
+		{"cname-first", dns.TypeA, cnameFirst},
+		{"a-first", dns.TypeA, []string{"A 100.64.0.50"}},
+		{"A-FIRST", dns.TypeA, []string{"A 100.64.0.50"}},
+		// The order only applies to A queries; other types get the CNAME
+		{"a-first", dns.TypeCNAME, []string{"CNAME web.example.com."}},
+		{"a-first", dns.TypeTXT, []string{"CNAME web.example.com."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order+"/"+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			t.Setenv("NBDNS_A_QUERY_ORDER", tt.order)
+			n := newTestPlugin(t, path)
+
+			m, _ := exchange(t, n, &ctest.ResponseWriter{}, "both.example.com", tt.qtype)
+			if m == nil {
+				t.Fatal("no answer written")
+			}
+			if got := answerValues(m); fmt.Sprint(got) != fmt.Sprint(tt.answer) {
+				t.Errorf("answer = %v, want %v", got, tt.answer)
+			}
+		})
+	}
+}
+
+func TestConcurrentQueryLimit(t *testing.T) {
+	t.Setenv("NBDNS_MAX_CONCURRENT_QUERIES", "1")
+	n := newTestPlugin(t, writeRecords(t, &pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"}))
+	n.Next = ctest.NextHandler(dns.RcodeSuccess, nil)
+	w := &ctest.ResponseWriter{}
+
+	// Saturate the limit as a lookup in progress would
+	n.inflight <- struct{}{}
+
+	m, rcode := exchange(t, n, w, "web.example.com", dns.TypeA)
+	if rcode != dns.RcodeServerFailure || m != nil {
+		t.Errorf("in-zone query over the limit = %d, %v, want SERVFAIL without a written answer", rcode, m)
+	}
+	// Queries for other zones are passed on before the limit applies
+	if _, rcode := exchange(t, n, w, "example.org", dns.TypeA); rcode != dns.RcodeSuccess {
+		t.Errorf("out-of-zone query over the limit = %d, want it passed on", rcode)
+	}
+
+	<-n.inflight
+	m, rcode = exchange(t, n, w, "web.example.com", dns.TypeA)
+	if rcode != dns.RcodeSuccess || m == nil || len(m.Answer) != 1 {
+		t.Errorf("query below the limit = %d, %v, want the A record", rcode, m)
+	}
+	if len(n.inflight) != 0 {
+		t.Errorf("%d lookups still held after the query finished", len(n.inflight))
+	}
+}
+
+// BenchmarkLookupOverload runs far more concurrent queries than the limit
+// allows. Shed queries return at once, so ns/op stays close to BenchmarkLookup
+// instead of growing with the number of waiting queries.
+func BenchmarkLookupOverload(b *testing.B) {
+	b.Setenv("NBDNS_MAX_CONCURRENT_QUERIES", "1")
+	n := newTestPlugin(b, writeRecords(b, &pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"}))
+
+	var shed atomic.Int64
+	b.SetParallelism(64)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		w := &ctest.ResponseWriter{}
+		for pb.Next() {
+			if _, rcode := exchange(b, n, w, "web.example.com", dns.TypeA); rcode == dns.RcodeServerFailure {
+				shed.Add(1)
+			}
+		}
+	})
+	b.ReportMetric(float64(shed.Load())/float64(b.N), "shed/op")
+}
+
+func TestApexNS(t *testing.T) {
+	t.Setenv("NBDNS_ZONE_NS", "ns1.example.com, ns2.example.org., NS1.example.com")
+	n := newTestPlugin(t, writeRecords(t,
+		&pkgdns.Record{Name: "ns1", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.53"},
+		&pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"},
+	))
+	w := &ctest.ResponseWriter{}
+
+	m, _ := exchange(t, n, w, "example.com", dns.TypeNS)
+	if m == nil || m.Rcode != dns.RcodeSuccess || !m.Authoritative {
+		t.Fatalf("got %v, want an authoritative answer", m)
+	}
+	var servers []string
+	for _, rr := range m.Answer {
+		ns, ok := rr.(*dns.NS)
+		if !ok || ns.Hdr.Name != "example.com." {
+			t.Fatalf("answer %v, want NS records of example.com", rr)
+		}
+		servers = append(servers, ns.Ns)
+	}
+	if fmt.Sprint(servers) != "[ns1.example.com. ns2.example.org.]" {
+		t.Errorf("nameservers = %v, want ns1.example.com. and ns2.example.org.", servers)
+	}
+
+	// Only the in-zone nameserver gets glue
+	if len(m.Extra) != 1 {
+		t.Fatalf("additional section %v, want the A record of ns1.example.com", m.Extra)
+	}
+	if a, ok := m.Extra[0].(*dns.A); !ok || a.Hdr.Name != "ns1.example.com." || a.A.String() != "100.64.0.53" {
+		t.Errorf("glue %v, want ns1.example.com. A 100.64.0.53", m.Extra[0])
+	}
+
+	// Names below the apex hold no NS records
+	m, _ = exchange(t, n, w, "web.example.com", dns.TypeNS)
+	if m == nil || m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 || len(m.Ns) != 1 {
+		t.Errorf("NS query below the apex = %v, want NODATA with the SOA", m)
+	}
+}
+
+func TestForceTCPOverBytes(t *testing.T) {
+	t.Setenv("NBDNS_FORCE_TCP_OVER_BYTES", "512")
+	values := make([]string, 60)
+	for i := range values {
+		values[i] = fmt.Sprintf("100.64.1.%d", i+1)
+	}
+	n := newTestPlugin(t, writeRecords(t,
+		&pkgdns.Record{Name: "pool", Domain: "example.com", Type: pkgdns.RecordTypeA, Values: values},
+		&pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"},
+	))
+
+	query := func(name string) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion(dns.Fqdn(name), dns.TypeA)
+		// The client's buffer fits the whole answer, so only the threshold truncates it
+		r.SetEdns0(4096, false)
+		return r
+	}
+
+	// Over UDP the large answer is sent empty with TC set
+	m, _ := exchangeMsg(t, n, &ctest.ResponseWriter{}, query("pool.example.com"))
+	if m == nil || !m.Truncated || len(m.Answer) != 0 {
+		t.Fatalf("UDP answer over the threshold = %v, want it empty and truncated", m)
+	}
+	if m.IsEdns0() == nil {
+		t.Error("truncated answer lost its OPT record")
+	}
+
+	// The retry over TCP gets every address
+	m, _ = exchangeMsg(t, n, &ctest.ResponseWriter{TCP: true}, query("pool.example.com"))
+	if m == nil || m.Truncated || len(m.Answer) != len(values) {
+		t.Fatalf("TCP answer = %v, want all %d addresses", m, len(values))
+	}
+	if m.Len() <= 512 {
+		t.Errorf("TCP answer is %d bytes, want it over the threshold", m.Len())
+	}
+
+	// Small answers are still sent over UDP
+	m, _ = exchangeMsg(t, n, &ctest.ResponseWriter{}, query("web.example.com"))
+	if m == nil || m.Truncated || len(m.Answer) != 1 {
+		t.Errorf("UDP answer under the threshold = %v, want the A record", m)
+	}
+}
+
+func TestNegativeAnswers(t *testing.T) {
+	t.Setenv("NBDNS_NEGATIVE_TTL", "300")
+	n := newTestPlugin(t, writeRecords(t, &pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"}))
+	w := &ctest.ResponseWriter{}
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{"missing.example.com", dns.TypeA, dns.RcodeNameError},
+		{"web.example.com", dns.TypeTXT, dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		m, _ := exchange(t, n, w, tt.name, tt.qtype)
+		if m == nil {
+			t.Fatalf("%s %s: no answer written", tt.name, dns.TypeToString[tt.qtype])
+		}
+		if m.Rcode != tt.rcode || len(m.Answer) != 0 || !m.Authoritative {
+			t.Errorf("%s %s: rcode %s with %d answers, want an authoritative %s without answers",
+				tt.name, dns.TypeToString[tt.qtype], dns.RcodeToString[m.Rcode], len(m.Answer), dns.RcodeToString[tt.rcode])
+		}
+		if len(m.Ns) != 1 {
+			t.Fatalf("%s %s: %d authority records, want the SOA", tt.name, dns.TypeToString[tt.qtype], len(m.Ns))
+		}
+		soa, ok := m.Ns[0].(*dns.SOA)
+		if !ok || soa.Hdr.Name != "example.com." || soa.Hdr.Ttl != 300 || soa.Minttl != 300 {
+			t.Errorf("%s %s: authority %v, want the example.com SOA with the negative TTL", tt.name, dns.TypeToString[tt.qtype], m.Ns[0])
+		}
+	}
+}
+
+func TestResponseDelay(t *testing.T) {
+	delay := 100 * time.Millisecond
+	t.Setenv("NBDNS_CHAOS", "true")
+	t.Setenv("NBDNS_RESPONSE_DELAY", delay.String())
+	t.Setenv("NBDNS_MAX_CONCURRENT_QUERIES", "1")
+	n := newTestPlugin(t, writeRecords(t, &pkgdns.Record{Name: "web", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.10"}))
+	w := &ctest.ResponseWriter{}
+
+	// The delayed answer does not hold the lookup slot, so a second query is not shed
+	done := make(chan *dns.Msg, 1)
+	start := time.Now()
+	go func() {
+		m, _ := exchange(t, n, w, "web.example.com", dns.TypeA)
+		done <- m
+	}()
+	time.Sleep(delay / 2)
+	if len(n.inflight) != 0 {
+		t.Error("lookup slot held while the answer is delayed")
+	}
+	if m := <-done; m == nil || len(m.Answer) != 1 {
+		t.Fatalf("delayed query = %v, want the A record", m)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("answer written after %v, want at least %v", elapsed, delay)
+	}
+
+	// Replies CoreDNS writes from the returned rcode are delayed as well
+	n.inflight <- struct{}{}
+	defer func() { <-n.inflight }()
+	start = time.Now()
+	if _, rcode := exchange(t, n, w, "web.example.com", dns.TypeA); rcode != dns.RcodeServerFailure {
+		t.Errorf("shed query = %d, want SERVFAIL", rcode)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("SERVFAIL returned after %v, want at least %v", elapsed, delay)
+	}
+}
+
+func TestAllowedTypes(t *testing.T) {
+	n := newTestPlugin(t, writeRecords(t,
+		&pkgdns.Record{Name: "vault", Domain: "example.com", Type: pkgdns.RecordTypeA, Value: "100.64.0.20", AllowedTypes: []pkgdns.RecordType{pkgdns.RecordTypeA}},
+		&pkgdns.Record{Name: "vault", Domain: "example.com", Type: pkgdns.RecordTypeTXT, Value: "owner=ops"},
+	))
+	n.Fall = fall.Root
+	n.Next = ctest.NextHandler(dns.RcodeNameError, nil)
+	w := &ctest.ResponseWriter{}
+
+	if m, _ := exchange(t, n, w, "vault.example.com", dns.TypeA); m == nil || len(m.Answer) != 1 {
+		t.Errorf("A query = %v, want the allowed A record", m)
+	}
+
+	// ANY gets the unrestricted TXT record only
+	m, _ := exchange(t, n, w, "vault.example.com", dns.TypeANY)
+	if m == nil || len(m.Answer) != 1 || m.Answer[0].Header().Rrtype != dns.TypeTXT {
+		t.Errorf("ANY query = %v, want only the TXT record", m)
+	}
+}
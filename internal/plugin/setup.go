@@ -1,11 +1,16 @@
 package plugin
 
 import (
+	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/fall"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
 )
 
@@ -16,15 +21,27 @@ func init() {
 	plugin.Register("netbird", setup)
 }
 
-// setup configures the NetBird plugin with the given domains
+// setup configures the NetBird plugin with the given domains:
+//
+//	netbird DOMAINS... {
+//	    fallthrough [ZONES...]
+//	    ttl MIN [MAX]
+//	    forward ADDR
+//	    records_file PATH
+//	    refresh DURATION
+//	}
+//
+// ttl overrides NBDNS_TTL_MIN and NBDNS_TTL_MAX; 0 leaves that side unbounded.
+// forward sends queries the plugin does not answer to ADDR (port 53 unless given),
+// waiting at most NBDNS_UPSTREAM_TIMEOUT for each.
+// records_file and refresh override NBDNS_RECORDS_FILE and NBDNS_REFRESH_INTERVAL.
 func setup(c *caddy.Controller) error {
 	var domains []string
 
 	c.Next() // 'netbird'
 
-	// Parse all domains on the same line
-	for c.NextArg() {
-		domain := c.Val()
+	// Parse all domains on the same line, stopping before the block's opening brace
+	for _, domain := range c.RemainingArgs() {
 		// Split by comma if multiple domains are provided together
 		if strings.Contains(domain, ",") {
 			parts := strings.Split(domain, ",")
@@ -43,10 +60,74 @@ func setup(c *caddy.Controller) error {
 		return c.ArgErr()
 	}
 
-	nb, err := New(domains)
+	// Parse the optional block
+	var fall fall.F
+	var ttlBounds []uint32
+	var forward string
+	var opts Options
+	for c.NextBlock() {
+		switch c.Val() {
+		case "fallthrough":
+			fall.SetZonesFromArgs(c.RemainingArgs())
+		case "ttl":
+			args := c.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return c.ArgErr()
+			}
+			ttlBounds = ttlBounds[:0]
+			for _, arg := range args {
+				ttl, err := strconv.ParseUint(arg, 10, 32)
+				if err != nil {
+					return c.Errf("invalid ttl '%s'", arg)
+				}
+				ttlBounds = append(ttlBounds, uint32(ttl))
+			}
+			if len(ttlBounds) == 2 && ttlBounds[1] > 0 && ttlBounds[0] > ttlBounds[1] {
+				return c.Errf("ttl minimum %d is greater than maximum %d", ttlBounds[0], ttlBounds[1])
+			}
+		case "forward":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return c.ArgErr()
+			}
+			addr, err := parseForwardAddr(args[0])
+			if err != nil {
+				return c.Errf("invalid forward address '%s': %v", args[0], err)
+			}
+			forward = addr
+		case "records_file":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return c.ArgErr()
+			}
+			opts.RecordsFile = args[0]
+		case "refresh":
+			args := c.RemainingArgs()
+			if len(args) != 1 {
+				return c.ArgErr()
+			}
+			interval, err := time.ParseDuration(args[0])
+			if err != nil || interval <= 0 {
+				return c.Errf("invalid refresh interval '%s'", args[0])
+			}
+			opts.RefreshInterval = interval
+		default:
+			return c.Errf("unknown property '%s'", c.Val())
+		}
+	}
+
+	nb, err := New(domains, opts)
 	if err != nil {
 		return plugin.Error("netbird", err)
 	}
+	nb.Fall = fall
+	nb.Forward = forward
+	if len(ttlBounds) > 0 {
+		nb.TTLMin = ttlBounds[0]
+		if len(ttlBounds) == 2 {
+			nb.TTLMax = ttlBounds[1]
+		}
+	}
 
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		nb.Next = next
@@ -55,3 +136,18 @@ func setup(c *caddy.Controller) error {
 
 	return nil
 }
+
+// parseForwardAddr returns addr as host:port, defaulting to port 53
+func parseForwardAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("%s is not an IP address", host)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("invalid port %s", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+
+	"netbird-coredns/pkg/dns"
+)
+
+// setupPlugin runs setup on a Corefile server block and returns the plugin it added
+func setupPlugin(t *testing.T, input string) (*NetBird, error) {
+	t.Helper()
+	c := caddy.NewTestController("dns", input)
+	if err := setup(c); err != nil {
+		return nil, err
+	}
+	plugins := dnsserver.GetConfig(c).Plugin
+	if len(plugins) != 1 {
+		t.Fatalf("setup added %d plugins, want 1", len(plugins))
+	}
+	return plugins[0](nil).(*NetBird), nil
+}
+
+func TestSetupBlock(t *testing.T) {
+	path := writeRecords(t, &dns.Record{Name: "web", Domain: "example.com", Type: dns.RecordTypeA, Value: "100.64.0.10"})
+
+	nb, err := setupPlugin(t, `netbird example.com internal.net. {
+		records_file `+path+`
+		refresh 45s
+		ttl 30 600
+		forward 10.0.0.2
+		fallthrough
+	}`)
+	if err != nil {
+		t.Fatalf("setup failed: %v", err)
+	}
+
+	if len(nb.Domains) != 2 || nb.Domains[0] != "example.com" || nb.Domains[1] != "internal.net" {
+		t.Errorf("Domains = %v, want example.com and internal.net", nb.Domains)
+	}
+	if nb.refreshInterval != 45*time.Second {
+		t.Errorf("refresh interval = %v, want 45s", nb.refreshInterval)
+	}
+	if nb.TTLMin != 30 || nb.TTLMax != 600 {
+		t.Errorf("TTL bounds = %d-%d, want 30-600", nb.TTLMin, nb.TTLMax)
+	}
+	if nb.Forward != "10.0.0.2:53" {
+		t.Errorf("Forward = %q, want 10.0.0.2:53", nb.Forward)
+	}
+	if !nb.Fall.Through("anything.example.com.") {
+		t.Error("fallthrough without zones does not cover every name")
+	}
+	if _, err := nb.storage.GetRecord("example.com", "web", dns.RecordTypeA); err != nil {
+		t.Errorf("records were not loaded from records_file: %v", err)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	path := writeRecords(t)
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"no domains", `netbird`},
+		{"records_file without path", "netbird example.com {\n records_file\n}"},
+		{"records_file with two paths", "netbird example.com {\n records_file " + path + " " + path + "\n}"},
+		{"invalid refresh", "netbird example.com {\n records_file " + path + "\n refresh soon\n}"},
+		{"zero refresh", "netbird example.com {\n records_file " + path + "\n refresh 0s\n}"},
+		{"ttl minimum above maximum", "netbird example.com {\n records_file " + path + "\n ttl 600 30\n}"},
+		{"forward to a hostname", "netbird example.com {\n records_file " + path + "\n forward dns.example.org\n}"},
+		{"forward without address", "netbird example.com {\n records_file " + path + "\n forward\n}"},
+		{"unknown property", "netbird example.com {\n records_file " + path + "\n cache 30\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := setupPlugin(t, tt.input); err == nil {
+				t.Errorf("setup(%q) succeeded, want an error", tt.input)
+			}
+		})
+	}
+}
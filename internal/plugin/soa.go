@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/miekg/dns"
+)
+
+// defaultNegativeTTL is how long resolvers may cache negative answers by default
+const defaultNegativeTTL = 60
+
+// Timers of the served SOA record. Nothing transfers the zone from this server,
+// so they only need to be plausible.
+const (
+	soaRefresh = 7200
+	soaRetry   = 1800
+	soaExpire  = 1209600
+)
+
+// SOA holds the fields of the SOA record served in the authority section of
+// negative answers, which resolvers need to cache them (RFC 2308)
+type SOA struct {
+	// Mname and Rname are fully qualified; empty ones are derived from the zone
+	Mname string
+	Rname string
+	// Serial is served as is; nothing transfers the zone, so it is not bumped
+	Serial uint32
+	// NegativeTTL is both the SOA's TTL and its MINIMUM field, so it bounds
+	// how long resolvers cache the negative answer
+	NegativeTTL uint32
+}
+
+// getSOA returns the SOA fields from the environment. Invalid names stop the
+// plugin from starting, as they would be served to every client.
+func getSOA() (SOA, error) {
+	soa := SOA{Serial: 1, NegativeTTL: defaultNegativeTTL}
+
+	if mname := getenv("NBDNS_SOA_MNAME"); mname != "" {
+		host := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(mname), "."))
+		if _, ok := dns.IsDomainName(host); !ok || !strings.Contains(host, ".") {
+			return soa, fmt.Errorf("invalid NBDNS_SOA_MNAME value: %s", mname)
+		}
+		soa.Mname = host + "."
+	}
+
+	// The mailbox may be given as an address; its local part becomes the first label
+	if rname := getenv("NBDNS_SOA_RNAME"); rname != "" {
+		mailbox := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(rname), "."))
+		if local, domain, ok := strings.Cut(mailbox, "@"); ok {
+			mailbox = strings.ReplaceAll(local, ".", "\\.") + "." + domain
+		}
+		if _, ok := dns.IsDomainName(mailbox); !ok || !strings.Contains(mailbox, ".") {
+			return soa, fmt.Errorf("invalid NBDNS_SOA_RNAME value: %s", rname)
+		}
+		soa.Rname = mailbox + "."
+	}
+
+	if serialStr := getenv("NBDNS_SOA_SERIAL"); serialStr != "" {
+		if serial, err := strconv.ParseUint(serialStr, 10, 32); err == nil {
+			soa.Serial = uint32(serial)
+		} else {
+			clog.Warningf("invalid NBDNS_SOA_SERIAL value '%s' (0-4294967295), using default 1", serialStr)
+		}
+	}
+
+	if ttlStr := getenv("NBDNS_NEGATIVE_TTL"); ttlStr != "" {
+		if ttl, err := strconv.ParseUint(ttlStr, 10, 32); err == nil && ttl <= 86400 {
+			soa.NegativeTTL = uint32(ttl)
+		} else {
+			clog.Warningf("invalid NBDNS_NEGATIVE_TTL value '%s' (0-86400), using default %d", ttlStr, defaultNegativeTTL)
+		}
+	}
+
+	return soa, nil
+}
+
+// soaRecord returns the SOA record of the configured domain closest to name.
+// Without NBDNS_SOA_MNAME the first NBDNS_ZONE_NS nameserver, or ns.<zone>, is
+// the primary; without NBDNS_SOA_RNAME the mailbox is hostmaster.<zone>.
+func (n *NetBird) soaRecord(name string, qclass uint16) (*dns.SOA, bool) {
+	zone, ok := n.zones.innermost(strings.TrimSuffix(strings.ToLower(name), "."))
+	if !ok {
+		return nil, false
+	}
+
+	mname := n.SOA.Mname
+	if mname == "" {
+		if len(n.ZoneNS) > 0 {
+			mname = n.ZoneNS[0]
+		} else {
+			mname = "ns." + zone + "."
+		}
+	}
+	rname := n.SOA.Rname
+	if rname == "" {
+		rname = "hostmaster." + zone + "."
+	}
+
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone + ".", Rrtype: dns.TypeSOA, Class: qclass, Ttl: n.SOA.NegativeTTL},
+		Ns:      mname,
+		Mbox:    rname,
+		Serial:  n.SOA.Serial,
+		Refresh: soaRefresh,
+		Retry:   soaRetry,
+		Expire:  soaExpire,
+		Minttl:  n.SOA.NegativeTTL,
+	}, true
+}
+
+// addNegativeSOA puts the zone's SOA record in the authority section of an
+// NXDOMAIN or NODATA answer
+func (n *NetBird) addNegativeSOA(m *dns.Msg, name string, qclass uint16) {
+	if soa, ok := n.soaRecord(name, qclass); ok {
+		m.Ns = append(m.Ns, soa)
+	}
+}
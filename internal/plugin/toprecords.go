@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recordKey identifies a record by the domain and name it is stored under
+type recordKey struct {
+	domain string
+	name   string
+}
+
+// recordHits is a tracked record and its hit count
+type recordHits struct {
+	key   recordKey
+	count uint64
+	// index is the position in the heap
+	index int
+}
+
+// hitsHeap is a min-heap of tracked records by hit count
+type hitsHeap []*recordHits
+
+func (h hitsHeap) Len() int           { return len(h) }
+func (h hitsHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hitsHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *hitsHeap) Push(x interface{}) {
+	entry := x.(*recordHits)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *hitsHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// topRecords counts hits for at most limit records, keeping the most queried
+// ones (the Space-Saving algorithm): once full, a record not yet tracked
+// replaces the least queried one and inherits its count. Counts of records
+// that took over a slot are therefore upper bounds, but a record queried more
+// often than the limit-th most queried one is always tracked. A limit of 0
+// disables per-record counting, so the number of series never grows with the
+// number of records.
+type topRecords struct {
+	desc *prometheus.Desc
+
+	mu      sync.Mutex
+	limit   int
+	entries map[recordKey]*recordHits
+	heap    hitsHeap
+}
+
+// newTopRecords creates a disabled top records counter
+func newTopRecords() *topRecords {
+	return &topRecords{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(plugin.Namespace, "netbird", "record_hits_total"),
+			"Counter of queries that matched a custom DNS record, for the most queried records only.",
+			[]string{"domain", "name"}, nil,
+		),
+		entries: make(map[recordKey]*recordHits),
+	}
+}
+
+// SetLimit changes how many records are tracked. Tracked records are kept
+// unless the limit shrinks below their number, in which case counting starts over.
+func (t *topRecords) SetLimit(limit int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.limit = limit
+	if len(t.heap) > limit {
+		t.entries = make(map[recordKey]*recordHits)
+		t.heap = nil
+	}
+}
+
+// Inc counts a hit for the record stored under name in domain
+func (t *topRecords) Inc(domain, name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.limit == 0 {
+		return
+	}
+
+	key := recordKey{domain: domain, name: name}
+	if entry, ok := t.entries[key]; ok {
+		entry.count++
+		heap.Fix(&t.heap, entry.index)
+		return
+	}
+
+	if len(t.heap) < t.limit {
+		entry := &recordHits{key: key, count: 1}
+		t.entries[key] = entry
+		heap.Push(&t.heap, entry)
+		return
+	}
+
+	// Replace the least queried record
+	entry := t.heap[0]
+	delete(t.entries, entry.key)
+	entry.key = key
+	entry.count++
+	t.entries[key] = entry
+	heap.Fix(&t.heap, 0)
+}
+
+// Describe implements prometheus.Collector
+func (t *topRecords) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.desc
+}
+
+// Collect implements prometheus.Collector
+func (t *topRecords) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, entry := range t.heap {
+		ch <- prometheus.MustNewConstMetric(t.desc, prometheus.CounterValue, float64(entry.count), entry.key.domain, entry.key.name)
+	}
+}
@@ -3,11 +3,15 @@ package process
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -17,6 +21,9 @@ import (
 	"netbird-coredns/internal/logger"
 )
 
+// errAddressInUse indicates CoreDNS exited because its listen address was still bound
+var errAddressInUse = errors.New("address already in use")
+
 // Manager handles multiple processes and their lifecycle
 type Manager struct {
 	config    *config.Config
@@ -24,14 +31,32 @@ type Manager struct {
 	mu        sync.RWMutex
 	ctx       context.Context
 	cancel    context.CancelFunc
+
+	// recordCounts reports records per domain for state dumps
+	recordCounts func() map[string]int
+	// stopAPI drains the API server on shutdown before the processes are stopped
+	stopAPI func(ctx context.Context) error
+
+	// netbirdExternal is set when an already connected NetBird daemon is used
+	// instead of starting "netbird up"
+	netbirdExternal bool
 }
 
 // Process represents a managed process
 type Process struct {
-	name    string
-	cmd     *exec.Cmd
-	running bool
-	mu      sync.RWMutex
+	name      string
+	cmd       *exec.Cmd
+	running   bool
+	startedAt time.Time
+	mu        sync.RWMutex
+}
+
+// Status describes a managed process
+type Status struct {
+	Name      string    `json:"name"`
+	PID       int       `json:"pid"`
+	Running   bool      `json:"running"`
+	StartedAt time.Time `json:"started_at"`
 }
 
 // NewManager creates a new process manager
@@ -80,16 +105,28 @@ func (m *Manager) StartNetBird() error {
 		time.Sleep(2 * time.Second)
 	}
 
+	// A daemon restored from persisted state may already be logged in and
+	// connected; running "netbird up" again would only re-register the peer
+	if connected, err := m.NetBirdConnected(); err == nil && connected {
+		logger.Info("NetBird is already connected, skipping netbird up")
+		m.netbirdExternal = true
+		return nil
+	}
+
 	// Now connect to the network using netbird up in foreground mode
 	logger.Info("Connecting to NetBird network...")
 	args := []string{
 		"up",
 		"--foreground-mode", // Run in foreground for Docker containers
-		"--setup-key=" + m.config.SetupKey,
 		"--management-url=" + m.config.ManagementURL,
 		"--hostname=" + m.config.Hostname,
 		"--log-level=" + m.config.LogLevel,
 	}
+	if m.config.SetupKey != "" {
+		args = append(args, "--setup-key="+m.config.SetupKey)
+	} else {
+		logger.Info("NBDNS_SETUP_KEY is not set, relying on NetBird's persisted login")
+	}
 
 	// Add DNS labels - critical for service discovery
 	if len(m.config.DNSLabels) > 0 {
@@ -106,12 +143,14 @@ func (m *Manager) StartNetBird() error {
 
 	// Capture both stdout and stderr to detect errors
 	var stdout, stderr bytes.Buffer
-	cmd.Stdout = io.MultiWriter(os.Stdout, &stdout)
-	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	outWriter, errWriter := m.outputWriters("netbird")
+	cmd.Stdout = io.MultiWriter(outWriter, &stdout)
+	cmd.Stderr = io.MultiWriter(errWriter, &stderr)
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start NetBird: %w", err)
 	}
+	startedAt := time.Now()
 
 	// Wait briefly to detect immediate failures
 	time.Sleep(2 * time.Second)
@@ -125,6 +164,9 @@ func (m *Manager) StartNetBird() error {
 
 	// Check if process is still running
 	if err := cmd.Process.Signal(syscall.Signal(0)); err != nil {
+		if m.config.SetupKey == "" {
+			return fmt.Errorf("NetBird process exited immediately without a persisted login; NBDNS_SETUP_KEY is required for the first join: %s", strings.TrimSpace(errOutput))
+		}
 		if errOutput != "" {
 			return fmt.Errorf("NetBird process exited immediately: %s", errOutput)
 		}
@@ -132,9 +174,10 @@ func (m *Manager) StartNetBird() error {
 	}
 
 	process := &Process{
-		name:    "netbird",
-		cmd:     cmd,
-		running: true,
+		name:      "netbird",
+		cmd:       cmd,
+		running:   true,
+		startedAt: startedAt,
 	}
 
 	m.mu.Lock()
@@ -166,6 +209,11 @@ func (m *Manager) StartNetBird() error {
 func (m *Manager) WaitForNetBirdConnection() error {
 	logger.Info("Waiting for NetBird connection to be established...")
 
+	if m.netbirdExternal {
+		logger.Info("NetBird was already connected, proceeding with CoreDNS startup")
+		return nil
+	}
+
 	// In foreground mode, NetBird runs directly - wait for initial connection setup
 	logger.Info("NetBird is running in foreground mode, waiting for initial connection setup...")
 
@@ -199,20 +247,243 @@ func (m *Manager) WaitForNetBirdConnection() error {
 	return nil
 }
 
-// StartCoreDNS starts the CoreDNS server with the specified config file
+// WaitForDNSLabel waits up to timeout for this peer's first DNS label to resolve
+// through NetBird DNS, so CoreDNS only starts once the service is discoverable.
+// When the label cannot be checked, it simply waits for the full timeout.
+func (m *Manager) WaitForDNSLabel(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	var name string
+	if len(m.config.DNSLabels) > 0 {
+		if domain, err := m.DiscoverNetBirdDomain(); err == nil {
+			name = m.config.DNSLabels[0] + "." + domain
+		} else {
+			logger.Debug("Cannot check DNS label resolution: %v", err)
+		}
+	}
+
+	if name == "" {
+		logger.Info("Waiting %v before starting CoreDNS...", timeout)
+	} else {
+		logger.Info("Waiting up to %v for %s to resolve before starting CoreDNS...", timeout, name)
+	}
+
+	for {
+		if name != "" {
+			ctx, cancel := context.WithTimeout(m.ctx, 2*time.Second)
+			addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+			cancel()
+			if err == nil && len(addrs) > 0 {
+				logger.Info("DNS label %s resolves to %s", name, strings.Join(addrs, ", "))
+				return
+			}
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if name != "" {
+				logger.Warn("DNS label %s did not resolve within %v, starting CoreDNS anyway", name, timeout)
+			}
+			return
+		}
+
+		select {
+		case <-time.After(min(time.Second, remaining)):
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// DiscoverNetBirdDomain asks the local NetBird daemon for this peer's FQDN and
+// returns the account's DNS domain (the FQDN without the peer's own label)
+func (m *Manager) DiscoverNetBirdDomain() (string, error) {
+	cmd := exec.CommandContext(m.ctx, "netbird", "status", "--json")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to query NetBird status: %w", err)
+	}
+
+	var status struct {
+		FQDN string `json:"fqdn"`
+	}
+	if err := json.Unmarshal(output, &status); err != nil {
+		return "", fmt.Errorf("failed to parse NetBird status: %w", err)
+	}
+
+	fqdn := strings.TrimSuffix(status.FQDN, ".")
+	parts := strings.SplitN(fqdn, ".", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", fmt.Errorf("NetBird did not report a usable FQDN: %q", status.FQDN)
+	}
+
+	return strings.ToLower(parts[1]), nil
+}
+
+// DiscoverNetBirdIP returns the IPv4 address of the NetBird interface named by
+// NBDNS_NETBIRD_INTERFACE. The interface may only get its address shortly after
+// the peer connects, so it is looked up for a few seconds.
+func (m *Manager) DiscoverNetBirdIP() (net.IP, error) {
+	var lastErr error
+	for attempt := 0; attempt < 10; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Second):
+			case <-m.ctx.Done():
+				return nil, m.ctx.Err()
+			}
+		}
+
+		iface, err := net.InterfaceByName(m.config.NetBirdInterface)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				return ipNet.IP.To4(), nil
+			}
+		}
+		lastErr = fmt.Errorf("interface %s has no IPv4 address", m.config.NetBirdInterface)
+	}
+	return nil, lastErr
+}
+
+// NetBirdConnected asks the local NetBird daemon whether this peer is connected
+// to both the management and the signal service
+func (m *Manager) NetBirdConnected() (bool, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "netbird", "status", "--json").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to query NetBird status: %w", err)
+	}
+
+	var status struct {
+		Management struct {
+			Connected bool `json:"connected"`
+		} `json:"management"`
+		Signal struct {
+			Connected bool `json:"connected"`
+		} `json:"signal"`
+	}
+	if err := json.Unmarshal(output, &status); err != nil {
+		return false, fmt.Errorf("failed to parse NetBird status: %w", err)
+	}
+
+	return status.Management.Connected && status.Signal.Connected, nil
+}
+
+// MonitorNetBirdConnection checks the NetBird connection every interval until
+// the manager stops and calls onChange whenever it goes up or down. A daemon
+// that cannot be queried counts as disconnected. The connection is assumed to
+// be up when monitoring starts.
+func (m *Manager) MonitorNetBirdConnection(interval time.Duration, onChange func(connected bool)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	connected := true
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.ctx.Done():
+			return
+		}
+
+		now, err := m.NetBirdConnected()
+		if err != nil {
+			logger.Debug("NetBird connection check failed: %v", err)
+		}
+		if now == connected || m.ctx.Err() != nil {
+			continue
+		}
+
+		connected = now
+		if connected {
+			logger.Info("NetBird connection restored")
+		} else {
+			logger.Warn("NetBird connection lost")
+		}
+		onChange(connected)
+	}
+}
+
+// StartCoreDNS starts the CoreDNS server with the specified config file.
+// If CoreDNS exits right away because the DNS port is still held (e.g. by a
+// previous instance during a restart), it is retried with exponential backoff.
 func (m *Manager) StartCoreDNS(corefilePath string) error {
+	maxAttempts := m.config.CoreDNSStartRetries + 1
+	backoff := time.Second
+
+	for attempt := 1; ; attempt++ {
+		err := m.startCoreDNSOnce(corefilePath)
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, errAddressInUse) {
+			return err
+		}
+
+		if attempt >= maxAttempts {
+			return fmt.Errorf("CoreDNS failed to start after %d attempt(s), DNS port %d is still in use: %w", attempt, m.config.DNSPort, err)
+		}
+
+		logger.Warn("CoreDNS could not bind DNS port %d (attempt %d/%d), retrying in %v...", m.config.DNSPort, attempt, maxAttempts, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-m.ctx.Done():
+			return fmt.Errorf("CoreDNS startup cancelled: %w", m.ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// startCoreDNSOnce makes a single attempt at starting CoreDNS and waits briefly to detect early exits
+func (m *Manager) startCoreDNSOnce(corefilePath string) error {
 	cmd := exec.CommandContext(m.ctx, "coredns", "-conf", corefilePath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+
+	stderr := &limitedBuffer{max: 64 * 1024}
+	outWriter, errWriter := m.outputWriters("coredns")
+	cmd.Stdout = outWriter
+	cmd.Stderr = io.MultiWriter(errWriter, stderr)
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start CoreDNS: %w", err)
 	}
+	startedAt := time.Now()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	// Wait briefly to detect immediate failures such as a port that is still bound
+	select {
+	case err := <-done:
+		errOutput := stderr.String()
+		if strings.Contains(errOutput, "address already in use") {
+			return fmt.Errorf("%w: %s", errAddressInUse, strings.TrimSpace(errOutput))
+		}
+		if errOutput != "" {
+			return fmt.Errorf("CoreDNS process exited immediately: %s", strings.TrimSpace(errOutput))
+		}
+		return fmt.Errorf("CoreDNS process exited immediately: %v", err)
+	case <-time.After(2 * time.Second):
+	}
 
 	process := &Process{
-		name:    "coredns",
-		cmd:     cmd,
-		running: true,
+		name:      "coredns",
+		cmd:       cmd,
+		running:   true,
+		startedAt: startedAt,
 	}
 
 	m.mu.Lock()
@@ -221,12 +492,23 @@ func (m *Manager) StartCoreDNS(corefilePath string) error {
 
 	logger.Info("Started CoreDNS with PID: %d", cmd.Process.Pid)
 
-	// Monitor the process
-	go m.monitorProcess(process)
+	// Monitor the process (Wait() is already running in the goroutine above)
+	go func() {
+		m.handleProcessExit(process, <-done)
+	}()
 
 	return nil
 }
 
+// outputWriters returns where a child process's stdout and stderr are written
+func (m *Manager) outputWriters(name string) (io.Writer, io.Writer) {
+	if m.config.ChildOutput == config.ChildOutputRaw {
+		return os.Stdout, os.Stderr
+	}
+
+	return logger.NewLineWriter(name), logger.NewLineWriter(name)
+}
+
 // monitorProcess monitors a process and handles its lifecycle
 func (m *Manager) monitorProcess(process *Process) {
 	// Check if ProcessState is already set (meaning Wait() was already called)
@@ -244,6 +526,11 @@ func (m *Manager) monitorProcess(process *Process) {
 		err = process.cmd.Wait()
 	}
 
+	m.handleProcessExit(process, err)
+}
+
+// handleProcessExit records that a process has exited and triggers shutdown if it was unexpected
+func (m *Manager) handleProcessExit(process *Process, err error) {
 	process.mu.Lock()
 	process.running = false
 	process.mu.Unlock()
@@ -331,24 +618,54 @@ func (m *Manager) Stop() error {
 	return nil
 }
 
+// drainAPI stops the API server from accepting connections and waits up to the
+// configured drain timeout for active requests to complete
+func (m *Manager) drainAPI() {
+	m.mu.RLock()
+	stop := m.stopAPI
+	m.mu.RUnlock()
+	if stop == nil {
+		return
+	}
+
+	logger.Info("Draining API requests (timeout %v)...", m.config.APIDrainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), m.config.APIDrainTimeout)
+	defer cancel()
+	if err := stop(ctx); err != nil {
+		logger.Warn("API requests did not finish within %v, closing: %v", m.config.APIDrainTimeout, err)
+	}
+}
+
 // RunWithSignalHandling runs the process manager with signal handling
 func (m *Manager) RunWithSignalHandling() error {
 	// Set up signal handling
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGUSR1)
 
 	logger.Debug("Process manager is now waiting for signals...")
 
-	// Wait for either termination signal or context cancellation
-	select {
-	case sig := <-sigChan:
-		logger.Info("Received termination signal: %v - initiating graceful shutdown", sig)
-	case <-m.ctx.Done():
-		logger.Info("Process manager context cancelled - initiating shutdown")
+	// Wait for either termination signal or context cancellation; SIGUSR1 only dumps state
+wait:
+	for {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGUSR1 {
+				m.DumpState()
+				continue
+			}
+			logger.Info("Received termination signal: %v - initiating graceful shutdown", sig)
+			break wait
+		case <-m.ctx.Done():
+			logger.Info("Process manager context cancelled - initiating shutdown")
+			break wait
+		}
 	}
 
 	logger.Info("Beginning shutdown sequence...")
 
+	// Let in-flight API requests finish while CoreDNS still serves their records
+	m.drainAPI()
+
 	// Stop all processes
 	if err := m.Stop(); err != nil {
 		logger.Error("Error during shutdown: %v", err)
@@ -359,6 +676,68 @@ func (m *Manager) RunWithSignalHandling() error {
 	return nil
 }
 
+// SetAPIStopper sets the function that drains the API server on shutdown
+func (m *Manager) SetAPIStopper(stop func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopAPI = stop
+}
+
+// SetRecordCounter sets the function used to report records per domain in state dumps
+func (m *Manager) SetRecordCounter(counter func() map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.recordCounts = counter
+}
+
+// DumpState logs a snapshot of the service's internal state for live debugging
+func (m *Manager) DumpState() {
+	logger.Info("State dump:")
+	logger.Info("  Mode: %s", m.config.Mode)
+	logger.Info("  Domains: %s", strings.Join(m.config.Domains, ", "))
+	logger.Info("  Refresh interval: %d seconds", m.config.RefreshInterval)
+
+	running := m.GetRunningProcesses()
+	if len(running) == 0 {
+		logger.Info("  Running processes: none")
+	} else {
+		logger.Info("  Running processes: %s", strings.Join(running, ", "))
+	}
+
+	m.mu.RLock()
+	recordCounts := m.recordCounts
+	m.mu.RUnlock()
+
+	if recordCounts != nil {
+		counts := recordCounts()
+		domains := make([]string, 0, len(counts))
+		for domain := range counts {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+
+		logger.Info("  Records: %d domain(s)", len(domains))
+		for _, domain := range domains {
+			logger.Info("    %s: %d", domain, counts[domain])
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "netbird", "status").CombinedOutput()
+	if err != nil {
+		logger.Info("  NetBird status: unavailable (%v)", err)
+		return
+	}
+	logger.Info("  NetBird status:")
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		logger.Info("    %s", line)
+	}
+}
+
 // GetRunningProcesses returns a list of currently running process names
 func (m *Manager) GetRunningProcesses() []string {
 	m.mu.RLock()
@@ -376,7 +755,58 @@ func (m *Manager) GetRunningProcesses() []string {
 	return running
 }
 
+// Processes returns the status of every managed process in start order
+func (m *Manager) Processes() []Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make([]Status, 0, len(m.processes))
+	for _, process := range m.processes {
+		process.mu.RLock()
+		statuses = append(statuses, Status{
+			Name:      process.name,
+			PID:       process.cmd.Process.Pid,
+			Running:   process.running,
+			StartedAt: process.startedAt.UTC(),
+		})
+		process.mu.RUnlock()
+	}
+
+	return statuses
+}
+
 // GetContext returns the manager's context
 func (m *Manager) GetContext() context.Context {
 	return m.ctx
 }
+
+// limitedBuffer is a concurrency-safe buffer that stops growing once max bytes have been written
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+// Write appends p to the buffer up to the size limit; excess bytes are discarded
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if remaining := b.max - b.buf.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.buf.Write(p[:remaining])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+
+	return len(p), nil
+}
+
+// String returns the buffered contents
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
@@ -9,13 +9,42 @@ import (
 	"netbird-coredns/internal/config"
 )
 
+// CoreDNS manages its own listeners and has no option for the listen backlog,
+// which stays at the kernel default (net.core.somaxconn). Sharing the port via
+// SO_REUSEPORT is done with the multisocket plugin, part of the standard
+// plugin.cfg since CoreDNS 1.12.
 const corefileTemplate = `.{{ if ne .DNSPort 53 }}:{{ .DNSPort }}{{ end }} {
-    netbird {{ .DomainsString }}
+{{- if .Bind }}
+    bind {{ .Bind }}
+{{- end }}
+{{- if .ReusePort }}
+    multisocket{{ if .Sockets }} {{ .Sockets }}{{ end }}
+{{- end }}
+    netbird {{ .DomainsString }}{{ if .Fallthrough }} {
+        fallthrough{{ if .FallthroughZones }} {{ .FallthroughZones }}{{ end }}
+    }{{ end }}
 {{- if .ForwardTo }}
     forward . {{ .ForwardTo }}
+{{- end }}
+{{- if .MetricsPort }}
+    prometheus :{{ .MetricsPort }}
+{{- end }}
+{{- if .ReloadInterval }}
+    reload {{ .ReloadInterval }}s
 {{- end }}
     log
+{{- if or .ErrorsConsolidate .ErrorsStacktrace }}
+    errors {
+{{- if .ErrorsStacktrace }}
+        stacktrace
+{{- end }}
+{{- if .ErrorsConsolidate }}
+        consolidate {{ .ErrorsConsolidate }} "{{ .ErrorsPattern }}"
+{{- end }}
+    }
+{{- else }}
     errors
+{{- end }}
 }
 `
 
@@ -24,6 +53,27 @@ type CorefileData struct {
 	DomainsString string
 	ForwardTo     string
 	DNSPort       int
+	MetricsPort   int
+
+	Fallthrough      bool
+	FallthroughZones string
+
+	// ReloadInterval renders the reload plugin when non-zero (seconds)
+	ReloadInterval int
+
+	// ErrorsConsolidate, when set, consolidates errors matching ErrorsPattern
+	// over this duration; ErrorsStacktrace logs stack traces of panics
+	ErrorsConsolidate string
+	ErrorsPattern     string
+	ErrorsStacktrace  bool
+
+	// ReusePort renders the multisocket plugin with Sockets servers, or
+	// GOMAXPROCS servers when Sockets is zero
+	ReusePort bool
+	Sockets   int
+
+	// Bind lists the addresses to listen on, all addresses when empty
+	Bind string
 }
 
 // Generator handles Corefile generation
@@ -52,6 +102,24 @@ func (g *Generator) GenerateCorefile(cfg *config.Config) (string, error) {
 		DomainsString: domainsString,
 		ForwardTo:     cfg.ForwardTo,
 		DNSPort:       cfg.DNSPort,
+		MetricsPort:   cfg.MetricsPort,
+
+		Fallthrough:      cfg.Fallthrough,
+		FallthroughZones: strings.Join(cfg.FallthroughZones, " "),
+
+		ErrorsPattern:    cfg.CoreDNSErrorsPattern,
+		ErrorsStacktrace: cfg.CoreDNSErrorsStacktrace,
+
+		ReusePort: cfg.DNSReusePort,
+		Sockets:   cfg.DNSSockets,
+
+		Bind: strings.Join(cfg.BindAddresses, " "),
+	}
+	if cfg.CoreDNSErrorsConsolidate > 0 {
+		data.ErrorsConsolidate = cfg.CoreDNSErrorsConsolidate.String()
+	}
+	if cfg.CoreDNSReload {
+		data.ReloadInterval = cfg.CoreDNSReloadInterval
 	}
 
 	var buf strings.Builder
@@ -0,0 +1,125 @@
+package template
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy/caddyfile"
+
+	"netbird-coredns/internal/config"
+)
+
+// testConfig returns the smallest configuration the generator renders
+func testConfig() *config.Config {
+	return &config.Config{Domains: []string{"example.com"}, DNSPort: 53}
+}
+
+// generate renders cfg and checks that CoreDNS can parse the result. It returns
+// the Corefile and its lines without indentation.
+func generate(t *testing.T, cfg *config.Config) (string, []string) {
+	t.Helper()
+	g, err := NewGenerator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	corefile, err := g.GenerateCorefile(cfg)
+	if err != nil {
+		t.Fatalf("GenerateCorefile() failed: %v", err)
+	}
+
+	blocks, err := caddyfile.Parse("Corefile", strings.NewReader(corefile), nil)
+	if err != nil {
+		t.Fatalf("generated Corefile does not parse: %v\n%s", err, corefile)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("generated Corefile has %d server blocks, want 1:\n%s", len(blocks), corefile)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(corefile, "\n") {
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return corefile, lines
+}
+
+// hasLine reports whether the Corefile has a line reading line, ignoring indentation
+func hasLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCorefileReload(t *testing.T) {
+	cfg := testConfig()
+	cfg.CoreDNSReloadInterval = 30
+	if corefile, _ := generate(t, cfg); strings.Contains(corefile, "reload") {
+		t.Errorf("reload rendered while disabled:\n%s", corefile)
+	}
+
+	cfg.CoreDNSReload = true
+	cfg.CoreDNSReloadInterval = 45
+	corefile, lines := generate(t, cfg)
+	if !hasLine(lines, "reload 45s") {
+		t.Errorf("Corefile lacks \"reload 45s\":\n%s", corefile)
+	}
+}
+
+func TestCorefileErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		consolidate time.Duration
+		pattern     string
+		stacktrace  bool
+		want        []string
+	}{
+		{"plain by default", 0, "", false, []string{"errors"}},
+		{"consolidate", time.Minute, ".* error", false, []string{"errors {", `consolidate 1m0s ".* error"`, "}"}},
+		{"stacktrace", 0, "", true, []string{"errors {", "stacktrace", "}"}},
+		{"both", 30 * time.Second, ".*", true, []string{"errors {", "stacktrace", `consolidate 30s ".*"`, "}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.CoreDNSErrorsConsolidate = tt.consolidate
+			cfg.CoreDNSErrorsPattern = tt.pattern
+			cfg.CoreDNSErrorsStacktrace = tt.stacktrace
+
+			corefile, lines := generate(t, cfg)
+			start := -1
+			for i, line := range lines {
+				if strings.HasPrefix(line, "errors") {
+					start = i
+					break
+				}
+			}
+			if start < 0 || start+len(tt.want) > len(lines) {
+				t.Fatalf("Corefile lacks the errors directive:\n%s", corefile)
+			}
+			if got := lines[start : start+len(tt.want)]; strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("errors directive = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorefileReusePort(t *testing.T) {
+	cfg := testConfig()
+	if corefile, _ := generate(t, cfg); strings.Contains(corefile, "multisocket") {
+		t.Errorf("multisocket rendered while disabled:\n%s", corefile)
+	}
+
+	// Without a socket count CoreDNS starts GOMAXPROCS servers
+	cfg.DNSReusePort = true
+	if corefile, lines := generate(t, cfg); !hasLine(lines, "multisocket") {
+		t.Errorf("Corefile lacks a bare multisocket directive:\n%s", corefile)
+	}
+
+	cfg.DNSSockets = 4
+	if corefile, lines := generate(t, cfg); !hasLine(lines, "multisocket 4") {
+		t.Errorf("Corefile lacks \"multisocket 4\":\n%s", corefile)
+	}
+}
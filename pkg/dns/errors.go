@@ -0,0 +1,41 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors that callers can match with errors.Is
+var (
+	// ErrRecordNotFound is returned when a record does not exist
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrInvalidRecord is returned when a record is missing required fields or is otherwise malformed
+	ErrInvalidRecord = errors.New("invalid record")
+	// ErrInvalidValue is returned when a record value does not match its type
+	ErrInvalidValue = errors.New("invalid record value")
+	// ErrUnsupportedType is returned for record types this package does not handle
+	ErrUnsupportedType = errors.New("unsupported record type")
+	// ErrImmutableRecord is returned when changing or deleting a read-only seed record
+	ErrImmutableRecord = errors.New("immutable record")
+)
+
+// Error is an error with a detailed message that matches one of the sentinel errors
+type Error struct {
+	Kind error
+	Msg  string
+}
+
+// Error returns the detailed message
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Unwrap returns the sentinel error so errors.Is can match it
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Errorf creates an Error of the given kind with a formatted message
+func Errorf(kind error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
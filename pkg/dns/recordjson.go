@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// recordFields is the Record type without its JSON methods
+type recordFields Record
+
+// knownFields holds the lowercased JSON names of Record's fields. Field names
+// are matched case-insensitively when decoding, so extra fields are too.
+var knownFields = func() map[string]bool {
+	known := make(map[string]bool)
+	t := reflect.TypeOf(recordFields{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[strings.ToLower(name)] = true
+		}
+	}
+	return known
+}()
+
+// UnmarshalJSON decodes a record and keeps fields it does not know in Extra
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, (*recordFields)(r)); err != nil {
+		return err
+	}
+
+	r.Extra = nil
+	for key, value := range fields {
+		if knownFields[strings.ToLower(key)] {
+			continue
+		}
+		if r.Extra == nil {
+			r.Extra = make(map[string]json.RawMessage)
+		}
+		r.Extra[key] = value
+	}
+	return nil
+}
+
+// MarshalJSON encodes a record followed by its extra fields in key order.
+// Extra fields named like a known field are left out.
+func (r Record) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(recordFields(r))
+	if err != nil || len(r.Extra) == 0 {
+		return data, err
+	}
+
+	keys := make([]string, 0, len(r.Extra))
+	for key := range r.Extra {
+		if !knownFields[strings.ToLower(key)] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1])
+	for _, key := range keys {
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		var value bytes.Buffer
+		if err := json.Compact(&value, r.Extra[key]); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(value.Bytes())
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
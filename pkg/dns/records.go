@@ -1,9 +1,11 @@
 package dns
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
+	"time"
 )
 
 // RecordType represents the type of DNS record
@@ -12,49 +14,425 @@ type RecordType string
 const (
 	RecordTypeA     RecordType = "A"
 	RecordTypeCNAME RecordType = "CNAME"
+	RecordTypeTXT   RecordType = "TXT"
 )
 
-// Record represents a DNS record
+// SupportedRecordTypes lists every record type that can be stored
+var SupportedRecordTypes = []RecordType{RecordTypeA, RecordTypeCNAME, RecordTypeTXT}
+
+// QueryTypeANY stands for ANY queries in a record's AllowedTypes. It is not a
+// record type and cannot be stored as one.
+const QueryTypeANY RecordType = "ANY"
+
+// ParseRecordType returns the record type named by s, ignoring case, or an
+// ErrUnsupportedType error listing the supported types
+func ParseRecordType(s string) (RecordType, error) {
+	recordType := RecordType(strings.ToUpper(strings.TrimSpace(s)))
+	if !recordType.IsValid() {
+		return "", Errorf(ErrUnsupportedType, "unsupported record type: %q (supported: %s)", s, supportedTypeList())
+	}
+	return recordType, nil
+}
+
+// IsValid reports whether t is one of the supported record types
+func (t RecordType) IsValid() bool {
+	for _, supported := range SupportedRecordTypes {
+		if t == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON rejects unsupported record types while decoding. An empty type
+// is left for Validate to report, as is ANY, which only AllowedTypes accepts.
+func (t *RecordType) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("record type must be a string: %w", err)
+	}
+	if s == "" {
+		*t = ""
+		return nil
+	}
+	if strings.EqualFold(strings.TrimSpace(s), string(QueryTypeANY)) {
+		*t = QueryTypeANY
+		return nil
+	}
+
+	recordType, err := ParseRecordType(s)
+	if err != nil {
+		return err
+	}
+	*t = recordType
+	return nil
+}
+
+// supportedTypeList returns the supported record types as a comma-separated list
+func supportedTypeList() string {
+	names := make([]string, len(SupportedRecordTypes))
+	for i, recordType := range SupportedRecordTypes {
+		names[i] = string(recordType)
+	}
+	return strings.Join(names, ", ")
+}
+
+// MaxTXTLength bounds TXT values; long values such as DKIM keys are served as
+// several 255-byte strings in one TXT record
+const MaxTXTLength = 4096
+
+// MaxNameLength is the longest domain name the DNS protocol allows, in bytes
+// without the trailing dot
+const MaxNameLength = 253
+
+// Limits bounds the length of record names and values. Operators of shared
+// instances can tighten them below the protocol maxima; zero means the maximum.
+type Limits struct {
+	// MaxNameLength bounds record names and aliases
+	MaxNameLength int
+	// MaxValueLength bounds every value, including view values
+	MaxValueLength int
+}
+
+// DefaultLimits are the protocol maxima
+var DefaultLimits = Limits{MaxNameLength: MaxNameLength, MaxValueLength: MaxTXTLength}
+
+// SplitTXT splits a TXT value into the 255-byte character strings DNS allows
+func SplitTXT(value string) []string {
+	const maxChunk = 255
+
+	chunks := make([]string, 0, len(value)/maxChunk+1)
+	for len(value) > maxChunk {
+		chunks = append(chunks, value[:maxChunk])
+		value = value[maxChunk:]
+	}
+	return append(chunks, value)
+}
+
+// Record represents a DNS record. Multi-value A records list every address in
+// Values, in answer order, and Value mirrors the first of them.
 type Record struct {
-	Name   string     `json:"name"`
-	Domain string     `json:"domain"`
-	Type   RecordType `json:"type"`
-	Value  string     `json:"value"`
-	TTL    uint32     `json:"ttl,omitempty"`
+	Name      string     `json:"name"`
+	Domain    string     `json:"domain"`
+	Type      RecordType `json:"type"`
+	Value     string     `json:"value"`
+	Values    []string   `json:"values,omitempty"`
+	TTL       uint32     `json:"ttl,omitempty"`
+	Views     []View     `json:"views,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// ExpiresIn is a write-only convenience that is converted to ExpiresAt when the record is stored
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+	// Aliases are further names in the record's domain that are answered
+	// with this record, so many names can share one value
+	Aliases []string `json:"aliases,omitempty"`
+	// AllowedTypes restricts the query types the record answers, e.g. ["A"]
+	// keeps an A record out of ANY answers. Other queries get NODATA. Empty
+	// means every query type the record can answer.
+	AllowedTypes []RecordType `json:"allowed_types,omitempty"`
+	// Extra holds JSON fields this version does not know, such as annotations
+	// added by other tools. They are stored and returned unchanged and play no
+	// part in validation or serving.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// View is a client-dependent value: clients whose address falls within CIDR
+// receive Value instead of the record's default value. For A records, Values
+// may list several addresses that replace the record's values, e.g. to steer
+// each region's peers to the addresses closest to them.
+type View struct {
+	CIDR   string   `json:"cidr"`
+	Value  string   `json:"value"`
+	Values []string `json:"values,omitempty"`
+}
+
+// TrimDot strips the trailing dot from a fully qualified name so "example.com."
+// and "example.com" refer to the same record
+func TrimDot(name string) string {
+	return strings.TrimSuffix(name, ".")
 }
 
-// Validate checks if a record is valid
+// Normalize uppercases the record type and strips trailing dots from the
+// record's domain, name and CNAME targets
+func (r *Record) Normalize() {
+	r.Domain = TrimDot(r.Domain)
+	r.Name = TrimDot(r.Name)
+	for i := range r.Aliases {
+		r.Aliases[i] = TrimDot(r.Aliases[i])
+	}
+	r.Type = RecordType(strings.ToUpper(string(r.Type)))
+	for i := range r.AllowedTypes {
+		r.AllowedTypes[i] = RecordType(strings.ToUpper(string(r.AllowedTypes[i])))
+	}
+
+	// The first of several values is the record's primary value
+	if len(r.Values) > 0 {
+		r.Value = r.Values[0]
+	}
+	for i := range r.Views {
+		if len(r.Views[i].Values) > 0 {
+			r.Views[i].Value = r.Views[i].Values[0]
+		}
+	}
+
+	if r.Type == RecordTypeCNAME {
+		r.Value = TrimDot(r.Value)
+		for i := range r.Views {
+			r.Views[i].Value = TrimDot(r.Views[i].Value)
+		}
+	}
+}
+
+// DuplicateValues returns the values listed more than once in Values or in the
+// Values of a single view, in the order they repeat
+func (r *Record) DuplicateValues() []string {
+	duplicates := repeatedValues(r.Values)
+	for _, view := range r.Views {
+		duplicates = append(duplicates, repeatedValues(view.Values)...)
+	}
+	return duplicates
+}
+
+// DedupValues removes repeated entries from Values and from the Values of every
+// view, keeping the first occurrence so the answer order is unchanged
+func (r *Record) DedupValues() {
+	r.Values = uniqueValues(r.Values)
+	for i := range r.Views {
+		r.Views[i].Values = uniqueValues(r.Views[i].Values)
+	}
+}
+
+// repeatedValues returns each value that occurs more than once in values
+func repeatedValues(values []string) []string {
+	var repeated []string
+	seen := make(map[string]int, len(values))
+	for _, value := range values {
+		seen[value]++
+		if seen[value] == 2 {
+			repeated = append(repeated, value)
+		}
+	}
+	return repeated
+}
+
+// uniqueValues returns values without repeats, keeping the first occurrence
+func uniqueValues(values []string) []string {
+	if len(values) < 2 {
+		return values
+	}
+	unique := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// Validate checks if a record is valid within the protocol limits
 func (r *Record) Validate() error {
+	return r.ValidateWithin(DefaultLimits)
+}
+
+// ValidateWithin checks if a record is valid and its names and values fit limits
+func (r *Record) ValidateWithin(limits Limits) error {
+	if limits.MaxNameLength <= 0 {
+		limits.MaxNameLength = MaxNameLength
+	}
+	if limits.MaxValueLength <= 0 {
+		limits.MaxValueLength = MaxTXTLength
+	}
+
 	// Name can be empty for root domain records (represented as "" or "@")
 	// Empty name is allowed - it represents the root domain itself
 	if r.Domain == "" {
-		return fmt.Errorf("record domain cannot be empty")
+		return Errorf(ErrInvalidRecord, "record domain cannot be empty")
 	}
 	if r.Type == "" {
-		return fmt.Errorf("record type cannot be empty")
+		return Errorf(ErrInvalidRecord, "record type cannot be empty")
+	}
+	if _, err := ParseRecordType(string(r.Type)); err != nil {
+		return err
 	}
 	if r.Value == "" {
-		return fmt.Errorf("record value cannot be empty")
+		return Errorf(ErrInvalidValue, "record value cannot be empty")
+	}
+	if r.ExpiresIn < 0 {
+		return Errorf(ErrInvalidRecord, "record expires_in cannot be negative")
+	}
+	if len(r.Name) > limits.MaxNameLength {
+		return Errorf(ErrInvalidRecord, "record name is %d bytes, exceeding the limit of %d", len(r.Name), limits.MaxNameLength)
+	}
+
+	if err := r.validateValue(r.Value, limits); err != nil {
+		return err
+	}
+
+	seenAliases := make(map[string]bool, len(r.Aliases))
+	for _, alias := range r.Aliases {
+		switch {
+		case alias == "" || alias == "@":
+			return Errorf(ErrInvalidRecord, "alias cannot be empty or @")
+		case strings.Contains(alias, "."):
+			return Errorf(ErrInvalidRecord, "alias must be a single label: %s", alias)
+		case alias == r.Name:
+			return Errorf(ErrInvalidRecord, "alias cannot repeat the record name: %s", alias)
+		case seenAliases[alias]:
+			return Errorf(ErrInvalidRecord, "duplicate alias: %s", alias)
+		case len(alias) > limits.MaxNameLength:
+			return Errorf(ErrInvalidRecord, "alias is %d bytes, exceeding the limit of %d", len(alias), limits.MaxNameLength)
+		}
+		seenAliases[alias] = true
+	}
+
+	if err := r.validateAllowedTypes(); err != nil {
+		return err
+	}
+
+	if len(r.Values) > 0 && r.Type != RecordTypeA {
+		return Errorf(ErrInvalidValue, "multiple values are only supported for A records")
+	}
+	for i, value := range r.Values {
+		if err := r.validateValue(value, limits); err != nil {
+			return fmt.Errorf("value %d: %w", i, err)
+		}
+	}
+
+	for i, view := range r.Views {
+		if _, _, err := net.ParseCIDR(view.CIDR); err != nil {
+			return Errorf(ErrInvalidValue, "invalid CIDR in view %d: %s", i, view.CIDR)
+		}
+		if view.Value == "" {
+			return Errorf(ErrInvalidValue, "view %d value cannot be empty", i)
+		}
+		if err := r.validateValue(view.Value, limits); err != nil {
+			return fmt.Errorf("view %d: %w", i, err)
+		}
+		if len(view.Values) > 0 && r.Type != RecordTypeA {
+			return Errorf(ErrInvalidValue, "view %d: multiple values are only supported for A records", i)
+		}
+		for j, value := range view.Values {
+			if err := r.validateValue(value, limits); err != nil {
+				return fmt.Errorf("view %d value %d: %w", i, j, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateAllowedTypes checks that every allowed query type is one the record
+// can answer: a CNAME answers every query type at its name, other records
+// only queries for their own type and ANY
+func (r *Record) validateAllowedTypes() error {
+	seen := make(map[RecordType]bool, len(r.AllowedTypes))
+	for _, allowed := range r.AllowedTypes {
+		switch {
+		case allowed != QueryTypeANY && !allowed.IsValid():
+			return Errorf(ErrInvalidRecord, "unsupported allowed type: %q (supported: %s, %s)", allowed, supportedTypeList(), QueryTypeANY)
+		case r.Type != RecordTypeCNAME && allowed != r.Type && allowed != QueryTypeANY:
+			return Errorf(ErrInvalidRecord, "a %s record never answers %s queries", r.Type, allowed)
+		case seen[allowed]:
+			return Errorf(ErrInvalidRecord, "duplicate allowed type: %s", allowed)
+		}
+		seen[allowed] = true
+	}
+	return nil
+}
+
+// validateValue checks that value is valid for the record's type and fits limits
+func (r *Record) validateValue(value string, limits Limits) error {
+	if len(value) > limits.MaxValueLength {
+		return Errorf(ErrInvalidValue, "record value is %d bytes, exceeding the limit of %d", len(value), limits.MaxValueLength)
 	}
 
-	// Validate based on type
 	switch r.Type {
 	case RecordTypeA:
-		if ip := net.ParseIP(r.Value); ip == nil || ip.To4() == nil {
-			return fmt.Errorf("invalid IPv4 address: %s", r.Value)
+		if ip := net.ParseIP(value); ip == nil || ip.To4() == nil {
+			return Errorf(ErrInvalidValue, "invalid IPv4 address: %s", value)
 		}
 	case RecordTypeCNAME:
 		// CNAME value should be a valid domain name
-		if !isValidDomain(r.Value) {
-			return fmt.Errorf("invalid CNAME target: %s", r.Value)
+		if !IsValidDomain(value) {
+			return Errorf(ErrInvalidValue, "invalid CNAME target: %s", value)
+		}
+	case RecordTypeTXT:
+		if len(value) > MaxTXTLength {
+			return Errorf(ErrInvalidValue, "TXT value exceeds %d bytes", MaxTXTLength)
 		}
 	default:
-		return fmt.Errorf("unsupported record type: %s", r.Type)
+		return Errorf(ErrUnsupportedType, "unsupported record type: %s", r.Type)
 	}
 
 	return nil
 }
 
+// ValueFor returns the value to serve to a client. The view with the most
+// specific CIDR containing clientIP wins; otherwise the default value is used.
+func (r *Record) ValueFor(clientIP net.IP) string {
+	if view, ok := r.viewFor(clientIP); ok {
+		return view.Value
+	}
+	return r.Value
+}
+
+// ValuesFor returns every value to serve to a client in answer order. A
+// matching view replaces the record's values with its own.
+func (r *Record) ValuesFor(clientIP net.IP) []string {
+	if view, ok := r.viewFor(clientIP); ok {
+		if len(view.Values) > 0 {
+			return view.Values
+		}
+		return []string{view.Value}
+	}
+	if len(r.Values) > 0 {
+		return r.Values
+	}
+	return []string{r.Value}
+}
+
+// viewFor returns the view with the most specific CIDR containing clientIP
+func (r *Record) viewFor(clientIP net.IP) (View, bool) {
+	if clientIP == nil || len(r.Views) == 0 {
+		return View{}, false
+	}
+
+	var best View
+	bestPrefix := -1
+	for _, view := range r.Views {
+		_, network, err := net.ParseCIDR(view.CIDR)
+		if err != nil || !network.Contains(clientIP) {
+			continue
+		}
+		if ones, _ := network.Mask.Size(); ones > bestPrefix {
+			bestPrefix = ones
+			best = view
+		}
+	}
+
+	return best, bestPrefix >= 0
+}
+
+// AllowsQuery reports whether the record may answer a query of queryType, the
+// query type's name such as "A" or "ANY"
+func (r *Record) AllowsQuery(queryType RecordType) bool {
+	if len(r.AllowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range r.AllowedTypes {
+		if allowed == queryType {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the record has an expiry time that has passed
+func (r *Record) IsExpired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
 // FQDN returns the fully qualified domain name for this record
 func (r *Record) FQDN() string {
 	// For root domain records (empty name), return just the domain
@@ -64,8 +442,8 @@ func (r *Record) FQDN() string {
 	return fmt.Sprintf("%s.%s.", r.Name, r.Domain)
 }
 
-// isValidDomain checks if a string is a valid domain name
-func isValidDomain(domain string) bool {
+// IsValidDomain checks if a string is a valid domain name
+func IsValidDomain(domain string) bool {
 	domain = strings.TrimSuffix(domain, ".")
 	if len(domain) == 0 || len(domain) > 253 {
 		return false
@@ -73,20 +451,29 @@ func isValidDomain(domain string) bool {
 
 	labels := strings.Split(domain, ".")
 	for _, label := range labels {
-		if len(label) == 0 || len(label) > 63 {
+		if !IsValidLabel(label) {
 			return false
 		}
-		// Check if label contains only valid characters
-		for _, c := range label {
-			if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-') {
-				return false
-			}
-		}
-		// Label cannot start or end with hyphen
-		if label[0] == '-' || label[len(label)-1] == '-' {
+	}
+
+	return true
+}
+
+// IsValidLabel checks if a string is a valid DNS label: 1-63 letters, digits
+// or hyphens, not starting or ending with a hyphen
+func IsValidLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 {
+		return false
+	}
+	// Check if label contains only valid characters
+	for _, c := range label {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-') {
 			return false
 		}
 	}
-
+	// Label cannot start or end with hyphen
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
 	return true
 }
@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseRecordType(t *testing.T) {
+	tests := []struct {
+		input string
+		want  RecordType
+	}{
+		{"A", RecordTypeA},
+		{"a", RecordTypeA},
+		{"cname", RecordTypeCNAME},
+		{"CName", RecordTypeCNAME},
+		{" txt ", RecordTypeTXT},
+	}
+	for _, tt := range tests {
+		got, err := ParseRecordType(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRecordType(%q) = %q, %v, want %q", tt.input, got, err, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "mx", "any"} {
+		if _, err := ParseRecordType(input); !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("ParseRecordType(%q) error = %v, want ErrUnsupportedType", input, err)
+		}
+	}
+}
+
+func TestLowercaseTypesDecode(t *testing.T) {
+	data := `{"name": "www", "domain": "example.com", "type": "cname", "value": "web.example.com."}`
+
+	var record Record
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		t.Fatalf("decoding a lowercase type failed: %v", err)
+	}
+	if record.Type != RecordTypeCNAME {
+		t.Errorf("Type = %q, want %q", record.Type, RecordTypeCNAME)
+	}
+	if err := record.Validate(); err != nil {
+		t.Errorf("Validate() of a decoded lowercase record failed: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"name": "web", "domain": "example.com", "type": "mx", "value": "mail"}`), &record); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("decoding an unsupported type error = %v, want ErrUnsupportedType", err)
+	}
+}
+
+func TestNormalizeUppercasesTypes(t *testing.T) {
+	record := &Record{Name: "web.", Domain: "example.com.", Type: "a", Value: "100.64.0.10"}
+	record.Normalize()
+	if record.Type != RecordTypeA {
+		t.Errorf("Normalize() left type %q, want A", record.Type)
+	}
+	if err := record.Validate(); err != nil {
+		t.Errorf("Validate() of a normalized record failed: %v", err)
+	}
+}
+
+func TestValidateAllowedTypes(t *testing.T) {
+	tests := []struct {
+		recordType RecordType
+		allowed    []RecordType
+		valid      bool
+	}{
+		{RecordTypeA, []RecordType{RecordTypeA}, true},
+		{RecordTypeA, []RecordType{RecordTypeA, QueryTypeANY}, true},
+		{RecordTypeA, []RecordType{RecordTypeTXT}, false},
+		{RecordTypeA, []RecordType{RecordTypeA, RecordTypeA}, false},
+		{RecordTypeA, []RecordType{"MX"}, false},
+		{RecordTypeCNAME, []RecordType{RecordTypeA, RecordTypeTXT}, true},
+	}
+	for _, tt := range tests {
+		record := &Record{Name: "web", Domain: "example.com", Type: tt.recordType, Value: "100.64.0.10", AllowedTypes: tt.allowed}
+		if tt.recordType == RecordTypeCNAME {
+			record.Value = "other.example.com"
+		}
+		if err := record.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate() of a %s record allowing %v = %v, want valid %v", tt.recordType, tt.allowed, err, tt.valid)
+		}
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	tests := []struct {
+		length int
+		chunks []int
+	}{
+		{0, []int{0}},
+		{10, []int{10}},
+		{255, []int{255}},
+		{256, []int{255, 1}},
+		{400, []int{255, 145}},
+		{510, []int{255, 255}},
+		{MaxTXTLength, []int{255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 16}},
+	}
+	for _, tt := range tests {
+		value := strings.Repeat("k", tt.length)
+		chunks := SplitTXT(value)
+
+		lengths := make([]int, len(chunks))
+		for i, chunk := range chunks {
+			lengths[i] = len(chunk)
+		}
+		if len(lengths) != len(tt.chunks) {
+			t.Errorf("SplitTXT() of %d bytes gave chunks of %v, want %v", tt.length, lengths, tt.chunks)
+			continue
+		}
+		for i := range lengths {
+			if lengths[i] != tt.chunks[i] {
+				t.Errorf("SplitTXT() of %d bytes gave chunks of %v, want %v", tt.length, lengths, tt.chunks)
+				break
+			}
+		}
+		if joined := strings.Join(chunks, ""); joined != value {
+			t.Errorf("SplitTXT() of %d bytes does not join back to the value", tt.length)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// RecordSet holds the records stored under one name, at most one per type.
+// A CNAME cannot share its name with records of any other type.
+//
+// A set with a single record is encoded as that record's JSON object, keeping
+// API responses for single-type names unchanged, and sets with several records
+// as an array. Both forms are accepted when decoding, which also reads records
+// files written before multiple types were supported.
+type RecordSet []*Record
+
+// Get returns the record of the given type, or nil if the set has none
+func (rs RecordSet) Get(recordType RecordType) *Record {
+	for _, record := range rs {
+		if record.Type == recordType {
+			return record
+		}
+	}
+	return nil
+}
+
+// CheckConflict returns an error if a record of recordType cannot be added to
+// the set because of the CNAME exclusivity rule
+func (rs RecordSet) CheckConflict(recordType RecordType) error {
+	for _, record := range rs {
+		if record.Type == recordType {
+			continue
+		}
+		if recordType == RecordTypeCNAME {
+			return Errorf(ErrInvalidRecord, "CNAME record cannot coexist with the %s record of the same name", record.Type)
+		}
+		if record.Type == RecordTypeCNAME {
+			return Errorf(ErrInvalidRecord, "%s record cannot coexist with the CNAME record of the same name", recordType)
+		}
+	}
+	return nil
+}
+
+// Put returns a new set with record added, replacing any record of the same
+// type, and reports whether one was replaced. The receiver is not modified,
+// so sets can be shared with concurrent readers.
+func (rs RecordSet) Put(record *Record) (RecordSet, bool) {
+	result := make(RecordSet, 0, len(rs)+1)
+	replaced := false
+	for _, existing := range rs {
+		if existing.Type == record.Type {
+			result = append(result, record)
+			replaced = true
+			continue
+		}
+		result = append(result, existing)
+	}
+	if !replaced {
+		result = append(result, record)
+	}
+	return result, replaced
+}
+
+// Without returns a new set without the records for which drop returns true.
+// The receiver is not modified.
+func (rs RecordSet) Without(drop func(*Record) bool) RecordSet {
+	result := make(RecordSet, 0, len(rs))
+	for _, record := range rs {
+		if !drop(record) {
+			result = append(result, record)
+		}
+	}
+	return result
+}
+
+// MarshalJSON encodes a single record as an object and several as an array
+func (rs RecordSet) MarshalJSON() ([]byte, error) {
+	if len(rs) == 1 {
+		return json.Marshal(rs[0])
+	}
+	return json.Marshal([]*Record(rs))
+}
+
+// UnmarshalJSON accepts either a single record object or an array of records
+func (rs *RecordSet) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var records []*Record
+		if err := json.Unmarshal(data, &records); err != nil {
+			return err
+		}
+		*rs = records
+		return nil
+	}
+
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	*rs = RecordSet{&record}
+	return nil
+}